@@ -3,11 +3,11 @@ module github.com/Banyango/io-engine
 go 1.12
 
 require (
-	github.com/Banyango/socker v0.0.0-20190621174031-766264881e1b
 	github.com/SolarLune/resolv v0.0.0-20190326155406-6053e4e6907a
 	github.com/goburrow/dynamic v0.1.0
 	github.com/gorilla/websocket v1.4.0
 	github.com/lucasb-eyer/go-colorful v1.0.2
 	github.com/pion/webrtc/v2 v2.0.23
+	github.com/stretchr/testify v1.3.0
 	github.com/thoas/go-funk v0.4.0
 )
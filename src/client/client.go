@@ -2,6 +2,7 @@ package client
 
 import (
 	"encoding/json"
+	"fmt"
 	"github.com/Banyango/io-engine/src/ecs"
 	"github.com/Banyango/io-engine/src/server"
 	"github.com/thoas/go-funk"
@@ -32,6 +33,8 @@ func (self *Client) HandleWorldStatePacket(packet *server.WorldState, world *ecs
 
 		world.LastServerTick = packet.Tick
 
+		mispredicted := self.confirmPeerInputs(packet, world)
+
 		if len(packet.Created) > 0 || len(packet.Destroyed) > 0 {
 			resimulateRequired = true
 		} else {
@@ -46,7 +49,7 @@ func (self *Client) HandleWorldStatePacket(packet *server.WorldState, world *ecs
 			}
 		}
 
-		if resimulateRequired {
+		if resimulateRequired || mispredicted {
 			//log("Loop {resimulating}")
 			if world.CurrentTick-packet.Tick > ecs.MAX_CACHE_SIZE {
 				log("skipping packet")
@@ -55,6 +58,7 @@ func (self *Client) HandleWorldStatePacket(packet *server.WorldState, world *ecs
 
 			//log("Resetting to tick", packet.Tick, " from:", world.CurrentTick)
 			world.ResetToTick(packet.Tick)
+			world.Rand.State = packet.RandState
 
 			//log("Creating entities...")
 			self.createEntities(packet, world)
@@ -96,6 +100,35 @@ func (self *Client) HandleWorldStatePacket(packet *server.WorldState, world *ecs
 	}
 }
 
+// Stores the inputs of the other players and forgets the ones that left. Returns true if an input
+// that already ran was predicted wrong.
+func (self *Client) confirmPeerInputs(packet *server.WorldState, world *ecs.World) bool {
+	mispredicted := false
+	peers := map[ecs.PlayerId]bool{}
+
+	for _, playerInputs := range packet.Inputs {
+		if playerInputs.PlayerId == self.PlayerId {
+			continue
+		}
+
+		peers[playerInputs.PlayerId] = true
+
+		for i, input := range playerInputs.Inputs {
+			if world.ConfirmRemoteInput(playerInputs.PlayerId, packet.Tick+int64(i), input) {
+				mispredicted = true
+			}
+		}
+	}
+
+	for id := range world.Input.Remote {
+		if !peers[id] {
+			world.RemoveRemoteInput(id)
+		}
+	}
+
+	return mispredicted
+}
+
 func (self *Client) findEntityIdInStorageForNetworkPacket(NetworkInstances *ecs.Storage, data *server.NetworkData) int64 {
 	for i := range NetworkInstances.Components {
 		net := (*NetworkInstances.Components[i]).(*server.NetworkInstanceComponent)
@@ -139,11 +172,11 @@ func (self *Client) createEntities(packet *server.WorldState, world *ecs.World)
 }
 
 func (self *Client) createEntity(data *server.NetworkData, world *ecs.World) {
-	log("Creating entity ", data.PrefabId, " owner ", int(data.OwnerId), "network id", data.NetworkId)
+	log("Creating entity ", int(data.NetworkPrefabId), " owner ", int(data.OwnerId), "network id", data.NetworkId)
 	isPeer := self.PlayerId != data.OwnerId
 	entity := *data.DeserializeNewEntity(world, isPeer)
 	entity.Id = world.FetchAndIncrementId()
-	component := server.NetworkInstanceComponent{NetworkId: data.NetworkId, OwnerId: data.OwnerId, PrefabId: int(data.PrefabId)}
+	component := server.NetworkInstanceComponent{NetworkId: data.NetworkId, OwnerId: data.OwnerId, NetworkPrefabId: data.NetworkPrefabId, Overrides: data.Overrides}
 	entity.Components[int(ecs.NetworkInstanceComponentType)] = &component
 	world.Log.LogJson("entityId", entity)
 	world.AddEntityToWorld(entity)
@@ -172,10 +205,35 @@ func (self *Client) HandleResync(packet *server.WorldState, world *ecs.World) {
 
 }
 
+// Called with the first websocket message from the server. Returns an error if the server
+// has another version of the game data, the client can't play with it.
+func (self *Client) HandleHandshake(handshake server.ServerConnectionHandshakePacket, world *ecs.World) error {
+	if world.PrefabData != nil && world.PrefabData.Version != handshake.Version {
+		return fmt.Errorf("game data version %q doesn't match the server's %q", world.PrefabData.Version, handshake.Version)
+	}
+
+	self.PlayerId = handshake.PlayerId
+	// the server's generator has moved on since it was seeded, this is where it is now.
+	world.Seed(handshake.RandState)
+
+	return nil
+}
+
+// Called when the server pushes reloaded game data.
+func (self *Client) HandleGameData(packet server.GameDataPacket, world *ecs.World) error {
+	prefabData, err := ecs.NewPrefabManager(packet.GameData, world)
+
+	if err != nil {
+		return err
+	}
+
+	world.ReloadPrefabs(prefabData, packet.Patch)
+
+	return nil
+}
+
 func (self *Client) HandleRTT(rtt *server.RoundTripTime) {
 	if rtt != nil && rtt.SentTimeServer != 0 {
-		self.PlayerId = rtt.PlayerId
-
 		sentTime := time.Unix(0, rtt.RecTime).Sub(time.Unix(0, rtt.SentTimeClient))
 		recTime := time.Now().Sub(time.Unix(0, rtt.SentTimeServer))
 
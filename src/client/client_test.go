@@ -221,7 +221,7 @@ func TestRunClientUpdateAndCreateWithCacheAndInput(t *testing.T) {
 	world, client, storage := createWorld()
 
 	// create
-	data := server.NetworkData{OwnerId: 0, NetworkId: 0, PrefabId: 0, Data: map[int][]byte{}}
+	data := server.NetworkData{OwnerId: 0, NetworkId: 0, NetworkPrefabId: 0, Data: map[int][]byte{}}
 	component := game.PositionComponent{Position: math.NewVectorInt(0, 0)}
 	component.WriteUDP(&data)
 
@@ -279,7 +279,7 @@ func TestRunClientFromRealTest(t *testing.T) {
 	assert.Equal(t, int64(1248), world.CurrentTick)
 
 	bytes, _ := base64.StdEncoding.DecodeString("GP+NAwEC/44AAQIBAVgBBAABAVkBBAAAAAP/jgA=")
-	data := server.NetworkData{OwnerId: 0, NetworkId: 0, PrefabId: 0, Data: map[int][]byte{0: bytes}}
+	data := server.NetworkData{OwnerId: 0, NetworkId: 0, NetworkPrefabId: 0, Data: map[int][]byte{0: bytes}}
 
 	packet.Created = append(packet.Created, &data)
 
@@ -304,12 +304,12 @@ func TestRunClientFromRealTestBothCreateAndUpdate(t *testing.T) {
 	assert.Equal(t, int64(1248), world.CurrentTick)
 
 	bytes, _ := base64.StdEncoding.DecodeString("GP+NAwEC/44AAQIBAVgBBAABAVkBBAAAAAP/jgA=")
-	data := server.NetworkData{OwnerId: 0, NetworkId: 0, PrefabId: 0, Data: map[int][]byte{0: bytes}}
+	data := server.NetworkData{OwnerId: 0, NetworkId: 0, NetworkPrefabId: 0, Data: map[int][]byte{0: bytes}}
 
 	packet.Created = append(packet.Created, &data)
 
 	bytes2, _ := base64.StdEncoding.DecodeString("GP+NAwEC/44AAQIBAVgBBAABAVkBBAAAAAP/jgA=")
-	data2 := server.NetworkData{OwnerId: 0, NetworkId: 0, PrefabId: 0, Data: map[int][]byte{0: bytes2}}
+	data2 := server.NetworkData{OwnerId: 0, NetworkId: 0, NetworkPrefabId: 0, Data: map[int][]byte{0: bytes2}}
 
 	packet.Updates = append(packet.Updates, &data2)
 
@@ -318,6 +318,21 @@ func TestRunClientFromRealTestBothCreateAndUpdate(t *testing.T) {
 	assert.Equal(t, 1, len(world.Entities))
 }
 
+func TestHandleHandshakeVersionMismatch(t *testing.T) {
+
+	worldClient, handlerClient, _ := createWorld()
+	worldClient.PrefabData.Version = "1.0.0"
+
+	err := handlerClient.HandleHandshake(server.ServerConnectionHandshakePacket{PlayerId: 3, Version: "1.1.0"}, worldClient)
+
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "1.1.0")
+	assert.Equal(t, ecs.PlayerId(0), handlerClient.PlayerId)
+
+	assert.Nil(t, handlerClient.HandleHandshake(server.ServerConnectionHandshakePacket{PlayerId: 3, Version: "1.0.0"}, worldClient))
+	assert.Equal(t, ecs.PlayerId(3), handlerClient.PlayerId)
+}
+
 func TestRunClientFromRealTestMoreComplete(t *testing.T) {
 
 	worldClient, handlerClient, clientStorage := createWorld()
@@ -342,7 +357,7 @@ func TestRunClientFromRealTestMoreComplete(t *testing.T) {
 	worldServer.AddEntityToWorld(entity)
 	worldServer.Input.Player[0] = ecs.NewInput()
 
-	handlerClient.HandleHandshake(server.ServerConnectionHandshakePacket{PlayerId:0, RountTripClientTime:worldServer.CurrentTick}, worldClient)
+	assert.Nil(t, handlerClient.HandleHandshake(server.ServerConnectionHandshakePacket{PlayerId: 0, Tick: worldServer.CurrentTick, RandState: worldServer.Rand.State, Version: worldServer.PrefabData.Version}, worldClient))
 
 	for i := 0; i < 3; i++ {
 		worldServer.Update(0.016)
@@ -374,9 +389,9 @@ func TestRunClientFromRealTestMoreComplete(t *testing.T) {
 	worldClient.Update(0.016)
 	worldServer.Update(0.016)
 
-	worldServer.SetFutureInput(tickInputDown, bytesInputDown[0], 0)
+	worldServer.SetFutureInput(tickInputDown, ecs.NetworkInput{Buttons: bytesInputDown}, 0)
 
 	worldClient.Update(0.016)
 	worldServer.Update(0.016)
-	worldServer.SetFutureInput(tickInputDown+1, bytesInputDown[0], 0)
+	worldServer.SetFutureInput(tickInputDown+1, ecs.NetworkInput{Buttons: bytesInputDown}, 0)
 }
@@ -1,30 +1,33 @@
 package client
 
 import (
-	"github.com/goburrow/dynamic"
 	. "github.com/Banyango/io-engine/src/ecs"
 	. "github.com/Banyango/io-engine/src/game"
-	"github.com/Banyango/io-engine/src/math"
+	"github.com/Banyango/io-engine/src/server"
 )
 
+// Moves the player with the local input and the peers with their remote input, see ConfirmRemoteInput.
 type ClientMovementSystem struct {
+	Client *Client // the player moved by the local input, every entity is if nil
+
 	collisionComponents Storage
 	arcadeComponents    Storage
+	networkInstance     Storage
 }
 
 func (self *ClientMovementSystem) Init(w *World) {
-	dynamic.Register("ArcadeMovementComponent", func() interface{} {
-		return &ArcadeMovementComponent{}
-	})
+	RegisterComponents()
 
 	self.collisionComponents = NewStorage()
 	self.arcadeComponents = NewStorage()
+	self.networkInstance = NewStorage()
 }
 
 func (self *ClientMovementSystem) AddToStorage(entity *Entity) {
 	storages := map[int]*Storage{
 		int(CollisionComponentType): &self.collisionComponents,
 		int(ArcadeMovementComponentType): &self.arcadeComponents,
+		int(NetworkInstanceComponentType): &self.networkInstance,
 	}
 	AddComponentsToStorage(entity, storages)
 }
@@ -33,6 +36,7 @@ func (self *ClientMovementSystem) RemoveFromStorage(entity *Entity) {
 	storages := map[int]*Storage{
 		int(CollisionComponentType): &self.collisionComponents,
 		int(ArcadeMovementComponentType): &self.arcadeComponents,
+		int(NetworkInstanceComponentType): &self.networkInstance,
 	}
 	RemoveComponentsFromStorage(entity, storages)
 }
@@ -44,37 +48,24 @@ func (self *ClientMovementSystem) RequiredComponentTypes() []ComponentType {
 
 func (self *ClientMovementSystem) UpdateSystem(delta float64, world *World) {
 
-	global := world.Input.Player[0]
 	for entity, _ := range self.collisionComponents.Components {
 
 		arcade := (*self.arcadeComponents.Components[entity]).(*ArcadeMovementComponent)
 		collider := (*self.collisionComponents.Components[entity]).(*CollisionComponent)
 
-		direction := math.NewVector(float64(0), float64(0))
-
-		if global.AnyKeyPressed() {
-
-			if global.KeyPressed[Up] {
-				direction = direction.Add(math.VectorUp())
-			}
-
-			if global.KeyPressed[Down] {
-				direction = direction.Add(math.VectorDown())
-			}
-
-			if global.KeyPressed[Left] {
-				direction = direction.Add(math.VectorRight())
-			}
+		ApplyMovement(self.inputFor(entity, world), arcade, collider)
+	}
+}
 
-			if global.KeyPressed[Right] {
-				direction = direction.Add(math.VectorLeft())
+// The local input is at player 0 on a client, a peer with id 0 must not get it.
+func (self *ClientMovementSystem) inputFor(entity int64, world *World) *Input {
+	if self.Client != nil {
+		if instance, ok := self.networkInstance.Components[entity]; ok {
+			if net := (*instance).(*server.NetworkInstanceComponent); net.OwnerId != self.Client.PlayerId {
+				return world.RemoteInput(net.OwnerId)
 			}
-
-			collider.Velocity = collider.Velocity.Add(direction.Scale(arcade.Speed))
-
 		}
-
-
-		collider.Velocity = collider.Velocity.Add(arcade.Gravity).Scale(arcade.Drag).Clamp(arcade.MaxSpeed.Neg(), arcade.MaxSpeed)
 	}
+
+	return world.Input.Player[0]
 }
@@ -0,0 +1,180 @@
+package client
+
+import (
+	"github.com/Banyango/io-engine/src/ecs"
+	"github.com/Banyango/io-engine/src/math"
+	gomath "math"
+)
+
+/**
+Touch controls
+
+On touch screens the client shows a virtual joystick and buttons on the canvas. The joystick holds
+the direction actions it's pushed toward and moves the axes bound to the gamepad's left stick, each
+button holds its action, so systems read touches the same way as keys and gamepads.
+
+Positions are in canvas pixels, negative ones are from the right or bottom edge so the layout
+follows the canvas size. The controls are only shown once the screen has been touched.
+*/
+
+type TouchJoystick struct {
+	X, Y       float64   // center of the base
+	Radius     float64   // the stick is fully pushed at this distance
+	Directions [4]string // actions held when pushed up, down, left and right
+}
+
+type TouchButton struct {
+	Action string
+	Label  string
+	X, Y   float64
+	Radius float64
+}
+
+// A joystick direction is held when the stick is pushed further than this along it.
+const TOUCH_DIRECTION_THRESHOLD = 0.5
+
+// Touches grab the joystick this far out from its base, relative to its radius.
+const TOUCH_JOYSTICK_REACH = 1.5
+
+type TouchControls struct {
+	Joystick TouchJoystick
+	Buttons  []TouchButton
+
+	Width, Height float64 // canvas size
+	Visible       bool    // set by the first touch
+
+	joystickTouch int // touch identifier holding the joystick, -1 if none
+	stick         math.Vector
+	buttonTouches map[int]int // touch identifier to the button it's holding
+}
+
+// Joystick in the bottom left and fire buttons in the bottom right.
+func NewTouchControls() *TouchControls {
+	return &TouchControls{
+		Joystick: TouchJoystick{X: 90, Y: -90, Radius: 50, Directions: [4]string{"move_up", "move_down", "move_left", "move_right"}},
+		Buttons: []TouchButton{
+			{Action: "fire", Label: "A", X: -70, Y: -80, Radius: 34},
+			{Action: "alt_fire", Label: "B", X: -150, Y: -50, Radius: 28},
+		},
+		joystickTouch: -1,
+		buttonTouches: map[int]int{},
+	}
+}
+
+func (self *TouchControls) TouchStart(id int, x float64, y float64) {
+	self.Visible = true
+
+	position := math.NewVector(x, y)
+
+	for i := range self.Buttons {
+		if distance(position, self.ButtonCenter(i)) <= self.Buttons[i].Radius {
+			self.buttonTouches[id] = i
+			return
+		}
+	}
+
+	if self.joystickTouch == -1 && distance(position, self.JoystickCenter()) <= self.Joystick.Radius*TOUCH_JOYSTICK_REACH {
+		self.joystickTouch = id
+		self.moveStick(position)
+	}
+}
+
+func (self *TouchControls) TouchMove(id int, x float64, y float64) {
+	if id == self.joystickTouch {
+		self.moveStick(math.NewVector(x, y))
+	}
+}
+
+// Ends or cancels a touch.
+func (self *TouchControls) TouchEnd(id int) {
+	if id == self.joystickTouch {
+		self.joystickTouch = -1
+		self.stick = math.VectorZero()
+	}
+
+	delete(self.buttonTouches, id)
+}
+
+// Where the joystick is pushed, within the unit circle.
+func (self *TouchControls) Stick() math.Vector {
+	return self.stick
+}
+
+func (self *TouchControls) Held(button int) bool {
+	for _, held := range self.buttonTouches {
+		if held == button {
+			return true
+		}
+	}
+	return false
+}
+
+func (self *TouchControls) JoystickCenter() math.Vector {
+	return self.position(self.Joystick.X, self.Joystick.Y)
+}
+
+func (self *TouchControls) ButtonCenter(button int) math.Vector {
+	return self.position(self.Buttons[button].X, self.Buttons[button].Y)
+}
+
+// Adds the touches to an input resolved by the InputMap, what's held on the keyboard or a gamepad
+// stays held.
+func (self *TouchControls) Apply(input *ecs.Input, inputMap *ecs.InputMap) {
+	for id := range self.buttonTouches {
+		input.Actions[self.Buttons[self.buttonTouches[id]].Action] = true
+	}
+
+	x, y := self.stick.X(), self.stick.Y()
+
+	pushed := [4]bool{y < -TOUCH_DIRECTION_THRESHOLD, y > TOUCH_DIRECTION_THRESHOLD, x < -TOUCH_DIRECTION_THRESHOLD, x > TOUCH_DIRECTION_THRESHOLD}
+
+	for i, action := range self.Joystick.Directions {
+		if pushed[i] && action != "" {
+			input.Actions[action] = true
+		}
+	}
+
+	for _, axis := range inputMap.Axes {
+		value := 0.0
+
+		switch axis.Gamepad {
+		case 0:
+			value = x
+		case 1:
+			value = y
+		default:
+			continue
+		}
+
+		if gomath.Abs(value) > gomath.Abs(input.Axes[axis.Name]) {
+			input.Axes[axis.Name] = value
+		}
+	}
+}
+
+func (self *TouchControls) moveStick(position math.Vector) {
+	offset := position.Sub(self.JoystickCenter()).Scale(1 / self.Joystick.Radius)
+
+	if length := gomath.Hypot(offset.X(), offset.Y()); length > 1 {
+		offset = offset.Scale(1 / length)
+	}
+
+	self.stick = offset
+}
+
+func (self *TouchControls) position(x float64, y float64) math.Vector {
+	if x < 0 {
+		x += self.Width
+	}
+
+	if y < 0 {
+		y += self.Height
+	}
+
+	return math.NewVector(x, y)
+}
+
+func distance(a math.Vector, b math.Vector) float64 {
+	offset := a.Sub(b)
+	return gomath.Hypot(offset.X(), offset.Y())
+}
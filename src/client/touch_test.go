@@ -0,0 +1,90 @@
+package client
+
+import (
+	"github.com/Banyango/io-engine/src/ecs"
+	"github.com/Banyango/io-engine/src/math"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func createTouchControls() *TouchControls {
+	touch := NewTouchControls()
+	touch.Width = 600
+	touch.Height = 500
+	return touch
+}
+
+func TestTouchControls_Layout(t *testing.T) {
+	touch := createTouchControls()
+
+	assert.False(t, touch.Visible)
+	assert.Equal(t, math.NewVector(90, 410), touch.JoystickCenter())
+	assert.Equal(t, math.NewVector(530, 420), touch.ButtonCenter(0))
+}
+
+func TestTouchControls_Joystick(t *testing.T) {
+	touch := createTouchControls()
+
+	inputMap, errs := ecs.NewInputMap(ecs.InputJson{
+		Actions: []ecs.InputActionJson{{Name: "move_up"}, {Name: "move_right"}, {Name: "fire"}},
+		Axes:    []ecs.InputAxisJson{{Name: "move_x", Gamepad: new(int)}},
+	})
+	assert.Empty(t, errs)
+
+	// outside the joystick's reach.
+	touch.TouchStart(1, 300, 100)
+	assert.Equal(t, math.VectorZero(), touch.Stick())
+
+	touch.TouchStart(2, 100, 400)
+	touch.TouchMove(2, 190, 410)
+
+	assert.True(t, touch.Visible)
+	assert.Equal(t, math.NewVector(1, 0), touch.Stick())
+
+	input := ecs.NewInput()
+	inputMap.Resolve(input)
+	touch.Apply(input, inputMap)
+
+	assert.True(t, input.Action("move_right"))
+	assert.False(t, input.Action("move_up"))
+	assert.Equal(t, 1.0, input.Axis("move_x"))
+
+	touch.TouchEnd(2)
+	assert.Equal(t, math.VectorZero(), touch.Stick())
+
+	inputMap.Resolve(input)
+	touch.Apply(input, inputMap)
+
+	assert.False(t, input.Action("move_right"))
+	assert.Equal(t, 0.0, input.Axis("move_x"))
+}
+
+func TestTouchControls_Buttons(t *testing.T) {
+	touch := createTouchControls()
+
+	inputMap := ecs.DefaultInputMap()
+	input := ecs.NewInput()
+
+	touch.TouchStart(4, 525, 425)
+	touch.TouchStart(5, 85, 400)
+
+	assert.True(t, touch.Held(0))
+	assert.False(t, touch.Held(1))
+
+	// buttons don't move the joystick.
+	touch.TouchMove(4, 0, 0)
+
+	inputMap.Resolve(input)
+	touch.Apply(input, inputMap)
+
+	assert.True(t, input.Action("fire"))
+	assert.False(t, input.Action("move_left"))
+
+	touch.TouchEnd(4)
+
+	inputMap.Resolve(input)
+	touch.Apply(input, inputMap)
+
+	assert.False(t, input.Action("fire"))
+	assert.False(t, touch.Held(0))
+}
@@ -0,0 +1,290 @@
+package web
+
+import (
+	"encoding/json"
+	"github.com/Banyango/io-engine/src/server"
+	"syscall/js"
+)
+
+/**
+Browser connection
+
+The server.Connection of the browser. Reliable messages go over a websocket, the WebRTC data channel
+from main.js carries the unreliable ones once it's open. The server's answer to the channel offer
+comes back over the websocket and is handled here.
+
+If the data channel doesn't open within server.DATA_CHANNEL_TIMEOUT, or the server says it gave up on
+it, the unreliable packets go over the websocket behind server.WEBSOCKET_UNRELIABLE_PREFIX instead.
+
+Browser callbacks must never block, so a reliable message the game hasn't read in time closes the
+connection instead of waiting.
+*/
+type BrowserConnection struct {
+	url      string
+	ws       js.Value
+	webrtc   js.Value
+	messages chan server.Message
+	open     bool
+	fallback bool
+	closed   bool
+	stats    server.ConnectionStats
+	funcs    []js.Func
+
+	fallbackTimer js.Value
+}
+
+func NewBrowserConnection(url string) *BrowserConnection {
+	self := &BrowserConnection{url: url, messages: make(chan server.Message, server.RECEIVE_BUFFER_SIZE)}
+
+	log("Connecting to " + url)
+
+	self.ws = js.Global().Get("WebSocket").New(url)
+	self.ws.Set("binaryType", "arraybuffer")
+
+	self.ws.Set("onopen", self.callback(func(args []js.Value) {
+		log("Creating WebRTC connection...")
+
+		webrtcConnectionJs := js.Global().Get("window").Get("WebRTCConnection")
+
+		if webrtcConnectionJs.Type() != js.TypeFunction {
+			log("Please include main.js in html page.")
+			self.fallBack(true)
+			return
+		}
+
+		self.fallbackTimer = js.Global().Call("setTimeout", self.callback(func(args []js.Value) {
+			if !self.open && !self.closed {
+				log("data channel didn't open, falling back to the websocket")
+				self.fallBack(true)
+			}
+		}), server.DATA_CHANNEL_TIMEOUT.Seconds()*1000)
+
+		self.webrtc = webrtcConnectionJs.New(self.ws)
+
+		channel := self.webrtc.Get("sendChannel")
+
+		channel.Set("onmessage", self.callback(func(args []js.Value) {
+			self.push(server.Message{Data: bytesFromArrayBuffer(args[0].Get("data"))})
+		}))
+
+		channel.Set("onopen", self.callback(func(args []js.Value) {
+			log("sendChannel opened")
+			self.open = true
+		}))
+
+		channel.Set("onclose", self.callback(func(args []js.Value) {
+			log("sendChannel closed")
+			self.open = false
+		}))
+	}))
+
+	self.ws.Set("onmessage", self.callback(func(args []js.Value) {
+		message := bytesFromArrayBuffer(args[0].Get("data"))
+
+		if len(message) > 0 && message[0] == server.WEBSOCKET_UNRELIABLE_PREFIX {
+			self.push(server.Message{Data: message[1:]})
+			return
+		}
+
+		if !self.handleSignal(message) {
+			self.push(server.Message{Data: message, Reliable: true})
+		}
+	}))
+
+	self.ws.Set("onclose", self.callback(func(args []js.Value) {
+		log("websocket closed")
+		self.Close()
+	}))
+
+	return self
+}
+
+func (self *BrowserConnection) SendReliable(data []byte) error {
+	if self.closed {
+		return server.ErrConnectionClosed
+	}
+
+	jsBuf := js.TypedArrayOf(data)
+	self.ws.Call("send", jsBuf)
+	jsBuf.Release()
+
+	self.stats.ReliableSent++
+	self.stats.BytesSent += int64(len(data))
+
+	return nil
+}
+
+func (self *BrowserConnection) SendUnreliable(data []byte) error {
+	if self.fallback && !self.closed {
+		jsBuf := js.TypedArrayOf(append([]byte{server.WEBSOCKET_UNRELIABLE_PREFIX}, data...))
+		self.ws.Call("send", jsBuf)
+		jsBuf.Release()
+	} else if self.open {
+		jsBuf := js.TypedArrayOf(data)
+		self.webrtc.Get("sendChannel").Call("send", jsBuf)
+		jsBuf.Release()
+	} else {
+		return server.ErrUnreliableNotOpen
+	}
+
+	self.stats.UnreliableSent++
+	self.stats.BytesSent += int64(len(data))
+
+	return nil
+}
+
+func (self *BrowserConnection) Receive() <-chan server.Message {
+	return self.messages
+}
+
+func (self *BrowserConnection) UnreliableOpen() bool {
+	return self.open || self.fallback
+}
+
+func (self *BrowserConnection) RemoteAddr() string {
+	return self.url
+}
+
+func (self *BrowserConnection) Stats() server.ConnectionStats {
+	return self.stats
+}
+
+func (self *BrowserConnection) Close() error {
+	if self.closed {
+		return nil
+	}
+
+	self.closed = true
+	self.fallback = false
+
+	self.closeWebRTC()
+
+	// the browser mustn't call the callbacks once they're released.
+	if self.fallbackTimer.Type() == js.TypeNumber {
+		js.Global().Call("clearTimeout", self.fallbackTimer)
+	}
+
+	for _, handler := range []string{"onopen", "onmessage", "onclose"} {
+		self.ws.Set(handler, js.Null())
+	}
+	self.ws.Call("close")
+
+	close(self.messages)
+
+	for _, f := range self.funcs {
+		f.Release()
+	}
+	self.funcs = nil
+
+	return nil
+}
+
+func (self *BrowserConnection) push(message server.Message) {
+	if self.closed {
+		return
+	}
+
+	select {
+	case self.messages <- message:
+		if message.Reliable {
+			self.stats.ReliableReceived++
+		} else {
+			self.stats.UnreliableReceived++
+		}
+		self.stats.BytesReceived += int64(len(message.Data))
+	default:
+		if message.Reliable {
+			log("reliable message not read in time, closing")
+			self.Close()
+			return
+		}
+		self.stats.Dropped++
+	}
+}
+
+// Handles the answer to the data channel offer and the server falling back to the websocket.
+func (self *BrowserConnection) handleSignal(message []byte) bool {
+	var signal map[string]interface{}
+
+	if err := json.Unmarshal(message, &signal); err != nil {
+		return false
+	}
+
+	if _, ok := signal["fallback"]; ok {
+		log("server fell back to the websocket")
+		self.fallBack(false)
+		return true
+	}
+
+	answer, ok := signal["answer"].(string)
+
+	if !ok {
+		return false
+	}
+
+	if self.webrtc.Type() == js.TypeObject {
+		log("-- Setting answer")
+		self.webrtc.Call("setAnswer", answer)
+	}
+
+	return true
+}
+
+// Sends the unreliable packets over the websocket from now on, notify tells the server to do the same.
+func (self *BrowserConnection) fallBack(notify bool) {
+	if self.fallback || self.closed {
+		return
+	}
+
+	self.fallback = true
+
+	self.closeWebRTC()
+
+	if notify {
+		if err := self.SendReliable([]byte("{\"fallback\":true}")); err != nil {
+			log("fallback not sent", err.Error())
+		}
+	}
+}
+
+func (self *BrowserConnection) closeWebRTC() {
+	self.open = false
+
+	if self.webrtc.Type() != js.TypeObject {
+		return
+	}
+
+	// the browser mustn't call the callbacks once they're released.
+	channel := self.webrtc.Get("sendChannel")
+	for _, handler := range []string{"onmessage", "onopen", "onclose"} {
+		channel.Set(handler, js.Null())
+	}
+	channel.Call("close")
+	self.webrtc.Get("pc").Call("close")
+
+	self.webrtc = js.Null()
+}
+
+func (self *BrowserConnection) callback(f func(args []js.Value)) js.Func {
+	function := js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		f(args)
+		return nil
+	})
+
+	self.funcs = append(self.funcs, function)
+
+	return function
+}
+
+// Copies an ArrayBuffer into a go slice.
+func bytesFromArrayBuffer(data js.Value) []byte {
+	// make sure to release the typed array when done or there's a memory leak.
+	dataJSArray := js.Global().Get("Uint8Array").New(data)
+	message := make([]byte, data.Get("byteLength").Int())
+
+	jsBuf := js.TypedArrayOf(message)
+	jsBuf.Call("set", dataJSArray, 0)
+	jsBuf.Release()
+
+	return message
+}
@@ -1,25 +1,42 @@
 package web
 
 import (
+	"github.com/Banyango/io-engine/src/client"
 	. "github.com/Banyango/io-engine/src/ecs"
 	"strconv"
 	"syscall/js"
 )
 
 type ClientInputSystem struct {
+	CanvasElementId string // the cursor is relative to this canvas, which is drawn in world space
+
+	Touch *client.TouchControls // virtual joystick and buttons on touch screens, NewTouchControls if nil
+
 	keyDownFunc js.Func
-	keyPressedFunc js.Func
 	keyUpFunc js.Func
 
 	mouseMoveFunc js.Func
 	mouseDownFunc js.Func
 	mouseUpFunc js.Func
 
+	touchStartFunc js.Func
+	touchMoveFunc  js.Func
+	touchEndFunc   js.Func
+
 	callbackInput *Input
 }
 
 func (self *ClientInputSystem) Init(world *World) {
 	self.callbackInput = NewInput()
+
+	if self.CanvasElementId == "" {
+		self.CanvasElementId = "mycanvas"
+	}
+
+	if self.Touch == nil {
+		self.Touch = client.NewTouchControls()
+	}
+
 	go func() {
 		doc := js.Global().Get("document")
 
@@ -28,7 +45,15 @@ func (self *ClientInputSystem) Init(world *World) {
 
 			self.mouseMoveFunc = js.FuncOf(func(this js.Value, args []js.Value) interface{} {
 				e := args[0]
-				self.callbackInput.MousePosition.Set(e.Get("clientX").Float(), e.Get("clientY").Float())
+				x, y := e.Get("clientX").Float(), e.Get("clientY").Float()
+
+				if canvas := doc.Call("getElementById", self.CanvasElementId); canvas.Truthy() {
+					rect := canvas.Call("getBoundingClientRect")
+					x -= rect.Get("left").Float()
+					y -= rect.Get("top").Float()
+				}
+
+				self.callbackInput.MousePosition.Set(x, y)
 				return nil;
 			})
 			defer self.mouseMoveFunc.Release()
@@ -40,8 +65,8 @@ func (self *ClientInputSystem) Init(world *World) {
 
 				keyCode, err := KeyFromString(e.Get("keyCode").String())
 
+				// held keys repeat, only the held state is kept, the edges are per tick, see Input.SetDeviceEdges.
 				if err == nil {
-					self.callbackInput.KeyDown[keyCode] = true
 					self.callbackInput.KeyPressed[keyCode] = true
 				}
 
@@ -50,8 +75,6 @@ func (self *ClientInputSystem) Init(world *World) {
 
 			defer self.keyDownFunc.Release()
 
-			defer self.keyPressedFunc.Release()
-
 			self.keyUpFunc = js.FuncOf(func(this js.Value, args []js.Value) interface{} {
 				e := args[0]
 
@@ -61,7 +84,6 @@ func (self *ClientInputSystem) Init(world *World) {
 
 				if err == nil {
 					self.callbackInput.KeyPressed[keyCode] = false
-					self.callbackInput.KeyUp[keyCode] = true
 				}
 
 				return nil;
@@ -69,9 +91,44 @@ func (self *ClientInputSystem) Init(world *World) {
 
 			defer self.keyUpFunc.Release()
 
+			self.mouseDownFunc = js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+				self.callbackInput.MouseDown[args[0].Get("button").Int()] = true
+				return nil
+			})
+			defer self.mouseDownFunc.Release()
+
+			self.mouseUpFunc = js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+				self.callbackInput.MouseDown[args[0].Get("button").Int()] = false
+				return nil
+			})
+			defer self.mouseUpFunc.Release()
+
+			self.touchStartFunc = self.touchHandler(doc, self.Touch.TouchStart)
+			defer self.touchStartFunc.Release()
+
+			self.touchMoveFunc = self.touchHandler(doc, self.Touch.TouchMove)
+			defer self.touchMoveFunc.Release()
+
+			self.touchEndFunc = self.touchHandler(doc, func(id int, x float64, y float64) {
+				self.Touch.TouchEnd(id)
+			})
+			defer self.touchEndFunc.Release()
+
 			doc.Call("addEventListener", "mousemove", self.mouseMoveFunc)
 			doc.Call("addEventListener", "keydown", self.keyDownFunc)
 			doc.Call("addEventListener", "keyup", self.keyUpFunc)
+			doc.Call("addEventListener", "mousedown", self.mouseDownFunc)
+			doc.Call("addEventListener", "mouseup", self.mouseUpFunc)
+
+			if canvas := doc.Call("getElementById", self.CanvasElementId); canvas.Truthy() {
+				// not passive so the page doesn't scroll or zoom while playing.
+				options := map[string]interface{}{"passive": false}
+
+				canvas.Call("addEventListener", "touchstart", self.touchStartFunc, options)
+				canvas.Call("addEventListener", "touchmove", self.touchMoveFunc, options)
+				canvas.Call("addEventListener", "touchend", self.touchEndFunc, options)
+				canvas.Call("addEventListener", "touchcancel", self.touchEndFunc, options)
+			}
 
 			<-done
 		}
@@ -96,8 +153,58 @@ func (self *ClientInputSystem) UpdateSystem(delta float64, world *World) {
 		return
 	}
 
-	world.Input.Player[0] = self.callbackInput.Clone()
+	previous := world.Input.Player[0]
+
+	input := self.callbackInput.Clone()
+	input.SetGamepads(pollGamepads())
+
+	world.InputMap().Resolve(input)
+	self.Touch.Apply(input, world.InputMap())
+
+	input.SetEdges(previous)
+	input.SetDeviceEdges(previous)
+
+	world.Input.Player[0] = input
+}
+
+// Calls handle with every touch that changed, in canvas pixels.
+func (self *ClientInputSystem) touchHandler(doc js.Value, handle func(id int, x float64, y float64)) js.Func {
+	return js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		e := args[0]
+		e.Call("preventDefault")
+
+		canvas := doc.Call("getElementById", self.CanvasElementId)
+
+		if !canvas.Truthy() {
+			return nil
+		}
+
+		rect := canvas.Call("getBoundingClientRect")
+		width := canvas.Get("width").Float()
+		height := canvas.Get("height").Float()
+
+		self.Touch.Width = width
+		self.Touch.Height = height
+
+		// the canvas can be scaled by css.
+		scaleX, scaleY := 1.0, 1.0
+		if rect.Get("width").Float() > 0 && rect.Get("height").Float() > 0 {
+			scaleX = width / rect.Get("width").Float()
+			scaleY = height / rect.Get("height").Float()
+		}
+
+		touches := e.Get("changedTouches")
+
+		for i := 0; i < touches.Length(); i++ {
+			touch := touches.Index(i)
+			x := (touch.Get("clientX").Float() - rect.Get("left").Float()) * scaleX
+			y := (touch.Get("clientY").Float() - rect.Get("top").Float()) * scaleY
+
+			handle(touch.Get("identifier").Int(), x, y)
+		}
 
+		return nil
+	})
 }
 
 func KeyFromString(s string) (KeyCode, error) {
@@ -110,5 +217,40 @@ func KeyFromString(s string) (KeyCode, error) {
 	return KeyCode(keycode), err
 }
 
+// Reads every connected gamepad, the Gamepad API has no events for buttons and sticks so it's polled
+// every tick.
+func pollGamepads() []GamepadState {
+	navigator := js.Global().Get("navigator")
 
+	if !navigator.Truthy() || !navigator.Get("getGamepads").Truthy() {
+		return nil
+	}
+
+	pads := navigator.Call("getGamepads")
+	result := []GamepadState{}
 
+	// disconnected slots are null.
+	for i := 0; i < pads.Length(); i++ {
+		pad := pads.Index(i)
+
+		if !pad.Truthy() || !pad.Get("connected").Bool() {
+			continue
+		}
+
+		state := GamepadState{}
+
+		buttons := pad.Get("buttons")
+		for j := 0; j < buttons.Length(); j++ {
+			state.Buttons = append(state.Buttons, buttons.Index(j).Get("pressed").Bool())
+		}
+
+		axes := pad.Get("axes")
+		for j := 0; j < axes.Length(); j++ {
+			state.Axes = append(state.Axes, axes.Index(j).Float())
+		}
+
+		result = append(result, state)
+	}
+
+	return result
+}
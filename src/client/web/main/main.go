@@ -38,6 +38,11 @@ func main() {
 
 	renderer := new(web.CanvasRenderSystem)
 
+	movement.Client = &netClient.Client
+
+	input.Touch = client.NewTouchControls()
+	renderer.Touch = input.Touch
+
 	w.AddRenderer(renderer)
 
 	w.AddSystem(input)
@@ -55,6 +60,23 @@ func main() {
 
 	w.PrefabData = pm
 
+	// static entities from the map aren't sent by the server.
+	if pm.Map != "" {
+		mapJson := make(chan string)
+
+		js.Global().Call("fetch", "/"+pm.Map).Call("then", js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+			return args[0].Call("text")
+		})).Call("then", js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+			mapJson <- args[0].String()
+			return nil
+		}))
+
+		if err := w.LoadTiledMap(pm.Map, []byte(<-mapJson)); err != nil {
+			fmt.Println("Error loading map", err)
+			os.Exit(1)
+		}
+	}
+
 	MainLoopClient(w)
 }
 
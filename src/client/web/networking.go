@@ -1,18 +1,14 @@
 package web
 
 import (
-	"bytes"
 	"encoding/base64"
-	"encoding/gob"
 	"encoding/json"
 	"fmt"
 	"github.com/Banyango/io-engine/src/client"
 	. "github.com/Banyango/io-engine/src/ecs"
 	"github.com/Banyango/io-engine/src/server"
-	"github.com/Banyango/socker"
 	"net/url"
 	"path"
-	"sync"
 	"syscall/js"
 	"time"
 )
@@ -22,201 +18,193 @@ type ConnectionStateType int
 type NetworkedClientSystem struct {
 	entities Storage
 
-	ConnHandler *socker.SockerClient
+	// the server, a BrowserConnection to ServerURL is opened on the first update if it's not set.
+	Connection server.Connection
 
 	Client client.Client
 
-	done chan struct{}
-
-	ws       js.Value
-
-	WebRTCConnection js.Value
-
 	IsConnected      bool
 	WaitingToResync  bool
-	mux              sync.Mutex
 	WorldStatePacket []*server.WorldState
 
 	NetworkInstance Storage
-}
 
-func (self *NetworkedClientSystem) Init(w *World) {
+	// inputs the server hasn't acked, resent with every packet.
+	InputHistory server.InputHistory
 
-	self.ConnHandler = socker.NewClient()
+	// world states received in full, the server's deltas are against the newest one acked.
+	Snapshots server.SnapshotHistory
 
+	handshakeReceived bool
+	spawnSent         bool
+}
+
+func (self *NetworkedClientSystem) Init(w *World) {
 	self.NetworkInstance = NewStorage()
 
 	self.Client = client.Client{}
+}
 
-	// handle webrtc answer
-	self.ConnHandler.Add(func(message []byte) bool {
+// Address of the server's websocket, it carries the game data version the server checks.
+func ServerURL(w *World) string {
+	// todo this needs to match the server address. Maybe build a service?
+	u, err := url.Parse("ws://localhost:8081")
 
-		log("-- Handling answer")
-		var handshake map[string]string
-		err := json.Unmarshal(message, &handshake)
+	if err != nil {
+		panic(err)
+	}
 
-		if err != nil {
-			log("handshake failure ")
-			panic(err)
-		}
+	u.Path = path.Join(u.Path, "connect")
 
-		log("-- Setting answer")
-		self.WebRTCConnection.Call("setAnswer", handshake["answer"])
+	// the server refuses clients with other game data.
+	if w.PrefabData != nil {
+		u.RawQuery = url.Values{"version": {w.PrefabData.Version}}.Encode()
+	}
 
-		return true
-	})
+	return u.String()
+}
 
-	// handle normal TCP packets.
-	self.ConnHandler.Add(func(message []byte) bool {
-		return false
-	})
+/**
+Networking Handlers
+ */
 
-	go func() {
-		// todo this needs to match the server address. Maybe build a service?
-		u, err := url.Parse("ws://localhost:8081")
+// Reads everything the server sent since the last update.
+func (self *NetworkedClientSystem) receive(w *World) {
+	for {
+		select {
+		case message, ok := <-self.Connection.Receive():
+			if !ok {
+				if self.IsConnected {
+					log("connection closed")
+				}
+				self.IsConnected = false
+				return
+			}
 
-		if err != nil {
-			fmt.Println("Error parsing URL")
+			if message.Reliable {
+				self.handleReliable(message.Data, w)
+			} else {
+				w.BytesRec = len(message.Data)
+				self.receiveWorldStateTick(message.Data)
+			}
+		default:
 			return
 		}
+	}
+}
 
-		u.Path = path.Join(u.Path, "connect")
-
-		js.Global().Get("console").Call("log", "Connecting to "+u.String())
+// The first reliable message is the handshake, game data reloads follow.
+func (self *NetworkedClientSystem) handleReliable(message []byte, w *World) {
+	if !self.handshakeReceived {
+		log("-- Handling handshake")
 
-		self.ws = js.Global().Get("WebSocket").New(u.String())
-		self.ws.Set("binaryType", "arraybuffer")
+		var handshake server.ServerConnectionHandshakePacket
 
-		onopen := js.FuncOf(func(this js.Value, args []js.Value) interface{} {
-			js.Global().Get("console").Call("log", "Creating WebRTC connection...")
+		if err := json.Unmarshal(message, &handshake); err != nil {
+			log("handshake failure ", err.Error())
+			self.Connection.Close()
+			return
+		}
 
-			webrtcConnectionJs := js.Global().Get("window").Get("WebRTCConnection")
+		self.handshakeReceived = true
 
-			if webrtcConnectionJs == js.Undefined() {
-				log("Please include main.js in html page.")
+		w.Enqueue(func(world *World) {
+			if err := self.Client.HandleHandshake(handshake, world); err != nil {
+				log("handshake refused", err.Error())
+				self.Connection.Close()
 			}
-
-			self.WebRTCConnection = webrtcConnectionJs.New(self.ws)
-
-			log("Adding onmessage..")
-
-			self.WebRTCConnection.Get("sendChannel").Set("onmessage", js.FuncOf(func(this js.Value, args []js.Value) interface{} {
-				bytesRec := self.receiveWorldStateTick(args)
-				w.BytesRec = bytesRec
-				return nil
-			}));
-
-			self.WebRTCConnection.Get("sendChannel").Set("onclose", js.FuncOf(func(this js.Value, args []js.Value) interface{} {
-				log("sendChannel closed")
-				self.IsConnected = false
-				return nil
-			}))
-
-			self.WebRTCConnection.Get("sendChannel").Set("onopen", js.FuncOf(func(this js.Value, args []js.Value) interface{} {
-				self.onWebRTCConnectionOpened()
-				return nil
-			}))
-
-			return nil
 		})
 
-		defer onopen.Release()
-
-		onmessage := js.FuncOf(func(this js.Value, args []js.Value) interface{} {
-			// Create a buffer and copy it into a wasm referenced slice so we can use it in golang
-			// make sure to release the typed array when done or there's a memory leak.
-			dataJSArray := js.Global().Get("Uint8Array").New(args[0].Get("data"))
-			message := make([]byte, args[0].Get("data").Get("byteLength").Int())
-
-			jsBuf := js.TypedArrayOf(message)
-			jsBuf.Call("set", dataJSArray, 0)
-
-			err := self.ConnHandler.Handle(message)
-
-			if err != nil {
-				fmt.Println("Error occurred")
-				jsBuf.Release()
-			}
-
-			jsBuf.Release()
-
-			return nil
-		})
+		return
+	}
 
-		defer onmessage.Release()
+	var packet server.GameDataPacket
+	err := json.Unmarshal(message, &packet)
 
-		self.ws.Set("onopen", onopen)
-		self.ws.Set("onmessage", onmessage)
+	if err != nil || packet.GameData == "" {
+		log("unknown message")
+		return
+	}
 
-		<-self.done
-	}()
+	w.Enqueue(func(world *World) {
+		if err := self.Client.HandleGameData(packet, world); err != nil {
+			log("error reloading game data", err.Error())
+		}
+	})
 }
 
-/**
-Networking Handlers
- */
+func (self *NetworkedClientSystem) onConnectionOpened() {
+	log("connection opened")
 
-func (self *NetworkedClientSystem) onWebRTCConnectionOpened() {
-	log("sendChannel opened")
-	self.IsConnected = true
-	{
-		// this is a hack and should be changed. advances the server state once the connection is opened.
-		enc := js.Global().Get("TextEncoder").New()
-		result := enc.Call("encode", "{\"event\":\"connectionOpened\"}")
-		self.ws.Call("send", result)
+	if self.spawnSent {
+		return
 	}
-	enc := js.Global().Get("TextEncoder").New()
-	result := enc.Call("encode", "{\"event\":\"spawn\"}")
-	self.ws.Call("send", result)
-}
 
-func (self *NetworkedClientSystem) receiveWorldStateTick(args []js.Value) int {
-	dataJSArray := js.Global().Get("Uint8Array").New(args[0].Get("data"))
-	message := make([]byte, args[0].Get("data").Get("byteLength").Int())
-	jsBuf := js.TypedArrayOf(message)
-	jsBuf.Call("set", dataJSArray, 0)
+	if err := self.Connection.SendReliable([]byte("{\"event\":\"spawn\"}")); err != nil {
+		log("spawn not sent", err.Error())
+		return
+	}
 
-	bytesRec := len(message)
+	self.spawnSent = true
+}
 
+func (self *NetworkedClientSystem) receiveWorldStateTick(message []byte) {
 	var packet server.ClientWorldStatePacket
-	if err := gob.NewDecoder(bytes.NewReader(message)).Decode(&packet); err != nil {
+	if err := packet.UnmarshalBinary(message); err != nil {
 		fmt.Println("Error in ClientWorldStatePacket", err)
+		return
 	}
 
 	var data server.WorldState
-	if err := gob.NewDecoder(bytes.NewReader(packet.State)).Decode(&data); err != nil {
+	if err := data.UnmarshalBinary(packet.State); err != nil {
 		fmt.Println("Error in WorldStatePacket", err)
+		return
 	}
 
 	if packet.RTT != nil {
 		self.Client.HandleRTT(packet.RTT)
 	}
 
-	self.WorldStatePacket = append(self.WorldStatePacket, &data)
-	jsBuf.Release()
+	self.InputHistory.Ack(packet.InputAck)
+
+	if data.Baseline > 0 {
+		baseline, found := self.Snapshots.Find(data.Baseline)
 
-	return bytesRec
+		if !found {
+			fmt.Println("Dropping world state, no snapshot at tick", data.Baseline)
+			return
+		}
+
+		data.ApplyDelta(baseline)
+	}
+
+	self.Snapshots.Add(data.Tick, data.Updates)
+
+	self.WorldStatePacket = append(self.WorldStatePacket, &data)
 }
 
 func (self *NetworkedClientSystem) sendInputForCurrentFrame(world *World) {
 
-	var buf bytes.Buffer
+	self.InputHistory.Add(world.CurrentTick, world.InputMap().Encode(world.Input.Player[0]))
+
+	data := self.InputHistory.Packet(time.Now().UnixNano())
 
-	data := server.ClientPacket{
-		Tick:world.CurrentTick,
-		Time:time.Now().UnixNano(),
-		Input:world.Input.Player[0].ToBytes()[0],
+	if data == nil {
+		return
 	}
 
-	err := gob.NewEncoder(&buf).Encode(data)
+	data.SnapshotAck = self.Snapshots.Newest()
+
+	message, err := data.MarshalBinary()
 
 	if err != nil {
 		fmt.Println(err)
+		return
 	}
 
-	jsBuf := js.TypedArrayOf(buf.Bytes())
-	self.WebRTCConnection.Get("sendChannel").Call("send", jsBuf)
-	jsBuf.Release()
+	if err := self.Connection.SendUnreliable(message); err != nil {
+		fmt.Println(err)
+	}
 }
 
 func (self *NetworkedClientSystem) UpdateSystem(delta float64, world *World) {
@@ -224,6 +212,21 @@ func (self *NetworkedClientSystem) UpdateSystem(delta float64, world *World) {
 		return
 	}
 
+	// connects on the first update, the game data version isn't loaded yet in Init.
+	if self.Connection == nil {
+		self.Connection = NewBrowserConnection(ServerURL(world))
+	}
+
+	self.receive(world)
+
+	open := self.Connection.UnreliableOpen()
+
+	if open && !self.IsConnected {
+		self.onConnectionOpened()
+	}
+
+	self.IsConnected = open
+
 	if self.IsDataChannelConnected() {
 
 		world.Ping = self.Client.Ping;
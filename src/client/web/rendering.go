@@ -1,16 +1,12 @@
 package web
 
 import (
-	"database/sql/driver"
-	"encoding/json"
 	"fmt"
+	"github.com/Banyango/io-engine/src/client"
 	. "github.com/Banyango/io-engine/src/ecs"
 	"github.com/Banyango/io-engine/src/game"
 	"github.com/Banyango/io-engine/src/math"
-	"github.com/goburrow/dynamic"
-	. "github.com/lucasb-eyer/go-colorful"
 	math2 "math"
-	"reflect"
 	"syscall/js"
 )
 
@@ -29,12 +25,12 @@ type CanvasRenderSystem struct {
 	ctx           js.Value
 
 	ShouldRender bool
+
+	Touch *client.TouchControls // drawn over the world once the screen is touched
 }
 
 func (self *CanvasRenderSystem) Init(w *World) {
-	dynamic.Register("CircleRendererComponent", func() interface{} {
-		return &CircleRendererComponent{}
-	})
+	game.RegisterComponents()
 
 	self.circleComponents = NewStorage()
 	self.positionComponents = NewStorage()
@@ -97,7 +93,7 @@ func (self *CanvasRenderSystem) UpdateSystem(delta float64, world *World) {
 
 	for entity, _ := range self.positionComponents.Components {
 		self.ctx.Call("save")
-		circle := (*self.circleComponents.Components[entity]).(*CircleRendererComponent)
+		circle := (*self.circleComponents.Components[entity]).(*game.CircleRendererComponent)
 		position := (*self.positionComponents.Components[entity]).(*game.PositionComponent)
 
 		vector := self.lerpingComponents[entity]
@@ -121,84 +117,49 @@ func (self *CanvasRenderSystem) UpdateSystem(delta float64, world *World) {
 		self.ctx.Call("restore")
 	}
 
+	if self.Touch != nil && self.Touch.Visible {
+		self.renderTouchControls()
+	}
 }
 
-type CircleRendererComponent struct {
-	Size   math.Vector
-	Color  MyHexColor
-	Radius float32
-}
-
-func (self *CircleRendererComponent) Id() int {
-	return int(CircleComponentType)
-}
-
-func (self *CircleRendererComponent) CreateComponent() {
-
-}
-
-func (self *CircleRendererComponent) DestroyComponent() {
-
-}
-
-func (self *CircleRendererComponent) Clone() Component {
-	component := new(CircleRendererComponent)
-	component.Size = self.Size
-	component.Color = self.Color
-	component.Radius = self.Radius
-	return component
-}
-
-func (self *CircleRendererComponent) Reset(component Component) {
-
-}
-
-type MyHexColor Color
+func (self *CanvasRenderSystem) renderTouchControls() {
+	self.ctx.Call("save")
+	self.ctx.Set("lineWidth", 3)
+	self.ctx.Set("strokeStyle", "rgba(255, 255, 255, 0.6)")
 
-type errUnsupportedType struct {
-	got  interface{}
-	want reflect.Type
-}
+	joystick := self.Touch.JoystickCenter()
+	knob := joystick.Add(self.Touch.Stick().Scale(self.Touch.Joystick.Radius))
 
-func (hc *MyHexColor) Scan(value interface{}) error {
-	s, ok := value.(string)
-	if !ok {
-		return errUnsupportedType{got: reflect.TypeOf(value), want: reflect.TypeOf("")}
-	}
-	c, err := Hex(s)
-	if err != nil {
-		return err
-	}
-	*hc = MyHexColor(c)
-	return nil
-}
+	self.ctx.Call("beginPath")
+	self.ctx.Call("arc", joystick.X(), joystick.Y(), self.Touch.Joystick.Radius, 0, 2*math2.Pi)
+	self.ctx.Call("stroke")
 
-func (hc *MyHexColor) Value() (driver.Value, error) {
-	return Color(*hc).Hex(), nil
-}
+	self.ctx.Set("fillStyle", "rgba(255, 255, 255, 0.5)")
+	self.ctx.Call("beginPath")
+	self.ctx.Call("arc", knob.X(), knob.Y(), self.Touch.Joystick.Radius/2, 0, 2*math2.Pi)
+	self.ctx.Call("fill")
 
-func (e errUnsupportedType) Error() string {
-	return fmt.Sprintf("unsupported type: got %v, want a %s", e.got, e.want)
-}
-
-func (self *MyHexColor) UnmarshalJSON(bytes []byte) error {
-	data := ""
+	self.ctx.Set("font", "bold 20px sans-serif")
+	self.ctx.Set("textAlign", "center")
+	self.ctx.Set("textBaseline", "middle")
 
-	err := json.Unmarshal(bytes, &data)
+	for i, button := range self.Touch.Buttons {
+		center := self.Touch.ButtonCenter(i)
 
-	if err != nil {
-		return err
-	}
+		if self.Touch.Held(i) {
+			self.ctx.Set("fillStyle", "rgba(255, 255, 255, 0.6)")
+		} else {
+			self.ctx.Set("fillStyle", "rgba(255, 255, 255, 0.25)")
+		}
 
-	color, err := Hex(data)
+		self.ctx.Call("beginPath")
+		self.ctx.Call("arc", center.X(), center.Y(), button.Radius, 0, 2*math2.Pi)
+		self.ctx.Call("fill")
+		self.ctx.Call("stroke")
 
-	if err != nil {
-		return err
+		self.ctx.Set("fillStyle", "rgba(0, 0, 0, 0.7)")
+		self.ctx.Call("fillText", button.Label, center.X(), center.Y())
 	}
 
-	self.R = color.R
-	self.G = color.G
-	self.B = color.B
-
-	return nil
+	self.ctx.Call("restore")
 }
@@ -0,0 +1,427 @@
+package ecs
+
+import (
+	"errors"
+	"fmt"
+	"github.com/Banyango/io-engine/src/math"
+	gomath "math"
+	"strconv"
+)
+
+/**
+Input actions
+
+Systems read named actions instead of keys so games can define their own controls. Actions and
+their bindings are declared in game.json, a binding is held when any of its keys, mouse buttons or
+gamepad buttons is held:
+
+	"input": {
+		"actions": [
+			{ "name": "move_up", "keys": ["ArrowUp", "W"] },
+			{ "name": "fire", "keys": ["X"], "mouse": [0], "gamepad": [0] }
+		],
+		"axes": [
+			{ "name": "move_x", "negative": ["ArrowLeft"], "positive": ["ArrowRight"], "gamepad": 0 }
+		],
+		"cursor": true
+	}
+
+Clients resolve the actions from their devices every tick and only the actions are sent to the
+server, one bit each in the order they're declared, see NetworkInput for the wire format. Game data
+without "input" uses DefaultInputMap.
+
+Axes are analog values from -1 to 1, sent quantized to a signed byte. They take the gamepad axis
+when it's pushed, otherwise the keys. With "cursor" the world-space mouse position is sent as well
+for aiming.
+
+Clients predict the input of other players until the server confirms it by repeating their last
+known input. Actions that shouldn't repeat, like firing, set "predict" to "release" and are
+predicted released instead. Axes and the cursor always repeat.
+*/
+
+type InputJson struct {
+	Actions []InputActionJson `json:"actions"`
+	Axes    []InputAxisJson   `json:"axes,omitempty"`
+	Cursor  bool              `json:"cursor,omitempty"`
+}
+
+type InputActionJson struct {
+	Name    string   `json:"name"`
+	Keys    []string `json:"keys,omitempty"`    // key names, see KeyCodeFromName
+	Mouse   []int    `json:"mouse,omitempty"`   // mouse buttons, 0 is the left button
+	Gamepad []int    `json:"gamepad,omitempty"` // buttons of the standard gamepad layout
+	Predict string   `json:"predict,omitempty"` // PREDICT_REPEAT or PREDICT_RELEASE, repeat if empty
+}
+
+// How a remote player's action is predicted for ticks the server hasn't confirmed.
+const (
+	PREDICT_REPEAT  = "repeat"  // held as long as it was last known held
+	PREDICT_RELEASE = "release" // released until confirmed
+)
+
+type InputAxisJson struct {
+	Name     string   `json:"name"`
+	Negative []string `json:"negative,omitempty"` // keys pushing the axis to -1
+	Positive []string `json:"positive,omitempty"` // keys pushing the axis to 1
+	Gamepad  *int     `json:"gamepad,omitempty"`  // axis of the standard gamepad layout
+}
+
+type InputAction struct {
+	Name    string
+	Keys    []KeyCode
+	Mouse   []int
+	Gamepad []int
+	Release bool // predicted released instead of repeated, see InputMap.Predict
+}
+
+type InputAxis struct {
+	Name     string
+	Negative []KeyCode
+	Positive []KeyCode
+	Gamepad  int // -1 if the axis isn't bound to the gamepad
+}
+
+type InputMap struct {
+	Actions []InputAction // the index of an action is its bit in the network input
+	Axes    []InputAxis   // the index of an axis is its index in NetworkInput.Axes
+	Cursor  bool          // send the world-space cursor
+}
+
+// Input of one player for one tick as it's sent to the server.
+type NetworkInput struct {
+	Buttons   []byte // bit i of byte i/8 is set when action i is held, see InputMap.ToButtons
+	Axes      []int8 // -127 to 127, one per axis of the InputMap
+	HasCursor bool
+	CursorX   int16 // world-space cursor, rounded to the pixel
+	CursorY   int16
+}
+
+// Limits of a NetworkInput on the wire, see the server codec. 256 actions.
+const MAX_INPUT_BUTTON_BYTES = 32
+
+const MAX_INPUT_AXES = 64
+
+func (self NetworkInput) Equals(other NetworkInput) bool {
+	if len(self.Buttons) != len(other.Buttons) || len(self.Axes) != len(other.Axes) || self.HasCursor != other.HasCursor {
+		return false
+	}
+
+	for i := range self.Buttons {
+		if self.Buttons[i] != other.Buttons[i] {
+			return false
+		}
+	}
+
+	for i := range self.Axes {
+		if self.Axes[i] != other.Axes[i] {
+			return false
+		}
+	}
+
+	return !self.HasCursor || (self.CursorX == other.CursorX && self.CursorY == other.CursorY)
+}
+
+// Gamepad axes closer to the center than this are ignored so a resting stick doesn't drift.
+const GAMEPAD_DEAD_ZONE = 0.15
+
+// The controls of game data without an "input" section, the arrow keys, X and C.
+func DefaultInputMap() *InputMap {
+	return &InputMap{Actions: []InputAction{
+		{Name: "move_up", Keys: []KeyCode{Up}},
+		{Name: "move_down", Keys: []KeyCode{Down}},
+		{Name: "move_left", Keys: []KeyCode{Right}}, // keyCode 37 is the left arrow
+		{Name: "move_right", Keys: []KeyCode{Left}},
+		{Name: "fire", Keys: []KeyCode{X}},
+		{Name: "alt_fire", Keys: []KeyCode{C}},
+	}}
+}
+
+// Builds the input map declared in game data. Problems are returned as LoadErrors with the field of the binding.
+func NewInputMap(data InputJson) (*InputMap, LoadErrors) {
+	errs := LoadErrors{}
+	result := InputMap{}
+	names := map[string]bool{}
+
+	for i, actionJson := range data.Actions {
+		field := fmt.Sprintf("input.actions.%d", i)
+
+		if actionJson.Name == "" {
+			errs = append(errs, LoadError{ComponentIndex: -1, Field: field + ".name", Err: errors.New("action must have a name")})
+			continue
+		}
+
+		if names[actionJson.Name] {
+			errs = append(errs, LoadError{ComponentIndex: -1, Field: field + ".name", Err: fmt.Errorf("action %q is already declared", actionJson.Name)})
+			continue
+		}
+
+		names[actionJson.Name] = true
+
+		action := InputAction{Name: actionJson.Name, Mouse: actionJson.Mouse, Gamepad: actionJson.Gamepad}
+
+		switch actionJson.Predict {
+		case "", PREDICT_REPEAT:
+		case PREDICT_RELEASE:
+			action.Release = true
+		default:
+			errs = append(errs, LoadError{ComponentIndex: -1, Field: field + ".predict", Err: fmt.Errorf("unknown prediction %q, use %q or %q", actionJson.Predict, PREDICT_REPEAT, PREDICT_RELEASE)})
+		}
+
+		action.Keys = keyCodes(actionJson.Keys, field+".keys", &errs)
+
+		result.Actions = append(result.Actions, action)
+	}
+
+	for i, axisJson := range data.Axes {
+		field := fmt.Sprintf("input.axes.%d", i)
+
+		if axisJson.Name == "" {
+			errs = append(errs, LoadError{ComponentIndex: -1, Field: field + ".name", Err: errors.New("axis must have a name")})
+			continue
+		}
+
+		if names[axisJson.Name] {
+			errs = append(errs, LoadError{ComponentIndex: -1, Field: field + ".name", Err: fmt.Errorf("%q is already declared", axisJson.Name)})
+			continue
+		}
+
+		names[axisJson.Name] = true
+
+		axis := InputAxis{Name: axisJson.Name, Gamepad: -1}
+
+		if axisJson.Gamepad != nil {
+			axis.Gamepad = *axisJson.Gamepad
+		}
+
+		axis.Negative = keyCodes(axisJson.Negative, field+".negative", &errs)
+		axis.Positive = keyCodes(axisJson.Positive, field+".positive", &errs)
+
+		result.Axes = append(result.Axes, axis)
+	}
+
+	// more wouldn't fit in a NetworkInput on the wire.
+	if len(result.Actions) > MAX_INPUT_BUTTON_BYTES*8 {
+		errs = append(errs, LoadError{ComponentIndex: -1, Field: "input.actions", Err: fmt.Errorf("%d actions, at most %d are supported", len(result.Actions), MAX_INPUT_BUTTON_BYTES*8)})
+	}
+
+	if len(result.Axes) > MAX_INPUT_AXES {
+		errs = append(errs, LoadError{ComponentIndex: -1, Field: "input.axes", Err: fmt.Errorf("%d axes, at most %d are supported", len(result.Axes), MAX_INPUT_AXES)})
+	}
+
+	result.Cursor = data.Cursor
+
+	return &result, errs
+}
+
+// Key codes of the key names, unknown names are added to errs.
+func keyCodes(names []string, field string, errs *LoadErrors) []KeyCode {
+	result := []KeyCode{}
+
+	for i, name := range names {
+		keyCode, err := KeyCodeFromName(name)
+
+		if err != nil {
+			*errs = append(*errs, LoadError{ComponentIndex: -1, Field: fmt.Sprintf("%s.%d", field, i), Err: err})
+			continue
+		}
+
+		result = append(result, keyCode)
+	}
+
+	return result
+}
+
+// Sets the actions of the input from the keys, mouse and gamepad buttons it holds.
+func (self *InputMap) Resolve(input *Input) {
+	if input.Actions == nil {
+		input.Actions = map[string]bool{}
+	}
+
+	for _, action := range self.Actions {
+		held := false
+
+		for _, key := range action.Keys {
+			held = held || input.KeyPressed[key]
+		}
+
+		for _, button := range action.Mouse {
+			held = held || input.MouseDown[button]
+		}
+
+		for _, button := range action.Gamepad {
+			held = held || input.GamepadDown[button]
+		}
+
+		input.Actions[action.Name] = held
+	}
+
+	if input.Axes == nil {
+		input.Axes = map[string]float64{}
+	}
+
+	for _, axis := range self.Axes {
+		value := 0.0
+
+		for _, key := range axis.Negative {
+			if input.KeyPressed[key] {
+				value -= 1
+				break
+			}
+		}
+
+		for _, key := range axis.Positive {
+			if input.KeyPressed[key] {
+				value += 1
+				break
+			}
+		}
+
+		if axis.Gamepad >= 0 {
+			if stick := input.GamepadAxes[axis.Gamepad]; gomath.Abs(stick) > GAMEPAD_DEAD_ZONE {
+				value = stick
+			}
+		}
+
+		input.Axes[axis.Name] = gomath.Max(-1, gomath.Min(1, value))
+	}
+
+	if self.Cursor {
+		input.Cursor = input.MousePosition
+	}
+}
+
+// Encodes the actions, axes and cursor of the input for the network.
+func (self *InputMap) Encode(input *Input) NetworkInput {
+	result := NetworkInput{Buttons: self.ToButtons(input)}
+
+	if len(self.Axes) > 0 {
+		result.Axes = make([]int8, len(self.Axes))
+
+		for i, axis := range self.Axes {
+			result.Axes[i] = int8(gomath.Round(gomath.Max(-1, gomath.Min(1, input.Axes[axis.Name])) * 127))
+		}
+	}
+
+	if self.Cursor {
+		result.HasCursor = true
+		result.CursorX = int16(gomath.Max(gomath.MinInt16, gomath.Min(gomath.MaxInt16, gomath.Round(input.Cursor.X()))))
+		result.CursorY = int16(gomath.Max(gomath.MinInt16, gomath.Min(gomath.MaxInt16, gomath.Round(input.Cursor.Y()))))
+	}
+
+	return result
+}
+
+// Sets the actions, axes and cursor of the input from the network. Axes the client didn't send are
+// centered and the cursor is left where it was if it wasn't sent.
+func (self *InputMap) Decode(networkInput NetworkInput, input *Input) {
+	self.FromButtons(networkInput.Buttons, input)
+
+	if input.Axes == nil {
+		input.Axes = map[string]float64{}
+	}
+
+	for i, axis := range self.Axes {
+		value := 0.0
+
+		if i < len(networkInput.Axes) {
+			value = float64(networkInput.Axes[i]) / 127
+		}
+
+		input.Axes[axis.Name] = value
+	}
+
+	if networkInput.HasCursor {
+		input.Cursor = math.NewVector(float64(networkInput.CursorX), float64(networkInput.CursorY))
+	}
+}
+
+// Encodes the held actions of the input for the network, one bit per action.
+func (self *InputMap) ToButtons(input *Input) []byte {
+	buttons := make([]byte, (len(self.Actions)+7)/8)
+
+	for i, action := range self.Actions {
+		if input.Actions[action.Name] {
+			setBit(&buttons[i/8], uint(i%8))
+		}
+	}
+
+	return buttons
+}
+
+// Sets the actions of the input from the network, actions past the end of buttons aren't held.
+// The first key bound to each action is set too for code that still reads keys.
+func (self *InputMap) FromButtons(buttons []byte, input *Input) {
+	if input.Actions == nil {
+		input.Actions = map[string]bool{}
+	}
+
+	if input.KeyPressed == nil {
+		input.KeyPressed = map[KeyCode]bool{}
+	}
+
+	for i, action := range self.Actions {
+		held := i/8 < len(buttons) && hasBit(buttons[i/8], uint(i%8))
+
+		input.Actions[action.Name] = held
+
+		if len(action.Keys) > 0 {
+			input.KeyPressed[action.Keys[0]] = held
+		}
+	}
+}
+
+// Turns the last known input of a remote player into the prediction for the next tick. Actions
+// predicted released are released with their first key, everything else repeats.
+func (self *InputMap) Predict(input *Input) {
+	for _, action := range self.Actions {
+		if !action.Release {
+			continue
+		}
+
+		input.Actions[action.Name] = false
+
+		if len(action.Keys) > 0 {
+			input.KeyPressed[action.Keys[0]] = false
+		}
+	}
+}
+
+// Input map of the world's game data, the default one if there's none.
+func (w *World) InputMap() *InputMap {
+	if w.PrefabData != nil && w.PrefabData.InputMap != nil {
+		return w.PrefabData.InputMap
+	}
+	return DefaultInputMap()
+}
+
+var keyNames = map[string]KeyCode{
+	"Backspace":  8,
+	"Tab":        9,
+	"Enter":      13,
+	"Shift":      16,
+	"Control":    17,
+	"Alt":        18,
+	"Escape":     27,
+	"Space":      32,
+	"ArrowLeft":  37,
+	"ArrowUp":    38,
+	"ArrowRight": 39,
+	"ArrowDown":  40,
+}
+
+// Key code of a key name: the names in keyNames, a letter A-Z, a digit 0-9 or a key code number.
+func KeyCodeFromName(name string) (KeyCode, error) {
+	if keyCode, ok := keyNames[name]; ok {
+		return keyCode, nil
+	}
+
+	if len(name) == 1 && ((name[0] >= 'A' && name[0] <= 'Z') || (name[0] >= '0' && name[0] <= '9')) {
+		return KeyCode(name[0]), nil
+	}
+
+	if keyCode, err := strconv.Atoi(name); err == nil && keyCode > 0 {
+		return KeyCode(keyCode), nil
+	}
+
+	return -1, fmt.Errorf("unknown key %q", name)
+}
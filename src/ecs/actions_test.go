@@ -0,0 +1,238 @@
+package ecs_test
+
+import (
+	"fmt"
+	"github.com/Banyango/io-engine/src/ecs"
+	"github.com/Banyango/io-engine/src/math"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+const actionsGameJson = `{
+	"input": {
+		"actions": [
+			{ "name": "jump", "keys": ["Space", "W"], "gamepad": [0] },
+			{ "name": "fire", "mouse": [0] },
+			{ "name": "reload", "keys": ["82"] }
+		]
+	},
+	"prefabs": {}
+}`
+
+func createInputMap(t *testing.T) *ecs.InputMap {
+	world := ecs.NewWorld()
+	world.StrictLoading = true
+
+	prefabData, err := ecs.NewPrefabManager(actionsGameJson, world)
+	assert.NoError(t, err)
+
+	world.PrefabData = prefabData
+	assert.Equal(t, prefabData.InputMap, world.InputMap())
+
+	return prefabData.InputMap
+}
+
+func TestInputMap_Resolve(t *testing.T) {
+	inputMap := createInputMap(t)
+
+	assert.Equal(t, []ecs.KeyCode{32, 87}, inputMap.Actions[0].Keys)
+	assert.Equal(t, []ecs.KeyCode{82}, inputMap.Actions[2].Keys)
+
+	input := ecs.NewInput()
+	input.KeyPressed[87] = true
+	input.MouseDown[0] = true
+
+	inputMap.Resolve(input)
+
+	assert.True(t, input.Action("jump"))
+	assert.True(t, input.Action("fire"))
+	assert.False(t, input.Action("reload"))
+	assert.False(t, input.Action("missing"))
+
+	input.KeyPressed[87] = false
+	input.GamepadDown[0] = true
+
+	inputMap.Resolve(input)
+
+	assert.True(t, input.Action("jump"))
+}
+
+func TestInputMap_Network(t *testing.T) {
+	inputMap := createInputMap(t)
+
+	input := ecs.NewInput()
+	input.Actions["jump"] = true
+	input.Actions["reload"] = true
+
+	buttons := inputMap.ToButtons(input)
+	assert.Equal(t, []byte{0x5}, buttons)
+
+	received := ecs.NewInput()
+	inputMap.FromButtons(buttons, received)
+
+	assert.True(t, received.Action("jump"))
+	assert.False(t, received.Action("fire"))
+	assert.True(t, received.Action("reload"))
+	assert.True(t, received.AnyAction())
+
+	// the first key of each action is set for code reading keys.
+	assert.True(t, received.KeyPressed[32])
+	assert.False(t, received.KeyPressed[87])
+
+	inputMap.FromButtons(nil, received)
+	assert.False(t, received.AnyAction())
+}
+
+func TestInputMap_MoreThanEightActions(t *testing.T) {
+	actions := []ecs.InputActionJson{}
+	for i := 0; i < 12; i++ {
+		actions = append(actions, ecs.InputActionJson{Name: fmt.Sprintf("action_%d", i)})
+	}
+
+	inputMap, errs := ecs.NewInputMap(ecs.InputJson{Actions: actions})
+	assert.Empty(t, errs)
+
+	input := ecs.NewInput()
+	input.Actions["action_1"] = true
+	input.Actions["action_11"] = true
+
+	networkInput := inputMap.Encode(input)
+	assert.Equal(t, []byte{0x2, 0x8}, networkInput.Buttons)
+
+	received := ecs.NewInput()
+	inputMap.Decode(networkInput, received)
+
+	assert.True(t, received.Action("action_1"))
+	assert.True(t, received.Action("action_11"))
+	assert.False(t, received.Action("action_8"))
+}
+
+func TestInputMap_DefaultActions(t *testing.T) {
+	world := ecs.NewWorld()
+
+	prefabData, err := ecs.NewPrefabManager(`{"prefabs": {}}`, world)
+	assert.NoError(t, err)
+
+	world.PrefabData = prefabData
+
+	input := ecs.NewInput()
+	input.KeyPressed[ecs.Up] = true
+	input.KeyPressed[ecs.X] = true
+
+	world.InputMap().Resolve(input)
+
+	assert.True(t, input.Action("move_up"))
+	assert.True(t, input.Action("fire"))
+	assert.Equal(t, input.ToBytes(), world.InputMap().ToButtons(input))
+}
+
+func TestInputMapErrors(t *testing.T) {
+	world := ecs.NewWorld()
+	world.StrictLoading = true
+
+	_, err := ecs.NewPrefabManager(`{
+		"input": {
+			"actions": [
+				{ "name": "jump", "keys": ["Space", "Jump"] },
+				{ "name": "jump" },
+				{ "keys": ["W"] }
+			]
+		},
+		"prefabs": {}
+	}`, world)
+
+	assert.EqualError(t, err, "3 problem(s) loading game data:\n"+
+		"  - field \"input.actions.0.keys.1\": unknown key \"Jump\"\n"+
+		"  - field \"input.actions.1.name\": action \"jump\" is already declared\n"+
+		"  - field \"input.actions.2.name\": action must have a name")
+}
+
+// A NetworkInput holds at most MAX_INPUT_BUTTON_BYTES of buttons and MAX_INPUT_AXES axes.
+func TestInputMap_TooManyActionsAndAxes(t *testing.T) {
+	data := ecs.InputJson{}
+
+	for i := 0; i <= ecs.MAX_INPUT_BUTTON_BYTES*8; i++ {
+		data.Actions = append(data.Actions, ecs.InputActionJson{Name: fmt.Sprintf("action_%d", i)})
+	}
+
+	for i := 0; i <= ecs.MAX_INPUT_AXES; i++ {
+		data.Axes = append(data.Axes, ecs.InputAxisJson{Name: fmt.Sprintf("axis_%d", i)})
+	}
+
+	_, errs := ecs.NewInputMap(data)
+
+	assert.EqualError(t, errs, "2 problem(s) loading game data:\n"+
+		"  - field \"input.actions\": 257 actions, at most 256 are supported\n"+
+		"  - field \"input.axes\": 65 axes, at most 64 are supported")
+
+	data.Actions = data.Actions[:ecs.MAX_INPUT_BUTTON_BYTES*8]
+	data.Axes = data.Axes[:ecs.MAX_INPUT_AXES]
+
+	inputMap, errs := ecs.NewInputMap(data)
+	assert.Empty(t, errs)
+
+	input := ecs.NewInput()
+	input.Actions["action_255"] = true
+	input.Axes["axis_63"] = -1
+
+	// the most the wire format carries.
+	networkInput := inputMap.Encode(input)
+	assert.Equal(t, ecs.MAX_INPUT_BUTTON_BYTES, len(networkInput.Buttons))
+	assert.Equal(t, ecs.MAX_INPUT_AXES, len(networkInput.Axes))
+}
+
+func TestInputMap_AxesAndCursor(t *testing.T) {
+	world := ecs.NewWorld()
+	world.StrictLoading = true
+
+	prefabData, err := ecs.NewPrefabManager(`{
+		"input": {
+			"actions": [ { "name": "fire", "mouse": [0] } ],
+			"axes": [
+				{ "name": "move_x", "negative": ["A"], "positive": ["D"], "gamepad": 0 },
+				{ "name": "move_y", "negative": ["W"], "positive": ["S"], "gamepad": 1 }
+			],
+			"cursor": true
+		},
+		"prefabs": {}
+	}`, world)
+	assert.NoError(t, err)
+
+	inputMap := prefabData.InputMap
+
+	input := ecs.NewInput()
+	input.KeyPressed['D'] = true
+	input.GamepadAxes[0] = 0.1 // inside the dead zone, the keys win
+	input.GamepadAxes[1] = -0.5
+	input.MousePosition = math.NewVector(120.4, -33.6)
+
+	inputMap.Resolve(input)
+
+	assert.Equal(t, 1.0, input.Axis("move_x"))
+	assert.Equal(t, -0.5, input.Axis("move_y"))
+	assert.Equal(t, math.NewVector(120.4, -33.6), input.Cursor)
+
+	networkInput := inputMap.Encode(input)
+
+	assert.Equal(t, ecs.NetworkInput{Buttons: []byte{0}, Axes: []int8{127, -64}, HasCursor: true, CursorX: 120, CursorY: -34}, networkInput)
+
+	received := ecs.NewInput()
+	inputMap.Decode(networkInput, received)
+
+	assert.Equal(t, 1.0, received.Axis("move_x"))
+	assert.InDelta(t, -0.5, received.Axis("move_y"), 0.01)
+	assert.Equal(t, math.NewVector(120, -34), received.Cursor)
+
+	// nothing received, the axes are centered and the cursor stays.
+	inputMap.Decode(ecs.NetworkInput{}, received)
+
+	assert.Equal(t, 0.0, received.Axis("move_x"))
+	assert.Equal(t, math.NewVector(120, -34), received.Cursor)
+
+	// clones are cached for rollback.
+	clone := received.Clone()
+	received.Axes["move_x"] = 1
+
+	assert.Equal(t, 0.0, clone.Axis("move_x"))
+	assert.Equal(t, math.NewVector(120, -34), clone.Cursor)
+}
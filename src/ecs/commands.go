@@ -0,0 +1,32 @@
+package ecs
+
+/**
+Commands
+
+The world is owned by the tick goroutine. Network goroutines (websocket readers,
+webrtc callbacks, http handlers) must never touch entities, input or systems
+directly. Instead they queue a Command which is run on the tick goroutine at the
+start of the next Update, before any system runs.
+*/
+
+type Command func(w *World)
+
+// queues a command to run on the tick goroutine. Safe to call from any goroutine.
+func (w *World) Enqueue(command Command) {
+	w.commandMux.Lock()
+	w.commands = append(w.commands, command)
+	w.commandMux.Unlock()
+}
+
+// runs all queued commands in the order they were queued.
+// Must only be called from the tick goroutine.
+func (w *World) RunCommands() {
+	w.commandMux.Lock()
+	commands := w.commands
+	w.commands = nil
+	w.commandMux.Unlock()
+
+	for _, command := range commands {
+		command(w)
+	}
+}
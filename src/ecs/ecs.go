@@ -2,10 +2,11 @@ package ecs
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
-	"github.com/goburrow/dynamic"
 	"reflect"
 	"strconv"
+	"strings"
 	"sync"
 )
 
@@ -59,6 +60,7 @@ func (entity Entity) Clone() *Entity {
 
 	result := Entity{}
 	result.Id = entity.Id
+	result.PrefabId = entity.PrefabId
 
 	result.Components = map[int]Component{}
 
@@ -150,17 +152,25 @@ type World struct {
 	RenderSystems []*System
 
 	Entities map[int64]*Entity
+	Static   []Entity // the map's entities as they were loaded, Reset adds them back
 
 	Cache           []map[int64]*Entity
 	CacheInput      []*InputController
+	CacheTimers     []*TimerScheduler
+	CacheRand       []Random
 	ValidatedBuffer int32
 	IsResimulating  bool
 
 	ToSpawn   []Entity
 	ToDestroy []int64
 
-	Input  *InputController
-	Future []*BufferedInput
+	Input          *InputController
+	Future         []*BufferedInput
+	ConfirmedInput map[int64]map[PlayerId]NetworkInput // remote inputs the server has confirmed by tick
+	Timers         *TimerScheduler
+
+	Rand       *Random
+	RandomSeed uint64
 
 	TimeElapsed      int64
 	LastFrameTime    int64
@@ -172,17 +182,24 @@ type World struct {
 
 	Interval   int64
 	PrefabData *PrefabData
-	Mux        sync.Mutex
+
+	commands   []Command
+	commandMux sync.Mutex
 
 	Log    Logger
 	Paused bool
+
+	// collect every game data problem into LoadErrors instead of printing and ignoring them.
+	StrictLoading bool
 }
 
 func NewWorld() *World {
 	world := new(World)
 
 	world.Entities = map[int64]*Entity{}
-	world.Input = &InputController{map[PlayerId]*Input{0: NewInput()}}
+	world.Input = &InputController{Player: map[PlayerId]*Input{0: NewInput()}}
+	world.Timers = NewTimerScheduler()
+	world.Seed(0)
 	world.Log = DefaultLogger{}
 	world.Interval = 16
 	world.Paused = false
@@ -193,8 +210,12 @@ func NewWorld() *World {
 func (w *World) Update(delta float64) {
 	if !w.IsResimulating {
 		w.CurrentTick++
+		w.RunCommands()
 	}
 
+	w.updateTimers()
+	w.updateRemoteInput()
+
 	for _, v := range w.Systems {
 		(*v).UpdateSystem(delta, w)
 	}
@@ -250,43 +271,65 @@ func (w *World) CreateMultipleEntitiesFromJson(jsonStr string) (e []*Entity, er
 
 func (w *World) CreateEntityFromJson(jsonStr string) (e Entity, er error) {
 
-	var data map[string][]json.RawMessage
-
-	err := json.Unmarshal([]byte(jsonStr), &data)
-
-	components := data["components"]
+	var data struct {
+		Id         json.RawMessage   `json:"id"`
+		Components []json.RawMessage `json:"components"`
+	}
 
-	var idJson struct {
-		Id string
+	if err := json.Unmarshal([]byte(jsonStr), &data); err != nil {
+		if w.StrictLoading {
+			return Entity{}, LoadErrors{{ComponentIndex: -1, Err: err}}
+		}
+		return Entity{}, err
 	}
 
-	err = json.Unmarshal([]byte(jsonStr), &idJson)
+	errs := LoadErrors{}
 
-	id, err := strconv.ParseInt(idJson.Id, 10, 64)
+	// ids are written both as "0" and 0 in game data.
+	id, err := strconv.ParseInt(strings.Trim(string(data.Id), `"`), 10, 64)
+
+	if err != nil && w.StrictLoading {
+		errs = append(errs, LoadError{ComponentIndex: -1, Field: "id", Err: errors.New("id must be an integer")})
+	}
 
 	entity := Entity{Id: id, Components: make(map[int]Component)}
 
-	for i := range components {
+	for i := range data.Components {
 
-		component := components[i]
+		component, problems := decodeComponent(data.Components[i], w.StrictLoading)
 
-		var comp dynamic.Type
+		for _, problem := range problems {
+			problem.ComponentIndex = i
+			errs = append(errs, problem)
+		}
+
+		if len(problems) > 0 {
+			if w.StrictLoading {
+				continue
+			}
 
-		err = json.Unmarshal(component, &comp)
+			fmt.Println(LoadErrors(problems))
 
-		v := comp.Value()
+			// only the bad fields are skipped when the type is known.
+			if component == nil {
+				fmt.Println("Entity {", entity.Id, "}", " Component ignored =", string(data.Components[i]))
+				continue
+			}
+		}
 
-		if v != nil {
-			value := v.(Component)
-			entity.Components[value.Id()] = value
-		} else {
-			fmt.Println(err)
-			fmt.Println("Entity {", entity.Id, "}", " Component ignored =", string(component))
+		if _, ok := entity.Components[component.Id()]; ok && w.StrictLoading {
+			errs = append(errs, LoadError{ComponentIndex: i, ComponentType: reflect.TypeOf(component).Elem().Name(), Err: errors.New("component type is already on the entity")})
+			continue
 		}
 
+		entity.Components[component.Id()] = component
 	}
 
-	return entity, err
+	if w.StrictLoading && len(errs) > 0 {
+		return entity, errs
+	}
+
+	return entity, nil
 
 }
 
@@ -351,6 +394,9 @@ func (w *World) AddEntityToWorld(entity Entity) {
 	}
 
 	//w.Log.LogInfo("added entity: ", entity.Id)
+	if w.Entities == nil {
+		w.Entities = map[int64]*Entity{}
+	}
 	w.Entities[entity.Id] = &entity
 }
 
@@ -439,6 +485,8 @@ func (w *World) ResetToTick(tick int64) {
 		}
 
 		w.Input = w.CacheInput[index]
+		w.Timers = w.CacheTimers[index].Clone()
+		*w.Rand = w.CacheRand[index]
 
 		mask := int32(0)
 		for i := 0; i < diff; i++ {
@@ -448,6 +496,8 @@ func (w *World) ResetToTick(tick int64) {
 		w.ValidatedBuffer = w.ValidatedBuffer | mask
 
 		w.Cache = w.Cache[:index]
+		w.CacheTimers = w.CacheTimers[:index]
+		w.CacheRand = w.CacheRand[:index]
 	}
 
 }
@@ -458,11 +508,28 @@ func (w *World) Resimulate(tick int64) {
 
 	index := len(w.CacheInput) - int(diff)
 
+	// remote inputs are predicted from the tick before, not from what was predicted the first time.
+	var remote map[PlayerId]*Input
+	if index > 0 && w.CacheInput[index-1].Remote != nil {
+		remote = cloneInputs(w.CacheInput[index-1].Remote)
+	}
+
 	w.IsResimulating = true
 	for i := index; i < len(w.CacheInput); i++ {
-		clone := w.CacheInput[index].Clone()
+		clone := w.CacheInput[i].Clone()
+		clone.Remote = remote
 		w.Input = &clone
+
+		// systems see the tick they replay, it's back to the current tick after the last one.
+		w.CurrentTick = tick + int64(i-index) + 1
 		w.Update(FIXED_DELTA)
+
+		remote = w.Input.Remote
+
+		// keep the corrected prediction for later resimulations.
+		if remote != nil {
+			w.CacheInput[i].Remote = cloneInputs(remote)
+		}
 	}
 	w.IsResimulating = false
 
@@ -478,6 +545,8 @@ func (w *World) CacheState() {
 	inputClone := w.Input.Clone()
 
 	w.Cache = append(w.Cache, clone)
+	w.CacheTimers = append(w.CacheTimers, w.Timers.Clone())
+	w.CacheRand = append(w.CacheRand, *w.Rand)
 
 	if !w.IsResimulating {
 		w.CacheInput = append(w.CacheInput, &inputClone)
@@ -485,11 +554,14 @@ func (w *World) CacheState() {
 
 	w.ValidatedBuffer = w.ValidatedBuffer << 1
 
-	if len(w.Cache) > 32 {
+	if len(w.Cache) > MAX_CACHE_SIZE {
 		w.Cache = w.Cache[1:]
-		if !w.IsResimulating {
-			w.CacheInput = w.CacheInput[1:]
-		}
+		w.CacheTimers = w.CacheTimers[1:]
+		w.CacheRand = w.CacheRand[1:]
+	}
+
+	if len(w.CacheInput) > MAX_CACHE_SIZE {
+		w.CacheInput = w.CacheInput[1:]
 	}
 }
 
@@ -527,6 +599,8 @@ func (w *World) CompareEntitiesAtTick(tick int64, tempEntity *Entity) (same bool
 func (w *World) SetToTick(tick int64) {
 	w.Cache = w.Cache[:0]
 	w.CacheInput = w.CacheInput[:0]
+	w.CacheTimers = w.CacheTimers[:0]
+	w.CacheRand = w.CacheRand[:0]
 	w.CurrentTick = tick
 
 	for i := 0; i < MAX_CACHE_SIZE; i++ {
@@ -534,11 +608,15 @@ func (w *World) SetToTick(tick int64) {
 	}
 }
 
-func (w *World) SetFutureInput(tick int64, inputBytes byte, id PlayerId) {
+// Buffers the input of a player for a tick that hasn't run yet. Clients resend inputs until they're
+// acked so the first copy of a tick wins and later ones are ignored. Returns false if the tick has
+// already run and the input was dropped.
+func (w *World) SetFutureInput(tick int64, input NetworkInput, id PlayerId) bool {
 
-	if tick < w.CurrentTick {
+	// the current tick already collected its input.
+	if tick <= w.CurrentTick {
 		//fmt.Println("Received Past input got ", tick, " at ", w.CurrentTick)
-		return
+		return false
 	}
 
 	index := -1
@@ -551,33 +629,47 @@ func (w *World) SetFutureInput(tick int64, inputBytes byte, id PlayerId) {
 	}
 
 	if index >= 0 {
-		w.Future[index].Bytes[id] = inputBytes
+		if _, ok := w.Future[index].Inputs[id]; !ok {
+			w.Future[index].Inputs[id] = input
+		}
 	} else {
-		buffer := BufferedInput{Tick: tick, Bytes: map[PlayerId]byte{id: inputBytes}}
+		buffer := BufferedInput{Tick: tick, Inputs: map[PlayerId]NetworkInput{id: input}}
 		w.Future = append(w.Future, &buffer)
 	}
 
+	return true
+}
+
+// Input buffered for a player at a tick that hasn't run yet.
+func (w *World) FutureInput(tick int64, id PlayerId) (NetworkInput, bool) {
+	for i := range w.Future {
+		if w.Future[i].Tick == tick {
+			input, ok := w.Future[i].Inputs[id]
+			return input, ok
+		}
+	}
+	return NetworkInput{}, false
 }
 
+// Input of a player, the remote input on a client for the other players, nil if there's none.
 func (w *World) InputForPlayer(id PlayerId) *Input {
-	w.Mux.Lock()
-	defer w.Mux.Unlock()
 	if input, ok := w.Input.Player[id]; ok {
 		return input
 	}
-	return nil
+	return w.RemoteInput(id)
 }
 
 func (w *World) Reset() {
-	w.Mux.Lock()
-	defer w.Mux.Unlock()
-
 	for _, ent := range w.Entities {
 		w.RemoveEntity(ent.Id)
 	}
 
 	w.Cache = w.Cache[:0]
 	w.CacheInput = w.CacheInput[:0]
+	w.CacheTimers = w.CacheTimers[:0]
+	w.CacheRand = w.CacheRand[:0]
+	w.Timers = NewTimerScheduler()
+	w.Seed(w.RandomSeed)
 	w.CurrentTick = 0
 	w.LastServerTick = 0
 	w.IdIndex = 0
@@ -585,10 +677,15 @@ func (w *World) Reset() {
 	w.LastServerTick = 0
 	w.ToSpawn = []Entity{}
 	w.ToDestroy = []int64{}
+	w.ConfirmedInput = nil
+
+	for _, entity := range w.Static {
+		w.addStaticEntity(entity)
+	}
 }
 
 func (w *World) ResetInput(id PlayerId) {
 	if input, ok := w.Input.Player[id]; ok {
-		input.InputFromBytes(0)
+		w.InputMap().Decode(NetworkInput{}, input)
 	}
 }
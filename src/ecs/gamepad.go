@@ -0,0 +1,48 @@
+package ecs
+
+import (
+	"math"
+)
+
+// State of one connected gamepad in the standard layout, as polled from the browser Gamepad API.
+type GamepadState struct {
+	Buttons []bool
+	Axes    []float64 // -1 to 1
+}
+
+// The standard layout's sticks, as pairs of axes. Nothing reads a stick directly, games declare axes
+// on them in game data, see InputAxisJson.Gamepad.
+var gamepadSticks = [][2]int{{0, 1}, {2, 3}}
+
+// Sets GamepadDown and GamepadAxes from every connected pad, the previous state is replaced. A button
+// is down if it's down on any pad and an axis takes the pad pushing it furthest. A stick resting
+// inside GAMEPAD_DEAD_ZONE reads as centered on both of its axes so it can't drift diagonally.
+func (self *Input) SetGamepads(pads []GamepadState) {
+	self.GamepadDown = map[int]bool{}
+	self.GamepadAxes = map[int]float64{}
+
+	for _, pad := range pads {
+		for i, down := range pad.Buttons {
+			if down {
+				self.GamepadDown[i] = true
+			}
+		}
+
+		axes := append([]float64{}, pad.Axes...)
+
+		for _, stick := range gamepadSticks {
+			if stick[1] < len(axes) && math.Hypot(axes[stick[0]], axes[stick[1]]) < GAMEPAD_DEAD_ZONE {
+				axes[stick[0]] = 0
+				axes[stick[1]] = 0
+			}
+		}
+
+		for i, value := range axes {
+			value = math.Max(-1, math.Min(1, value))
+
+			if math.Abs(value) > math.Abs(self.GamepadAxes[i]) {
+				self.GamepadAxes[i] = value
+			}
+		}
+	}
+}
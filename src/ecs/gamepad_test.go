@@ -0,0 +1,39 @@
+package ecs_test
+
+import (
+	"github.com/Banyango/io-engine/src/ecs"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestInput_SetGamepads(t *testing.T) {
+	input := ecs.NewInput()
+	input.GamepadDown[3] = true
+
+	input.SetGamepads([]ecs.GamepadState{
+		{Buttons: []bool{true, false}, Axes: []float64{0.1, 0.1, 0.5, -0.2}},
+		{Buttons: []bool{false, false, true}, Axes: []float64{-0.3, 0.05, -0.9, 1.5}},
+	})
+
+	// buttons of either pad, the old state is gone.
+	assert.Equal(t, map[int]bool{0: true, 2: true}, input.GamepadDown)
+
+	// the first pad's left stick rests in the dead zone, the furthest pushed axis wins.
+	assert.Equal(t, map[int]float64{0: -0.3, 1: 0.05, 2: -0.9, 3: 1}, input.GamepadAxes)
+
+	input.SetGamepads(nil)
+
+	assert.Empty(t, input.GamepadDown)
+	assert.Empty(t, input.GamepadAxes)
+}
+
+func TestInput_SetGamepadsResolvesActions(t *testing.T) {
+	inputMap := createInputMap(t)
+
+	input := ecs.NewInput()
+	input.SetGamepads([]ecs.GamepadState{{}, {Buttons: []bool{true}}})
+
+	inputMap.Resolve(input)
+
+	assert.True(t, input.Action("jump"))
+}
@@ -0,0 +1,204 @@
+package ecs
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+/**
+Prefab inheritance
+
+A prefab can declare "extends": "<parent prefab name>" to start from the parent's
+components. Components are matched by Type:
+  - a component the parent doesn't have is added.
+  - a component the parent has is overridden field by field, so a child only needs to
+    list the fields it changes.
+  - "remove": ["<Type>", ...] drops components inherited from the parent.
+
+The id and name are never inherited. Prefabs are flattened here before they're loaded
+so PrefabData.Prefabs only ever holds complete entities.
+*/
+
+type prefabJson struct {
+	Id         json.RawMessage   `json:"id"`
+	Name       string            `json:"name,omitempty"`
+	Extends    string            `json:"extends,omitempty"`
+	Remove     []string          `json:"remove,omitempty"`
+	Components []json.RawMessage `json:"components"`
+}
+
+// one component as a set of raw fields, so overrides can be merged into it.
+type prefabComponent struct {
+	Type   string
+	Fields map[string]json.RawMessage
+}
+
+type prefabResolver struct {
+	raw      map[string]json.RawMessage
+	resolved map[string][]prefabComponent
+	problems map[string]LoadErrors
+}
+
+func newPrefabResolver(raw map[string]json.RawMessage) *prefabResolver {
+	return &prefabResolver{
+		raw:      raw,
+		resolved: map[string][]prefabComponent{},
+		problems: map[string]LoadErrors{},
+	}
+}
+
+// Returns the prefab with its parents flattened into it.
+// Prefabs that don't extend anything are returned untouched.
+func (self *prefabResolver) Flatten(name string) (json.RawMessage, LoadErrors) {
+	prefab := prefabJson{}
+
+	if err := json.Unmarshal(self.raw[name], &prefab); err != nil {
+		return nil, LoadErrors{{Prefab: name, ComponentIndex: -1, Err: err}}
+	}
+
+	if prefab.Extends == "" && len(prefab.Remove) == 0 {
+		return self.raw[name], nil
+	}
+
+	components, errs := self.components(name, nil)
+
+	if components == nil {
+		return nil, errs
+	}
+
+	flat := prefabJson{Id: prefab.Id, Name: prefab.Name}
+
+	if flat.Id == nil {
+		flat.Id = json.RawMessage("null")
+	}
+
+	for _, component := range components {
+		data, err := json.Marshal(component.Fields)
+
+		if err != nil {
+			errs = append(errs, LoadError{Prefab: name, ComponentIndex: -1, ComponentType: component.Type, Err: err})
+			continue
+		}
+
+		flat.Components = append(flat.Components, data)
+	}
+
+	data, err := json.Marshal(flat)
+
+	if err != nil {
+		return nil, append(errs, LoadError{Prefab: name, ComponentIndex: -1, Err: err})
+	}
+
+	return data, errs
+}
+
+// Resolves the components of a prefab. chain holds the prefabs currently being resolved and is used
+// to detect cycles. Returns nil components if the prefab can't be resolved at all.
+func (self *prefabResolver) components(name string, chain []string) ([]prefabComponent, LoadErrors) {
+	if components, ok := self.resolved[name]; ok {
+		return components, self.problems[name]
+	}
+
+	for i := range chain {
+		if chain[i] == name {
+			cycle := append(append([]string{}, chain[i:]...), name)
+			return nil, LoadErrors{{Prefab: chain[0], ComponentIndex: -1, Field: "extends", Err: fmt.Errorf("inheritance cycle %s", strings.Join(cycle, " -> "))}}
+		}
+	}
+
+	prefab := prefabJson{}
+
+	if err := json.Unmarshal(self.raw[name], &prefab); err != nil {
+		return nil, LoadErrors{{Prefab: name, ComponentIndex: -1, Err: err}}
+	}
+
+	errs := LoadErrors{}
+	components := []prefabComponent{}
+
+	if prefab.Extends != "" {
+		if _, ok := self.raw[prefab.Extends]; !ok {
+			return nil, LoadErrors{{Prefab: name, ComponentIndex: -1, Field: "extends", Err: fmt.Errorf("parent prefab %q doesn't exist", prefab.Extends)}}
+		}
+
+		// the parent's own problems are reported when the parent is flattened.
+		parent, parentErrs := self.components(prefab.Extends, append(chain, name))
+
+		if parent == nil {
+			return nil, parentErrs
+		}
+
+		// copied so the child never modifies the parent.
+		for _, component := range parent {
+			components = append(components, component.clone())
+		}
+	} else if len(prefab.Remove) > 0 {
+		errs = append(errs, LoadError{Prefab: name, ComponentIndex: -1, Field: "remove", Err: errors.New("remove can only be used with extends")})
+	}
+
+	for _, typeName := range prefab.Remove {
+		index := indexOfComponent(components, typeName)
+
+		if index < 0 {
+			if prefab.Extends != "" {
+				errs = append(errs, LoadError{Prefab: name, ComponentIndex: -1, ComponentType: typeName, Field: "remove", Err: fmt.Errorf("parent prefab %q doesn't have this component", prefab.Extends)})
+			}
+			continue
+		}
+
+		components = append(components[:index], components[index+1:]...)
+	}
+
+	for i, raw := range prefab.Components {
+		fields := map[string]json.RawMessage{}
+
+		if err := json.Unmarshal(raw, &fields); err != nil {
+			errs = append(errs, LoadError{Prefab: name, ComponentIndex: i, Err: err})
+			continue
+		}
+
+		var typeName string
+
+		// components without a type are passed through and reported when they're loaded.
+		if err := json.Unmarshal(fields["Type"], &typeName); err != nil || typeName == "" {
+			components = append(components, prefabComponent{Fields: fields})
+			continue
+		}
+
+		index := indexOfComponent(components, typeName)
+
+		if index < 0 {
+			components = append(components, prefabComponent{Type: typeName, Fields: fields})
+			continue
+		}
+
+		for field, value := range fields {
+			components[index].Fields[field] = value
+		}
+	}
+
+	self.resolved[name] = components
+	self.problems[name] = errs
+
+	return components, errs
+}
+
+func (self prefabComponent) clone() prefabComponent {
+	fields := make(map[string]json.RawMessage, len(self.Fields))
+
+	for field, value := range self.Fields {
+		fields[field] = value
+	}
+
+	return prefabComponent{Type: self.Type, Fields: fields}
+}
+
+func indexOfComponent(components []prefabComponent, typeName string) int {
+	for i := range components {
+		if components[i].Type == typeName {
+			return i
+		}
+	}
+	return -1
+}
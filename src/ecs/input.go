@@ -8,11 +8,12 @@ type PlayerId uint16
 
 type InputController struct {
 	Player map[PlayerId]*Input
+	Remote map[PlayerId]*Input // other players on a client, predicted until confirmed, see ConfirmRemoteInput
 }
 
 type BufferedInput struct {
 	Tick int64
-	Bytes map[PlayerId]byte
+	Inputs map[PlayerId]NetworkInput
 }
 
 func (self *InputController) Clone() InputController {
@@ -24,19 +25,44 @@ func (self *InputController) Clone() InputController {
 		ic.Player[k] = v.Clone()
 	}
 
+	if self.Remote != nil {
+		ic.Remote = cloneInputs(self.Remote)
+	}
+
 	return ic
 }
 
+/**
+Input
+
+The device maps hold what's held: KeyPressed, MouseDown and GamepadDown. The pressed and released
+actions are only set on the tick an action was pressed or released. SetEdges computes them from the
+actions held on the tick before, never from device events, so they're cached with the input and come
+out the same on the server and when resimulating. Game systems read ActionPressed and ActionReleased.
+
+The key and mouse edges, KeyDown and KeyUp for keys and MousePressed and MouseUp for mouse buttons,
+are client only. The server only gets the actions, axes and cursor, so they're never set there or for
+remote players. They're set by SetDeviceEdges for the local player, for menus and debug keys.
+*/
 type Input struct {
-	KeyDown    map[KeyCode]bool
-	KeyPressed map[KeyCode]bool
-	KeyUp      map[KeyCode]bool
+	KeyDown    map[KeyCode]bool // pressed this tick, client only
+	KeyPressed map[KeyCode]bool // held
+	KeyUp      map[KeyCode]bool // released this tick, client only
 
 	MousePosition math.Vector
 
-	MouseDown    map[int]bool
-	MousePressed map[int]bool
-	MouseUp      map[int]bool
+	MouseDown    map[int]bool // held
+	MousePressed map[int]bool // pressed this tick, client only
+	MouseUp      map[int]bool // released this tick, client only
+
+	GamepadDown map[int]bool
+	GamepadAxes map[int]float64
+
+	Actions         map[string]bool // held actions, resolved from the devices by the InputMap
+	ActionsPressed  map[string]bool // actions pressed this tick
+	ActionsReleased map[string]bool // actions released this tick
+	Axes    map[string]float64 // analog axes from -1 to 1, resolved by the InputMap
+	Cursor  math.Vector        // world-space cursor, only set if the InputMap sends it
 }
 
 func (self *Input) Clone() *Input {
@@ -66,6 +92,32 @@ func (self *Input) Clone() *Input {
 		i.MouseDown[k] = v
 	}
 
+	for k, v := range self.GamepadDown {
+		i.GamepadDown[k] = v
+	}
+
+	for k, v := range self.GamepadAxes {
+		i.GamepadAxes[k] = v
+	}
+
+	for k, v := range self.Actions {
+		i.Actions[k] = v
+	}
+
+	for k, v := range self.ActionsPressed {
+		i.ActionsPressed[k] = v
+	}
+
+	for k, v := range self.ActionsReleased {
+		i.ActionsReleased[k] = v
+	}
+
+	for k, v := range self.Axes {
+		i.Axes[k] = v
+	}
+
+	i.Cursor = self.Cursor
+
 	i.MousePosition = self.MousePosition
 
 	return i
@@ -81,71 +133,152 @@ func NewInput() *Input {
 	input.MousePressed = map[int]bool{}
 	input.MouseUp = map[int]bool{}
 	input.MousePosition = math.VectorZero()
+	input.GamepadDown = map[int]bool{}
+	input.GamepadAxes = map[int]float64{}
+	input.Actions = map[string]bool{}
+	input.ActionsPressed = map[string]bool{}
+	input.ActionsReleased = map[string]bool{}
+	input.Axes = map[string]float64{}
+	input.Cursor = math.VectorZero()
 
 	return input
 }
 
-func (self *Input) AnyKeyPressed() bool {
-	for _, i := range self.KeyPressed {
-		if i {
-			return true
+// Whether the action is held, see InputMap.
+func (self *Input) Action(name string) bool {
+	return self.Actions[name]
+}
+
+// Value of the analog axis from -1 to 1, see InputMap.
+func (self *Input) Axis(name string) float64 {
+	return self.Axes[name]
+}
+
+// Whether the action was pressed this tick.
+func (self *Input) ActionPressed(name string) bool {
+	return self.ActionsPressed[name]
+}
+
+// Whether the action was released this tick.
+func (self *Input) ActionReleased(name string) bool {
+	return self.ActionsReleased[name]
+}
+
+// Sets the pressed and released actions from the actions held on the tick before, nil if none were.
+func (self *Input) SetEdges(previous *Input) {
+	if previous == nil {
+		previous = NewInput()
+	}
+
+	self.ActionsPressed, self.ActionsReleased = stringEdges(previous.Actions, self.Actions)
+}
+
+// Sets the key and mouse edges from what was held on the tick before, nil if nothing was. Client only,
+// see Input.
+func (self *Input) SetDeviceEdges(previous *Input) {
+	if previous == nil {
+		previous = NewInput()
+	}
+
+	self.KeyDown, self.KeyUp = keyEdges(previous.KeyPressed, self.KeyPressed)
+	self.MousePressed, self.MouseUp = intEdges(previous.MouseDown, self.MouseDown)
+}
+
+func keyEdges(before map[KeyCode]bool, after map[KeyCode]bool) (map[KeyCode]bool, map[KeyCode]bool) {
+	pressed, released := map[KeyCode]bool{}, map[KeyCode]bool{}
+
+	for k, held := range after {
+		if held && !before[k] {
+			pressed[k] = true
 		}
 	}
 
-	return false
+	for k, held := range before {
+		if held && !after[k] {
+			released[k] = true
+		}
+	}
+
+	return pressed, released
 }
 
-// Simplified Input - only uses up/dwn/left/right/ X and C
-func (self *Input) ToBytes() []byte {
-	value := byte(0)
+func intEdges(before map[int]bool, after map[int]bool) (map[int]bool, map[int]bool) {
+	pressed, released := map[int]bool{}, map[int]bool{}
 
-	if self.KeyPressed[Up] {
-		setBit(&value, 0)
+	for k, held := range after {
+		if held && !before[k] {
+			pressed[k] = true
+		}
 	}
 
-	if self.KeyPressed[Down] {
-		setBit(&value, 1)
+	for k, held := range before {
+		if held && !after[k] {
+			released[k] = true
+		}
 	}
 
-	if self.KeyPressed[Right] {
-		setBit(&value, 2)
+	return pressed, released
+}
+
+func stringEdges(before map[string]bool, after map[string]bool) (map[string]bool, map[string]bool) {
+	pressed, released := map[string]bool{}, map[string]bool{}
+
+	for k, held := range after {
+		if held && !before[k] {
+			pressed[k] = true
+		}
 	}
 
-	if self.KeyPressed[Left] {
-		setBit(&value, 3)
+	for k, held := range before {
+		if held && !after[k] {
+			released[k] = true
+		}
 	}
 
-	if self.KeyPressed[X] {
-		setBit(&value, 4)
+	return pressed, released
+}
+
+func (self *Input) AnyAction() bool {
+	for _, held := range self.Actions {
+		if held {
+			return true
+		}
 	}
 
-	if self.KeyPressed[C] {
-		setBit(&value, 5)
+	return false
+}
+
+func (self *Input) AnyKeyPressed() bool {
+	for _, i := range self.KeyPressed {
+		if i {
+			return true
+		}
 	}
 
-	return []byte{value}
+	return false
+}
+
+// Network buttons of the input with the default actions, see InputMap.ToButtons for game data actions.
+func (self *Input) ToBytes() []byte {
+	inputMap := DefaultInputMap()
+
+	resolved := self.Clone()
+	inputMap.Resolve(resolved)
+
+	return inputMap.ToButtons(resolved)
 }
 
 func NewInputFromBytes(value byte) Input {
-	input := Input{KeyPressed: map[KeyCode]bool{}}
+	input := Input{}
 
-	input.KeyPressed[Up] = hasBit(value, 0)
-	input.KeyPressed[Down] = hasBit(value, 1)
-	input.KeyPressed[Right] = hasBit(value, 2)
-	input.KeyPressed[Left] = hasBit(value, 3)
-	input.KeyPressed[X] = hasBit(value, 4)
-	input.KeyPressed[C] = hasBit(value, 5)
+	DefaultInputMap().FromButtons([]byte{value}, &input)
 
 	return input
 }
 
+// Sets the default actions from the network byte, see InputMap.FromButtons for game data actions.
 func (self *Input) InputFromBytes(value byte) {
-	self.KeyPressed[Up] = hasBit(value, 0)
-	self.KeyPressed[Down] = hasBit(value, 1)
-	self.KeyPressed[Right] = hasBit(value, 2)
-	self.KeyPressed[Left] = hasBit(value, 3)
-	self.KeyPressed[X] = hasBit(value, 4)
-	self.KeyPressed[C] = hasBit(value, 5)
+	DefaultInputMap().FromButtons([]byte{value}, self)
 }
 
 func hasBit(n byte, pos uint) bool {
@@ -167,3 +300,13 @@ const (
 	X     KeyCode = 88
 	C     KeyCode = 67
 )
+
+func cloneInputs(inputs map[PlayerId]*Input) map[PlayerId]*Input {
+	result := map[PlayerId]*Input{}
+
+	for k, v := range inputs {
+		result[k] = v.Clone()
+	}
+
+	return result
+}
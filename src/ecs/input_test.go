@@ -119,3 +119,71 @@ func TestReadMultiBytes(t *testing.T) {
 	assert.True(t, networkInput.KeyPressed[X]);
 	assert.True(t, networkInput.KeyPressed[Right]);
 }
+
+func TestInput_SetEdges(t *testing.T) {
+	previous := NewInput()
+	previous.KeyPressed[Up] = true
+	previous.Actions["fire"] = true
+
+	input := NewInput()
+	input.KeyPressed[C] = true
+	input.Actions["jump"] = true
+	input.Actions["fire"] = false
+
+	// stale edges are replaced.
+	input.ActionsPressed["fire"] = true
+
+	input.SetEdges(previous)
+
+	assert.True(t, input.ActionPressed("jump"))
+	assert.True(t, input.ActionReleased("fire"))
+	assert.False(t, input.ActionPressed("fire"))
+
+	// the key edges are client only.
+	assert.Empty(t, input.KeyDown)
+	assert.Empty(t, input.KeyUp)
+
+	// held on both ticks, no edges.
+	next := input.Clone()
+	next.SetEdges(input)
+
+	assert.Empty(t, next.ActionsPressed)
+	assert.Empty(t, next.ActionsReleased)
+
+	// everything held is pressed on the first tick.
+	first := NewInput()
+	first.Actions["jump"] = true
+	first.SetEdges(nil)
+
+	assert.True(t, first.ActionPressed("jump"))
+}
+
+func TestInput_SetDeviceEdges(t *testing.T) {
+	previous := NewInput()
+	previous.KeyPressed[Up] = true
+	previous.KeyPressed[X] = true
+	previous.MouseDown[0] = true
+
+	input := NewInput()
+	input.KeyPressed[Up] = true
+	input.KeyPressed[C] = true
+	input.MouseDown[2] = true
+
+	// stale edges are replaced.
+	input.KeyUp[Down] = true
+
+	input.SetDeviceEdges(previous)
+
+	assert.Equal(t, map[KeyCode]bool{C: true}, input.KeyDown)
+	assert.Equal(t, map[KeyCode]bool{X: true}, input.KeyUp)
+	assert.Equal(t, map[int]bool{2: true}, input.MousePressed)
+	assert.Equal(t, map[int]bool{0: true}, input.MouseUp)
+
+	// held on both ticks, no edges.
+	next := input.Clone()
+	next.SetDeviceEdges(input)
+
+	assert.Empty(t, next.KeyDown)
+	assert.Empty(t, next.KeyUp)
+	assert.Empty(t, next.MousePressed)
+}
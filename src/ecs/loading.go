@@ -0,0 +1,223 @@
+package ecs
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+/**
+Loading
+
+By default game data is loaded leniently, problems are printed and skipped: a component with
+a missing or unknown type is ignored and a field that fails to decode keeps the value the
+component was created with, the rest of its component still loads. With World.StrictLoading
+every problem is collected into LoadErrors instead so the server can refuse to start with
+broken game data.
+*/
+
+type LoadError struct {
+	Scene          string
+	Entity         int // index of the entity in the scene, only used with Scene
+	Prefab         string
+	ComponentIndex int // -1 if the problem isn't in a component
+	ComponentType  string
+	Field          string
+	Line           int // line in the game data, 0 if unknown. Only set by ValidateGameData
+	Err            error
+}
+
+func (e LoadError) Error() string {
+	parts := []string{}
+
+	if e.Scene != "" {
+		parts = append(parts, fmt.Sprintf("scene %q entity %d", e.Scene, e.Entity))
+	}
+
+	if e.Prefab != "" {
+		parts = append(parts, fmt.Sprintf("prefab %q", e.Prefab))
+	}
+
+	if e.ComponentIndex >= 0 {
+		if e.ComponentType != "" {
+			parts = append(parts, fmt.Sprintf("component %d (%s)", e.ComponentIndex, e.ComponentType))
+		} else {
+			parts = append(parts, fmt.Sprintf("component %d", e.ComponentIndex))
+		}
+	}
+
+	if e.Field != "" {
+		parts = append(parts, fmt.Sprintf("field %q", e.Field))
+	}
+
+	message := e.Err.Error()
+
+	if len(parts) > 0 {
+		message = strings.Join(parts, " ") + ": " + message
+	}
+
+	if e.Line > 0 {
+		message = fmt.Sprintf("line %d: %s", e.Line, message)
+	}
+
+	return message
+}
+
+type LoadErrors []LoadError
+
+func (e LoadErrors) Error() string {
+	lines := []string{fmt.Sprintf("%d problem(s) loading game data:", len(e))}
+
+	for _, err := range e {
+		lines = append(lines, "  - "+err.Error())
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// Decodes a single {"Type":"...", ...} component. Each field is decoded on its own so
+// every bad field is reported, not just the first. Unknown fields are only reported when strict.
+// The component is nil if its type is missing or unknown, bad fields are left as they were.
+func decodeComponent(raw json.RawMessage, strict bool) (Component, []LoadError) {
+	fields, typeName, factory, problems := componentFields(raw)
+
+	if problems != nil {
+		return nil, problems
+	}
+
+	value := factory()
+
+	component, ok := value.(Component)
+
+	if !ok {
+		return nil, []LoadError{{ComponentIndex: -1, ComponentType: typeName, Err: errors.New("registered type is not a Component")}}
+	}
+
+	return component, decodeFields(fields, component, typeName, strict)
+}
+
+// Patches the fields of the entity's component with the same type as a {"Type":"...", ...} override.
+// Unknown fields are always reported. If the entity doesn't have the component it's reported when
+// strict and skipped otherwise.
+func overrideComponent(entity *Entity, raw json.RawMessage, strict bool) []LoadError {
+	fields, typeName, factory, problems := componentFields(raw)
+
+	if problems != nil {
+		return problems
+	}
+
+	prototype, ok := factory().(Component)
+
+	if !ok {
+		return []LoadError{{ComponentIndex: -1, ComponentType: typeName, Err: errors.New("registered type is not a Component")}}
+	}
+
+	component, ok := entity.Components[prototype.Id()]
+
+	if !ok {
+		if strict {
+			return []LoadError{{ComponentIndex: -1, ComponentType: typeName, Field: "Type", Err: errors.New("prefab doesn't have this component")}}
+		}
+		return nil
+	}
+
+	return decodeFields(fields, component, typeName, true)
+}
+
+// Splits a component into its fields and finds the factory for its Type.
+func componentFields(raw json.RawMessage) (map[string]json.RawMessage, string, func() interface{}, []LoadError) {
+	var fields map[string]json.RawMessage
+
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return nil, "", nil, []LoadError{{ComponentIndex: -1, Err: err}}
+	}
+
+	var typeName string
+
+	if err := json.Unmarshal(fields["Type"], &typeName); err != nil || typeName == "" {
+		return nil, "", nil, []LoadError{{ComponentIndex: -1, Field: "Type", Err: errors.New("component type must be specified")}}
+	}
+
+	factory, ok := componentFactory(typeName)
+
+	if !ok {
+		return nil, "", nil, []LoadError{{ComponentIndex: -1, ComponentType: typeName, Field: "Type", Err: errors.New("unknown component type")}}
+	}
+
+	return fields, typeName, factory, nil
+}
+
+// Decodes every field except Type onto the struct the component points to.
+func decodeFields(fields map[string]json.RawMessage, component Component, typeName string, strict bool) []LoadError {
+	structValue := reflect.ValueOf(component).Elem()
+	structFields := jsonFieldIndex(structValue.Type())
+
+	// sorted so the errors come out in the same order every time.
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	problems := []LoadError{}
+
+	for _, name := range names {
+		if name == "Type" {
+			continue
+		}
+
+		index, ok := structFields[strings.ToLower(name)]
+
+		if !ok {
+			if strict {
+				problems = append(problems, LoadError{ComponentIndex: -1, ComponentType: typeName, Field: name, Err: errors.New("unknown field")})
+			}
+			continue
+		}
+
+		// decoded into a copy so a field that fails halfway isn't left half set.
+		field := structValue.Field(index)
+		decoded := reflect.New(field.Type())
+
+		if err := json.Unmarshal(fields[name], decoded.Interface()); err != nil {
+			problems = append(problems, LoadError{ComponentIndex: -1, ComponentType: typeName, Field: name, Err: err})
+			continue
+		}
+
+		field.Set(decoded.Elem())
+	}
+
+	return problems
+}
+
+// maps the lower case json name of every exported field to its index, matching encoding/json.
+func jsonFieldIndex(t reflect.Type) map[string]int {
+	result := map[string]int{}
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+
+		if field.PkgPath != "" {
+			continue
+		}
+
+		name := field.Name
+
+		if tag := field.Tag.Get("json"); tag != "" {
+			tagName := strings.Split(tag, ",")[0]
+			if tagName == "-" {
+				continue
+			}
+			if tagName != "" {
+				name = tagName
+			}
+		}
+
+		result[strings.ToLower(name)] = i
+	}
+
+	return result
+}
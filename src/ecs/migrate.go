@@ -0,0 +1,184 @@
+package ecs
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sync"
+)
+
+/**
+Migrations
+
+Game data and scene files carry the layout they were written in as "format". Files without one
+are format 1. When the layout of components changes, GameDataFormat is bumped and a migration
+from the previous format is registered, older files are then upgraded when they're loaded:
+
+	ecs.RegisterMigration(ecs.Migration{
+		From: 1,
+		Component: func(component map[string]interface{}) error {
+			if component["Type"] == "CollisionComponent" {
+				component["Velocity"] = component["Speed"]
+				delete(component, "Speed")
+			}
+			return nil
+		},
+	})
+
+"version" is the designer's version of the content and is unrelated, clients and the server must
+have the same one to play together.
+*/
+
+// Format the engine reads. Bump it together with a migration from the previous format.
+var GameDataFormat = 1
+
+type Migration struct {
+	From int // upgrades files in this format to From + 1
+
+	// Called for every component in prefabs and scenes. Optional.
+	Component func(component map[string]interface{}) error
+
+	// Called once with the whole game.json after the components. Optional.
+	GameData func(gameData map[string]interface{}) error
+
+	// Called once with a whole scene file after the components. Optional.
+	Scene func(scene map[string]interface{}) error
+}
+
+var (
+	migrations   = map[int]Migration{}
+	migrationMux sync.Mutex
+)
+
+// Registers a migration. Registering another one from the same format is a no-op.
+func RegisterMigration(migration Migration) {
+	migrationMux.Lock()
+	defer migrationMux.Unlock()
+
+	if _, ok := migrations[migration.From]; ok {
+		return
+	}
+
+	migrations[migration.From] = migration
+}
+
+// Upgrades game.json to GameDataFormat. Returns the data untouched and false if it's already current.
+func MigrateGameData(data []byte) ([]byte, bool, error) {
+	return migrateJson(data, func(root map[string]interface{}, migration Migration) error {
+		if prefabs, ok := root["prefabs"].(map[string]interface{}); ok {
+			for _, prefab := range prefabs {
+				if prefab, ok := prefab.(map[string]interface{}); ok {
+					if err := migrateComponents(prefab["components"], migration); err != nil {
+						return err
+					}
+				}
+			}
+		}
+
+		if migration.GameData != nil {
+			return migration.GameData(root)
+		}
+
+		return nil
+	})
+}
+
+// Upgrades a scene file to GameDataFormat. Returns the data untouched and false if it's already current.
+func MigrateScene(data []byte) ([]byte, bool, error) {
+	return migrateJson(data, func(root map[string]interface{}, migration Migration) error {
+		if entities, ok := root["entities"].([]interface{}); ok {
+			for _, entity := range entities {
+				if entity, ok := entity.(map[string]interface{}); ok {
+					if err := migrateComponents(entity["components"], migration); err != nil {
+						return err
+					}
+				}
+			}
+		}
+
+		if migration.Scene != nil {
+			return migration.Scene(root)
+		}
+
+		return nil
+	})
+}
+
+func migrateJson(data []byte, apply func(root map[string]interface{}, migration Migration) error) ([]byte, bool, error) {
+	var header struct {
+		Format *int `json:"format"`
+	}
+
+	if err := json.Unmarshal(data, &header); err != nil {
+		return nil, false, err
+	}
+
+	format := 1
+	if header.Format != nil {
+		format = *header.Format
+	}
+
+	if format > GameDataFormat {
+		return nil, false, fmt.Errorf("format %d is newer than the engine's format %d", format, GameDataFormat)
+	}
+
+	if format == GameDataFormat {
+		return data, false, nil
+	}
+
+	// numbers are kept as written, ids don't turn into floats.
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	root := map[string]interface{}{}
+
+	if err := decoder.Decode(&root); err != nil {
+		return nil, false, err
+	}
+
+	for ; format < GameDataFormat; format++ {
+		migrationMux.Lock()
+		migration, ok := migrations[format]
+		migrationMux.Unlock()
+
+		if !ok {
+			return nil, false, fmt.Errorf("no migration from format %d", format)
+		}
+
+		if err := apply(root, migration); err != nil {
+			return nil, false, fmt.Errorf("migrating from format %d: %v", format, err)
+		}
+	}
+
+	root["format"] = GameDataFormat
+
+	migrated, err := json.MarshalIndent(root, "", "  ")
+
+	if err != nil {
+		return nil, false, err
+	}
+
+	return migrated, true, nil
+}
+
+func migrateComponents(components interface{}, migration Migration) error {
+	if migration.Component == nil {
+		return nil
+	}
+
+	list, ok := components.([]interface{})
+
+	if !ok {
+		return nil
+	}
+
+	for _, component := range list {
+		if component, ok := component.(map[string]interface{}); ok {
+			if err := migration.Component(component); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
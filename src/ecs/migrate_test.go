@@ -0,0 +1,144 @@
+package ecs_test
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/Banyango/io-engine/src/ecs"
+	"github.com/Banyango/io-engine/src/game"
+	"github.com/Banyango/io-engine/src/math"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+// format 1 called the CircleRendererComponent radius "R".
+const oldGameJson = `{
+	"name": "test",
+	"version": "0.0.1",
+	"prefabs": {
+		"ball" : {
+			"id": "0",
+			"components": [
+				{"Type":"PositionComponent", "Position":[0,0] },
+				{"Type":"CircleRendererComponent", "Size":[6,6], "R":12.5, "Color":"#001121" }
+			]
+		}
+	}
+}`
+
+// Registers the migration and bumps the format, call the returned func to go back to format 1.
+func registerTestMigration() func() {
+	game.RegisterComponents()
+
+	ecs.RegisterMigration(ecs.Migration{
+		From: 1,
+		Component: func(component map[string]interface{}) error {
+			if component["Type"] == "CircleRendererComponent" {
+				if _, ok := component["Broken"]; ok {
+					return errors.New("broken component")
+				}
+				component["Radius"] = component["R"]
+				delete(component, "R")
+			}
+			return nil
+		},
+	})
+
+	ecs.GameDataFormat = 2
+
+	return func() { ecs.GameDataFormat = 1 }
+}
+
+func TestMigrateGameData(t *testing.T) {
+	defer registerTestMigration()()
+
+	migrated, ok, err := ecs.MigrateGameData([]byte(oldGameJson))
+
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	gameData := ecs.GameDataJson{}
+	assert.NoError(t, json.Unmarshal(migrated, &gameData))
+	assert.Equal(t, 2, gameData.Format)
+	assert.Equal(t, "0.0.1", gameData.Version)
+
+	// already current, returned as it is.
+	again, ok, err := ecs.MigrateGameData(migrated)
+
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	assert.Equal(t, migrated, again)
+}
+
+func TestNewPrefabManagerMigratesOldGameData(t *testing.T) {
+	defer registerTestMigration()()
+
+	world := ecs.NewWorld()
+	world.StrictLoading = true
+
+	prefabData, err := ecs.NewPrefabManager(oldGameJson, world)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "0.0.1", prefabData.Version)
+
+	ball, err := prefabData.CreatePrefabByName("ball")
+	assert.NoError(t, err)
+
+	renderer := ball.Components[int(ecs.CircleComponentType)].(*game.CircleRendererComponent)
+	assert.Equal(t, float32(12.5), renderer.Radius)
+
+	assert.NoError(t, ecs.ValidateGameData([]byte(oldGameJson)))
+}
+
+func TestMigrateScene(t *testing.T) {
+	defer registerTestMigration()()
+
+	world := ecs.NewWorld()
+	prefabData, err := ecs.NewPrefabManager(`{
+		"network": { "Ball": { "id": 0, "owner": "ball" } },
+		"prefabs": {
+			"ball" : {
+				"id": "0",
+				"components": [
+					{"Type":"PositionComponent", "Position":[0,0] },
+					{"Type":"CircleRendererComponent", "Size":[6,6], "Radius":1, "Color":"#001121" }
+				]
+			}
+		},
+		"format": 2
+	}`, world)
+	assert.NoError(t, err)
+
+	entities, err := prefabData.LoadScene("arena", []byte(`{
+		"name": "arena",
+		"entities": [
+			{ "prefab": "Ball", "position": [3, 4], "components": [ {"Type":"CircleRendererComponent", "R":7 } ] }
+		]
+	}`))
+
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(entities))
+
+	renderer := entities[0].Entity.Components[int(ecs.CircleComponentType)].(*game.CircleRendererComponent)
+	assert.Equal(t, float32(7), renderer.Radius)
+
+	position := entities[0].Entity.Components[int(ecs.PositionComponentType)].(*game.PositionComponent)
+	assert.Equal(t, math.NewVectorInt(3, 4), position.Position)
+}
+
+func TestMigrateErrors(t *testing.T) {
+	defer registerTestMigration()()
+
+	_, _, err := ecs.MigrateGameData([]byte(`{"format": 3}`))
+	assert.EqualError(t, err, "format 3 is newer than the engine's format 2")
+
+	_, _, err = ecs.MigrateGameData([]byte(`{"prefabs": { "ball": { "components": [ {"Type":"CircleRendererComponent", "Broken":true} ] } } }`))
+	assert.EqualError(t, err, "migrating from format 1: broken component")
+
+	ecs.GameDataFormat = 3
+
+	_, _, err = ecs.MigrateScene([]byte(`{"format": 2, "entities": []}`))
+	assert.EqualError(t, err, "no migration from format 2")
+
+	err = ecs.ValidateGameData([]byte(`{"format": 4}`))
+	assert.Contains(t, err.Error(), `field "format": format 4 is newer than the engine's format 3`)
+}
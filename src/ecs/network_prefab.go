@@ -0,0 +1,179 @@
+package ecs
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+)
+
+/**
+Network prefabs
+
+A networked prefab is declared in the "network" section of game.json with the prefab the
+owning player sees and the prefab every other player sees:
+
+	"player": "Player",
+	"network": {
+		"Player": { "id": 0, "owner": "Player_owned", "peer": "Player_peer" }
+	}
+
+The server always creates the owner view. "peer" is optional and defaults to the owner view.
+
+The id is what's sent over the network, so it's given explicitly and never changes once clients
+have the data. New network prefabs get new ids, a reload that gives an existing network prefab
+another id or an id to another network prefab is rejected, see CheckNetworkPrefabIds.
+*/
+
+type NetworkPrefabId uint16
+
+type NetworkPrefabJson struct {
+	Id    *int   `json:"id"`
+	Owner string `json:"owner"`
+	Peer  string `json:"peer"`
+}
+
+type NetworkPrefab struct {
+	Id    NetworkPrefabId
+	Name  string
+	Owner int // prefab id created by the server and the owning client
+	Peer  int // prefab id created by every other client
+}
+
+// Returns the prefab id to create for this view of the network prefab.
+func (self NetworkPrefab) View(isPeer bool) int {
+	if isPeer {
+		return self.Peer
+	}
+	return self.Owner
+}
+
+func (self *PrefabData) NetworkPrefab(id NetworkPrefabId) (NetworkPrefab, bool) {
+	networkPrefab, ok := self.NetworkPrefabs[id]
+	return networkPrefab, ok
+}
+
+func (self *PrefabData) NetworkPrefabByName(name string) (NetworkPrefab, bool) {
+	for _, networkPrefab := range self.NetworkPrefabs {
+		if networkPrefab.Name == name {
+			return networkPrefab, true
+		}
+	}
+	return NetworkPrefab{}, false
+}
+
+// Checks reloaded game data keeps the network prefab ids of the data clients already have. Network
+// prefabs can be added and removed, but an id can't move to another name.
+func (self *PrefabData) CheckNetworkPrefabIds(previous *PrefabData) error {
+	if previous == nil {
+		return nil
+	}
+
+	errs := LoadErrors{}
+
+	for id, before := range previous.NetworkPrefabs {
+		if networkPrefab, ok := self.NetworkPrefabByName(before.Name); ok && networkPrefab.Id != id {
+			errs = append(errs, LoadError{ComponentIndex: -1, Field: "network." + before.Name + ".id", Err: fmt.Errorf("id changed from %d to %d, network prefab ids can't change", id, networkPrefab.Id)})
+		}
+
+		if networkPrefab, ok := self.NetworkPrefab(id); ok && networkPrefab.Name != before.Name {
+			errs = append(errs, LoadError{ComponentIndex: -1, Field: "network." + networkPrefab.Name + ".id", Err: fmt.Errorf("id %d was network prefab %q, network prefab ids can't change", id, before.Name)})
+		}
+	}
+
+	if len(errs) > 0 {
+		sort.Slice(errs, func(i, j int) bool {
+			return errs[i].Field < errs[j].Field
+		})
+		return errs
+	}
+
+	return nil
+}
+
+// Creates the owner or peer view of a network prefab.
+func (self *PrefabData) CreateNetworkPrefab(id NetworkPrefabId, isPeer bool, overrides ...PrefabOverride) (Entity, error) {
+	networkPrefab, ok := self.NetworkPrefab(id)
+
+	if !ok {
+		return Entity{}, fmt.Errorf("Network prefab %d Doesn't exist", id)
+	}
+
+	return self.CreatePrefab(networkPrefab.View(isPeer), overrides...)
+}
+
+// Builds the network prefab table from the network section of the game data. names holds
+// the prefabs that were loaded.
+func newNetworkPrefabs(data GameDataJson, names map[string]int) (map[NetworkPrefabId]NetworkPrefab, LoadErrors) {
+	errs := LoadErrors{}
+
+	// sorted so errors are reported in the same order every time.
+	networkNames := make([]string, 0, len(data.Network))
+	for name := range data.Network {
+		networkNames = append(networkNames, name)
+	}
+	sort.Strings(networkNames)
+
+	// looks up a view, the field is only used for errors.
+	view := func(name string, field string, prefabName string) int {
+		if id, ok := names[prefabName]; ok {
+			return id
+		}
+
+		err := fmt.Errorf("prefab %q doesn't exist", prefabName)
+
+		if prefabName == "" {
+			err = errors.New("prefab must be specified")
+		} else if _, ok := data.Prefabs[prefabName]; ok {
+			err = fmt.Errorf("prefab %q failed to load", prefabName)
+		}
+
+		errs = append(errs, LoadError{ComponentIndex: -1, Field: "network." + name + "." + field, Err: err})
+
+		return -1
+	}
+
+	result := map[NetworkPrefabId]NetworkPrefab{}
+	networkNamesById := map[NetworkPrefabId]string{}
+
+	for _, name := range networkNames {
+		declared := data.Network[name]
+
+		if declared.Id == nil {
+			errs = append(errs, LoadError{ComponentIndex: -1, Field: "network." + name + ".id", Err: errors.New("id must be specified")})
+			continue
+		}
+
+		if *declared.Id < 0 || *declared.Id > math.MaxUint16 {
+			errs = append(errs, LoadError{ComponentIndex: -1, Field: "network." + name + ".id", Err: fmt.Errorf("id %d isn't between 0 and %d", *declared.Id, math.MaxUint16)})
+			continue
+		}
+
+		id := NetworkPrefabId(*declared.Id)
+
+		if other, ok := networkNamesById[id]; ok {
+			errs = append(errs, LoadError{ComponentIndex: -1, Field: "network." + name + ".id", Err: fmt.Errorf("id %d is already used by network prefab %q", id, other)})
+			continue
+		}
+
+		networkNamesById[id] = name
+
+		// views that failed to load are left as -1.
+		owner := view(name, "owner", declared.Owner)
+		peer := owner
+
+		if declared.Peer != "" {
+			peer = view(name, "peer", declared.Peer)
+		}
+
+		result[id] = NetworkPrefab{Id: id, Name: name, Owner: owner, Peer: peer}
+	}
+
+	if data.Player != "" {
+		if _, ok := data.Network[data.Player]; !ok {
+			errs = append(errs, LoadError{ComponentIndex: -1, Field: "player", Err: fmt.Errorf("network prefab %q doesn't exist", data.Player)})
+		}
+	}
+
+	return result, errs
+}
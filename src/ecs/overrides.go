@@ -0,0 +1,85 @@
+package ecs
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+)
+
+/**
+Prefab overrides
+
+Overrides change the clone CreatePrefab returns so callers don't have to reach into
+entity.Components. The game package has typed overrides for its components, e.g.
+game.WithPosition, and any component field can be patched with WithComponentJson.
+*/
+
+type PrefabOverride interface {
+	Apply(entity *Entity) error
+}
+
+type PrefabOverrideFunc func(entity *Entity) error
+
+func (self PrefabOverrideFunc) Apply(entity *Entity) error {
+	return self(entity)
+}
+
+// Adds the component to the entity, replacing the prefab's component of the same type.
+func WithComponent(component Component) PrefabOverride {
+	return PrefabOverrideFunc(func(entity *Entity) error {
+		entity.Components[component.Id()] = component
+		return nil
+	})
+}
+
+// Patches fields of one of the prefab's components from {"Type":"...", ...}. Unknown fields and
+// components the prefab doesn't have are errors.
+func WithComponentJson(jsonStr string) PrefabOverride {
+	return PrefabOverrideFunc(func(entity *Entity) error {
+		if problems := overrideComponent(entity, json.RawMessage(jsonStr), true); len(problems) > 0 {
+			return LoadErrors(problems)
+		}
+		return nil
+	})
+}
+
+// Finds the entity's component of the same type as component, for typed overrides.
+// Returns an error if the prefab doesn't have it.
+func OverrideTarget(entity *Entity, component Component) (Component, error) {
+	if target, ok := entity.Components[component.Id()]; ok {
+		return target, nil
+	}
+
+	return nil, LoadErrors{{ComponentIndex: -1, ComponentType: reflect.TypeOf(component).Elem().Name(), Err: errors.New("prefab doesn't have this component")}}
+}
+
+// Applies every override, the errors are returned as LoadErrors with the index of the override.
+func applyOverrides(entity *Entity, prefabName string, overrides []PrefabOverride) error {
+	errs := LoadErrors{}
+
+	for i, override := range overrides {
+		err := override.Apply(entity)
+
+		if err == nil {
+			continue
+		}
+
+		problems, ok := err.(LoadErrors)
+
+		if !ok {
+			problems = LoadErrors{{ComponentIndex: -1, Err: err}}
+		}
+
+		for _, problem := range problems {
+			problem.Prefab = prefabName
+			problem.ComponentIndex = i
+			errs = append(errs, problem)
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+
+	return nil
+}
@@ -0,0 +1,123 @@
+package ecs
+
+/**
+Remote input prediction
+
+A client only knows its own input ahead of the server. The inputs of the other players arrive with
+the world state, see ConfirmRemoteInput, and are kept in Input.Remote by player. Every tick a remote
+input is set from its confirmed input if the server sent one, otherwise it's predicted from the tick
+before with InputMap.Predict. Resimulating replays the confirmed inputs as they become known, and a
+confirmed input that differs from what was predicted for a tick that already ran asks for one.
+
+Systems get it with InputForPlayer like any other player's input, so peers are simulated with it.
+*/
+
+// Stores the input the server applied, or will apply, for a remote player at a tick. Returns true if
+// the tick already ran with another input and has to be resimulated.
+func (w *World) ConfirmRemoteInput(id PlayerId, tick int64, input NetworkInput) bool {
+	if tick <= w.CurrentTick-MAX_CACHE_SIZE {
+		return false
+	}
+
+	if w.ConfirmedInput == nil {
+		w.ConfirmedInput = map[int64]map[PlayerId]NetworkInput{}
+	}
+
+	if w.ConfirmedInput[tick] == nil {
+		w.ConfirmedInput[tick] = map[PlayerId]NetworkInput{}
+	}
+
+	w.ConfirmedInput[tick][id] = input
+
+	if tick > w.CurrentTick {
+		return false
+	}
+
+	index := len(w.CacheInput) - 1 - int(w.CurrentTick-tick)
+
+	if index < 0 {
+		return false
+	}
+
+	cached := w.CacheInput[index]
+
+	if cached.Remote == nil {
+		cached.Remote = map[PlayerId]*Input{}
+	}
+
+	predicted, ok := cached.Remote[id]
+	mispredicted := !ok || !w.InputMap().Encode(predicted).Equals(input)
+
+	// resimulating predicts the ticks after this one from the cached input.
+	if mispredicted {
+		if !ok {
+			predicted = NewInput()
+			cached.Remote[id] = predicted
+		}
+		w.InputMap().Decode(input, predicted)
+	}
+
+	return mispredicted
+}
+
+// Forgets a remote player that left.
+func (w *World) RemoveRemoteInput(id PlayerId) {
+	delete(w.Input.Remote, id)
+
+	for _, cached := range w.CacheInput {
+		delete(cached.Remote, id)
+	}
+
+	for _, inputs := range w.ConfirmedInput {
+		delete(inputs, id)
+	}
+}
+
+// Input of a remote player on a client, nil if nothing is known about the player.
+func (w *World) RemoteInput(id PlayerId) *Input {
+	return w.Input.Remote[id]
+}
+
+func (w *World) updateRemoteInput() {
+	confirmed := w.ConfirmedInput[w.CurrentTick]
+
+	if len(confirmed) == 0 && len(w.Input.Remote) == 0 {
+		return
+	}
+
+	if w.Input.Remote == nil {
+		w.Input.Remote = map[PlayerId]*Input{}
+	}
+
+	inputMap := w.InputMap()
+	previous := cloneInputs(w.Input.Remote)
+
+	for id, input := range w.Input.Remote {
+		if _, ok := confirmed[id]; !ok {
+			inputMap.Predict(input)
+		}
+	}
+
+	for id, networkInput := range confirmed {
+		input, ok := w.Input.Remote[id]
+
+		if !ok {
+			input = NewInput()
+			w.Input.Remote[id] = input
+		}
+
+		inputMap.Decode(networkInput, input)
+	}
+
+	for id, input := range w.Input.Remote {
+		input.SetEdges(previous[id])
+	}
+
+	if !w.IsResimulating {
+		for tick := range w.ConfirmedInput {
+			if tick <= w.CurrentTick-MAX_CACHE_SIZE {
+				delete(w.ConfirmedInput, tick)
+			}
+		}
+	}
+}
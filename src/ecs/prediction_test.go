@@ -0,0 +1,160 @@
+package ecs_test
+
+import (
+	"github.com/Banyango/io-engine/src/ecs"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+// records whether the remote player held move and fire on every tick.
+type remoteInputRecordSystem struct {
+	move map[int64]bool
+	fire map[int64]bool
+}
+
+func (*remoteInputRecordSystem) Init(w *ecs.World)                    {}
+func (*remoteInputRecordSystem) AddToStorage(entity *ecs.Entity)      {}
+func (*remoteInputRecordSystem) RemoveFromStorage(entity *ecs.Entity) {}
+func (*remoteInputRecordSystem) RequiredComponentTypes() []ecs.ComponentType {
+	return []ecs.ComponentType{}
+}
+
+func (self *remoteInputRecordSystem) UpdateSystem(delta float64, world *ecs.World) {
+	if input := world.RemoteInput(5); input != nil {
+		self.move[world.CurrentTick] = input.Action("move")
+		self.fire[world.CurrentTick] = input.Action("fire")
+	}
+}
+
+func createPredictionWorld(t *testing.T) (*ecs.World, *remoteInputRecordSystem) {
+	world := ecs.NewWorld()
+	world.StrictLoading = true
+
+	prefabData, err := ecs.NewPrefabManager(`{
+		"input": {
+			"actions": [
+				{ "name": "move", "keys": ["D"] },
+				{ "name": "fire", "keys": ["X"], "predict": "release" }
+			]
+		},
+		"prefabs": {}
+	}`, world)
+	assert.NoError(t, err)
+
+	world.PrefabData = prefabData
+
+	recorder := &remoteInputRecordSystem{move: map[int64]bool{}, fire: map[int64]bool{}}
+	world.AddSystem(recorder)
+
+	return world, recorder
+}
+
+func TestWorld_PredictsRemoteInput(t *testing.T) {
+	world, recorder := createPredictionWorld(t)
+
+	assert.False(t, world.ConfirmRemoteInput(5, 1, ecs.NetworkInput{Buttons: []byte{0x3}}))
+
+	for i := 0; i < 3; i++ {
+		world.Update(ecs.FIXED_DELTA)
+	}
+
+	// confirmed on tick 1, then move repeats and fire is released.
+	assert.True(t, recorder.move[1])
+	assert.True(t, recorder.fire[1])
+	assert.True(t, recorder.move[2])
+	assert.False(t, recorder.fire[2])
+	assert.True(t, recorder.move[3])
+
+	// confirmed as predicted, nothing to resimulate.
+	assert.False(t, world.ConfirmRemoteInput(5, 2, ecs.NetworkInput{Buttons: []byte{0x1}}))
+
+	// move was released on tick 2.
+	assert.True(t, world.ConfirmRemoteInput(5, 2, ecs.NetworkInput{Buttons: []byte{0x0}}))
+
+	world.ResetToTick(1)
+	world.Resimulate(1)
+
+	assert.Equal(t, int64(3), world.CurrentTick)
+	assert.False(t, recorder.move[2])
+	assert.False(t, recorder.move[3])
+	assert.False(t, world.RemoteInput(5).Action("move"))
+
+	world.RemoveRemoteInput(5)
+	world.Update(ecs.FIXED_DELTA)
+
+	assert.Nil(t, world.RemoteInput(5))
+}
+
+func TestWorld_ConfirmedFutureInputIsUsed(t *testing.T) {
+	world, recorder := createPredictionWorld(t)
+
+	world.ConfirmRemoteInput(5, 1, ecs.NetworkInput{Buttons: []byte{0x0}})
+	world.ConfirmRemoteInput(5, 3, ecs.NetworkInput{Buttons: []byte{0x2}})
+
+	for i := 0; i < 4; i++ {
+		world.Update(ecs.FIXED_DELTA)
+	}
+
+	assert.False(t, recorder.fire[2])
+	assert.True(t, recorder.fire[3])
+	assert.False(t, recorder.fire[4])
+}
+
+func TestInputMap_PredictErrors(t *testing.T) {
+	_, errs := ecs.NewInputMap(ecs.InputJson{Actions: []ecs.InputActionJson{{Name: "fire", Predict: "guess"}}})
+
+	assert.EqualError(t, errs, "1 problem(s) loading game data:\n"+
+		`  - field "input.actions.0.predict": unknown prediction "guess", use "repeat" or "release"`)
+}
+
+// holds fire on tick 2 only, the way the client input system sets the local input.
+type edgeInputSystem struct {
+	pressed  map[int64]bool
+	released map[int64]bool
+}
+
+func (*edgeInputSystem) Init(w *ecs.World)                           {}
+func (*edgeInputSystem) AddToStorage(entity *ecs.Entity)             {}
+func (*edgeInputSystem) RemoveFromStorage(entity *ecs.Entity)        {}
+func (*edgeInputSystem) RequiredComponentTypes() []ecs.ComponentType { return []ecs.ComponentType{} }
+
+func (self *edgeInputSystem) UpdateSystem(delta float64, world *ecs.World) {
+	if !world.IsResimulating {
+		previous := world.Input.Player[0]
+
+		input := ecs.NewInput()
+		input.Actions["fire"] = world.CurrentTick == 2
+		input.SetEdges(previous)
+
+		world.Input.Player[0] = input
+	}
+
+	self.pressed[world.CurrentTick] = world.Input.Player[0].ActionPressed("fire")
+	self.released[world.CurrentTick] = world.Input.Player[0].ActionReleased("fire")
+}
+
+func TestWorld_InputEdgesAreReplayed(t *testing.T) {
+	world := ecs.NewWorld()
+
+	system := &edgeInputSystem{pressed: map[int64]bool{}, released: map[int64]bool{}}
+	world.AddSystem(system)
+
+	for i := 0; i < 4; i++ {
+		world.Update(ecs.FIXED_DELTA)
+	}
+
+	expectedPressed := map[int64]bool{1: false, 2: true, 3: false, 4: false}
+	expectedReleased := map[int64]bool{1: false, 2: false, 3: true, 4: false}
+
+	assert.Equal(t, expectedPressed, system.pressed)
+	assert.Equal(t, expectedReleased, system.released)
+
+	system.pressed = map[int64]bool{}
+	system.released = map[int64]bool{}
+
+	world.ResetToTick(1)
+	world.Resimulate(1)
+
+	assert.Equal(t, map[int64]bool{2: true, 3: false, 4: false}, system.pressed)
+	assert.Equal(t, map[int64]bool{2: false, 3: true, 4: false}, system.released)
+}
@@ -4,21 +4,38 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"sort"
 )
 
 type PrefabData struct {
 	data GameDataJson
 	Prefabs map[int]Entity
+	Names map[string]int // prefab name in game.json -> prefab id
+	NetworkPrefabs map[NetworkPrefabId]NetworkPrefab
+	Player string // name of the network prefab spawned for each connecting player
+	Scenes map[string]string // scene name -> scene file, relative to game.json
+	Scene string // name of the scene loaded when the world starts
+	Version string // version of the game data, clients and the server must have the same one
+	Map string // Tiled map loaded by the server and clients, relative to game.json
+	InputMap *InputMap // actions systems read, DefaultInputMap if game data doesn't declare any
 }
 
 type GameDataJson struct {
 	Name string `json:"name"`
 	Version string `json:"version"`
+	Format int `json:"format,omitempty"`
 	Globals json.RawMessage `json:"globals"`
+	Player string `json:"player"`
+	Network map[string]NetworkPrefabJson `json:"network"`
+	Scenes map[string]string `json:"scenes"`
+	Scene string `json:"scene"`
+	Map string `json:"map"`
+	Input *InputJson `json:"input"`
 	Prefabs map[string]json.RawMessage `json:"prefabs"`
 }
 
-func (self *PrefabData) CreatePrefab(id int) (Entity, error) {
+// Clones the prefab and applies the overrides to the clone. Override problems are returned as LoadErrors.
+func (self *PrefabData) CreatePrefab(id int, overrides ...PrefabOverride) (Entity, error) {
 
 	if val, ok := self.Prefabs[id]; ok {
 
@@ -34,19 +51,49 @@ func (self *PrefabData) CreatePrefab(id int) (Entity, error) {
 
 		clone.PrefabId = id
 
+		if err := applyOverrides(&clone, self.prefabName(id), overrides); err != nil {
+			return Entity{}, err
+		}
+
 		return clone, nil
 	}
 
 	return Entity{}, errors.New("Prefab Doesn't exist")
 }
 
+func (self *PrefabData) CreatePrefabByName(name string, overrides ...PrefabOverride) (Entity, error) {
+
+	if id, ok := self.Names[name]; ok {
+		return self.CreatePrefab(id, overrides...)
+	}
+
+	return Entity{}, fmt.Errorf("Prefab %q Doesn't exist", name)
+}
+
+func (self *PrefabData) prefabName(id int) string {
+	for name, prefabId := range self.Names {
+		if prefabId == id {
+			return name
+		}
+	}
+	return ""
+}
+
 // This will create a new prefab manager.
 // Not that systems will need to be manually added by name when the world is created.
 func NewPrefabManager (jsonGameData string, world *World) (*PrefabData, error) {
 
 	prefabManager := GameDataJson{}
 
-	err := json.Unmarshal([]byte(jsonGameData), &prefabManager)
+	data, _, err := MigrateGameData([]byte(jsonGameData))
+
+	if err != nil {
+		fmt.Println("Error migrating game data: ", err)
+
+		return nil, err
+	}
+
+	err = json.Unmarshal(data, &prefabManager)
 
 	if err != nil {
 		fmt.Println("Error with json: ", err)
@@ -57,20 +104,87 @@ func NewPrefabManager (jsonGameData string, world *World) (*PrefabData, error) {
 	result := PrefabData {
 		data:prefabManager,
 		Prefabs: map[int]Entity{},
+		Names: map[string]int{},
+		Player: prefabManager.Player,
+		Scenes: prefabManager.Scenes,
+		Scene: prefabManager.Scene,
+		Version: prefabManager.Version,
+		Map: prefabManager.Map,
 	}
 
+	// sorted so errors are reported in the same order every time.
+	names := make([]string, 0, len(prefabManager.Prefabs))
+	for name := range prefabManager.Prefabs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	errs := LoadErrors{}
+	prefabNames := map[int]string{}
+
+	resolver := newPrefabResolver(prefabManager.Prefabs)
+
 	// Create prefabs
-	for i := range prefabManager.Prefabs {
-		prefab := prefabManager.Prefabs[i]
+	for _, name := range names {
+		prefab, problems := resolver.Flatten(name)
+
+		errs = append(errs, problems...)
+
+		if prefab == nil {
+			continue
+		}
+
+		entity, err := world.CreateEntityFromJson(string(prefab))
+
+		if err != nil {
+			if loadErrors, ok := err.(LoadErrors); ok {
+				for _, loadError := range loadErrors {
+					loadError.Prefab = name
+					errs = append(errs, loadError)
+				}
+			} else {
+				errs = append(errs, LoadError{Prefab: name, ComponentIndex: -1, Err: err})
+			}
+			continue
+		}
 
-		entity, _ := world.CreateEntityFromJson(string(prefab))
+		if other, ok := prefabNames[int(entity.Id)]; ok {
+			errs = append(errs, LoadError{Prefab: name, ComponentIndex: -1, Field: "id", Err: fmt.Errorf("id %d is already used by prefab %q", entity.Id, other)})
+			continue
+		}
 
+		prefabNames[int(entity.Id)] = name
+		result.Names[name] = int(entity.Id)
 		result.Prefabs[int(entity.Id)] = entity
 	}
 
-	return &result, nil
+	networkPrefabs, networkErrs := newNetworkPrefabs(prefabManager, result.Names)
 
-}
+	result.NetworkPrefabs = networkPrefabs
+	errs = append(errs, networkErrs...)
 
+	if prefabManager.Input != nil {
+		inputMap, inputErrs := NewInputMap(*prefabManager.Input)
 
+		result.InputMap = inputMap
+		errs = append(errs, inputErrs...)
+	} else {
+		result.InputMap = DefaultInputMap()
+	}
 
+	if prefabManager.Scene != "" {
+		if _, ok := prefabManager.Scenes[prefabManager.Scene]; !ok {
+			errs = append(errs, LoadError{ComponentIndex: -1, Field: "scene", Err: fmt.Errorf("scene %q doesn't exist", prefabManager.Scene)})
+		}
+	}
+
+	if len(errs) > 0 {
+		if world.StrictLoading {
+			return nil, errs
+		}
+		fmt.Println(errs)
+	}
+
+	return &result, nil
+
+}
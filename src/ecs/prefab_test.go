@@ -1,6 +1,7 @@
 package ecs_test
 
 import (
+	"io/ioutil"
 	"github.com/stretchr/testify/assert"
 	"github.com/Banyango/io-engine/src/game"
 	"github.com/Banyango/io-engine/src/math"
@@ -89,3 +90,391 @@ func TestPrefabClone(t *testing.T) {
 	assert.NotEqual(t, 1, entity2.Components[0].(*game.PositionComponent).Position.X())
 	assert.NotEqual(t, 1, entity2.Components[0].(*game.PositionComponent).Position.Y())
 }
+
+func TestNewPrefabManager_StrictReportsEveryProblem(t *testing.T) {
+
+	json := `{
+	"name": "test",
+	"version": "0.0.1",
+	"prefabs": {
+		"player" : {
+			"id": "0",
+			"components": [
+				{"Type":"PositionComponent", "Position":[0,0] },
+				{"Type":"CollisionComponent", "Size":"big", "Velocty":[0,0] }
+			]
+		},
+		"wall" : {
+			"id": "1",
+			"components": [
+				{"Type":"PositionComponent", "Position":[0,0] },
+				{"Type":"WallComponent" }
+			]
+		},
+		"bad_id" : {
+			"id": "one",
+			"components": []
+		}
+	}
+}`
+
+	w := ecs.NewWorld()
+	w.StrictLoading = true
+
+	w.AddSystem(new(game.CollisionSystem))
+
+	pm, err := ecs.NewPrefabManager(json, w)
+
+	assert.Nil(t, pm)
+	assert.Error(t, err)
+
+	errs, ok := err.(ecs.LoadErrors)
+	assert.True(t, ok)
+	assert.Equal(t, 4, len(errs))
+
+	assert.Equal(t, ecs.LoadError{Prefab: "bad_id", ComponentIndex: -1, Field: "id", Err: errs[0].Err}, errs[0])
+	assert.Equal(t, ecs.LoadError{Prefab: "player", ComponentIndex: 1, ComponentType: "CollisionComponent", Field: "Size", Err: errs[1].Err}, errs[1])
+	assert.Equal(t, ecs.LoadError{Prefab: "player", ComponentIndex: 1, ComponentType: "CollisionComponent", Field: "Velocty", Err: errs[2].Err}, errs[2])
+	assert.Equal(t, ecs.LoadError{Prefab: "wall", ComponentIndex: 1, ComponentType: "WallComponent", Field: "Type", Err: errs[3].Err}, errs[3])
+
+	assert.Contains(t, err.Error(), `prefab "player" component 1 (CollisionComponent) field "Velocty": unknown field`)
+}
+
+func TestNewPrefabManager_LenientIgnoresProblems(t *testing.T) {
+
+	json := `{
+	"name": "test",
+	"version": "0.0.1",
+	"prefabs": {
+		"player" : {
+			"id": "0",
+			"components": [
+				{"Type":"PositionComponent", "Position":[0,0], "Extra": 1 },
+				{"Type":"WallComponent" },
+				{"Type":"CircleRendererComponent", "Size":[6,6], "Radius":"big", "Color":"#001121" },
+				{"Type":"CollisionComponent", "Size":"big", "Velocity":[1,2] }
+			]
+		}
+	}
+}`
+
+	w := ecs.NewWorld()
+
+	w.AddSystem(new(game.CollisionSystem))
+
+	pm, err := ecs.NewPrefabManager(json, w)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(pm.Prefabs[0].Components))
+
+	// a bad field is skipped, the rest of its component loads.
+	renderer := pm.Prefabs[0].Components[int(ecs.CircleComponentType)].(*game.CircleRendererComponent)
+	assert.Equal(t, float32(0), renderer.Radius)
+	assert.Equal(t, math.NewVector(6, 6), renderer.Size)
+
+	collision := pm.Prefabs[0].Components[int(ecs.CollisionComponentType)].(*game.CollisionComponent)
+	assert.Equal(t, math.NewVectorInt(0, 0), collision.Size)
+	assert.Equal(t, math.NewVector(1, 2), collision.Velocity)
+}
+
+func TestNewPrefabManager_StrictLoadsGameJson(t *testing.T) {
+	gameJson, err := ioutil.ReadFile("../../game.json")
+	assert.NoError(t, err)
+
+	w := ecs.NewWorld()
+	w.StrictLoading = true
+
+	game.RegisterComponents()
+
+	pm, err := ecs.NewPrefabManager(string(gameJson), w)
+
+	assert.NoError(t, err)
+	// peers move with their remote input so they keep the movement of the owned player.
+	assert.NotNil(t, pm.Prefabs[1].Components[int(ecs.ArcadeMovementComponentType)])
+	assert.Equal(t, len(pm.Prefabs[0].Components), len(pm.Prefabs[1].Components))
+}
+
+func TestNewPrefabManager_Extends(t *testing.T) {
+
+	json := `{
+	"name": "test",
+	"version": "0.0.1",
+	"prefabs": {
+		"base" : {
+			"id": "0",
+			"components": [
+				{"Type":"PositionComponent", "Position":[0,0] },
+				{"Type":"CircleRendererComponent", "Size":[6,6], "Radius":12.3, "Color":"#001121" },
+				{"Type":"ArcadeMovementComponent", "MaxSpeed":[200,200], "Speed":400, "Drag":0.8, "Gravity":[0,0] }
+			]
+		},
+		"variant" : {
+			"id": "1",
+			"extends": "base",
+			"remove": ["ArcadeMovementComponent"],
+			"components": [
+				{"Type":"CircleRendererComponent", "Radius":4 },
+				{"Type":"CollisionComponent", "Size":[2,2], "Velocity":[0,0] }
+			]
+		},
+		"grandchild" : {
+			"id": "2",
+			"extends": "variant",
+			"components": [
+				{"Type":"PositionComponent", "Position":[5,5] }
+			]
+		}
+	}
+}`
+
+	w := ecs.NewWorld()
+	w.StrictLoading = true
+
+	game.RegisterComponents()
+
+	pm, err := ecs.NewPrefabManager(json, w)
+
+	assert.NoError(t, err)
+
+	base := pm.Prefabs[0]
+	variant := pm.Prefabs[1]
+	grandchild := pm.Prefabs[2]
+
+	assert.Equal(t, 3, len(base.Components))
+	assert.Equal(t, float32(12.3), base.Components[int(ecs.CircleComponentType)].(*game.CircleRendererComponent).Radius)
+
+	assert.Equal(t, 3, len(variant.Components))
+	assert.Equal(t, int64(1), variant.Id)
+	assert.Nil(t, variant.Components[int(ecs.ArcadeMovementComponentType)])
+	assert.NotNil(t, variant.Components[int(ecs.CollisionComponentType)])
+
+	renderer := variant.Components[int(ecs.CircleComponentType)].(*game.CircleRendererComponent)
+	assert.Equal(t, float32(4), renderer.Radius)
+	assert.Equal(t, math.NewVector(6, 6), renderer.Size)
+
+	assert.Equal(t, 3, len(grandchild.Components))
+	assert.Equal(t, math.NewVectorInt(5, 5), grandchild.Components[int(ecs.PositionComponentType)].(*game.PositionComponent).Position)
+	assert.Equal(t, math.NewVectorInt(0, 0), variant.Components[int(ecs.PositionComponentType)].(*game.PositionComponent).Position)
+}
+
+func TestNewPrefabManager_ExtendsErrors(t *testing.T) {
+
+	json := `{
+	"name": "test",
+	"version": "0.0.1",
+	"prefabs": {
+		"a" : { "id": "0", "extends": "b", "components": [] },
+		"b" : { "id": "1", "extends": "a", "components": [] },
+		"orphan" : { "id": "2", "extends": "missing", "components": [] },
+		"remover" : { "id": "3", "extends": "base", "remove": ["ArcadeMovementComponent"] },
+		"base" : { "id": "4", "components": [ {"Type":"PositionComponent", "Position":[0,0] } ] }
+	}
+}`
+
+	w := ecs.NewWorld()
+	w.StrictLoading = true
+
+	game.RegisterComponents()
+
+	pm, err := ecs.NewPrefabManager(json, w)
+
+	assert.Nil(t, pm)
+
+	loadErrors, ok := err.(ecs.LoadErrors)
+	assert.True(t, ok)
+	assert.Equal(t, 4, len(loadErrors))
+
+	assert.Contains(t, err.Error(), `prefab "a" field "extends": inheritance cycle a -> b -> a`)
+	assert.Contains(t, err.Error(), `prefab "b" field "extends": inheritance cycle b -> a -> b`)
+	assert.Contains(t, err.Error(), `prefab "orphan" field "extends": parent prefab "missing" doesn't exist`)
+	assert.Contains(t, err.Error(), `prefab "remover" field "remove": parent prefab "base" doesn't have this component`)
+}
+
+func TestNewPrefabManager_NetworkPrefabs(t *testing.T) {
+
+	json := `{
+	"name": "test",
+	"version": "0.0.1",
+	"player": "Player",
+	"network": {
+		"Player": { "id": 4, "owner": "Player_owned", "peer": "Player_peer" },
+		"Bullet": { "id": 0, "owner": "Bullet" }
+	},
+	"prefabs": {
+		"Bullet" : { "id": "7", "components": [ {"Type":"PositionComponent", "Position":[0,0] } ] },
+		"Player_owned" : { "id": "3", "components": [ {"Type":"PositionComponent", "Position":[0,0] } ] },
+		"Player_peer" : { "id": "1", "components": [ {"Type":"PositionComponent", "Position":[1,1] } ] }
+	}
+}`
+
+	w := ecs.NewWorld()
+	w.StrictLoading = true
+
+	game.RegisterComponents()
+
+	pm, err := ecs.NewPrefabManager(json, w)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 3, pm.Names["Player_owned"])
+	assert.Equal(t, "Player", pm.Player)
+
+	bullet, ok := pm.NetworkPrefabByName("Bullet")
+	assert.True(t, ok)
+	assert.Equal(t, ecs.NetworkPrefabId(0), bullet.Id)
+	assert.Equal(t, 7, bullet.Peer)
+
+	player, ok := pm.NetworkPrefab(4)
+	assert.True(t, ok)
+	assert.Equal(t, "Player", player.Name)
+
+	owner, err := pm.CreateNetworkPrefab(player.Id, false)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, owner.PrefabId)
+
+	peer, err := pm.CreateNetworkPrefab(player.Id, true)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, peer.PrefabId)
+
+	_, err = pm.CreateNetworkPrefab(2, false)
+	assert.Error(t, err)
+
+	byName, err := pm.CreatePrefabByName("Player_peer")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, byName.PrefabId)
+}
+
+func TestNewPrefabManager_NetworkPrefabErrors(t *testing.T) {
+
+	json := `{
+	"name": "test",
+	"version": "0.0.1",
+	"player": "Hero",
+	"network": {
+		"Player": { "id": 0, "owner": "Player_owned", "peer": "Player_ghost" },
+		"Empty": { "id": 1 },
+		"Bullet": { "owner": "Player_owned" },
+		"Wall": { "id": 0, "owner": "Player_owned" },
+		"Huge": { "id": 65536, "owner": "Player_owned" }
+	},
+	"prefabs": {
+		"Player_owned" : { "id": "0", "components": [ {"Type":"PositionComponent", "Position":[0,0] } ] }
+	}
+}`
+
+	w := ecs.NewWorld()
+	w.StrictLoading = true
+
+	game.RegisterComponents()
+
+	_, err := ecs.NewPrefabManager(json, w)
+
+	loadErrors, ok := err.(ecs.LoadErrors)
+	assert.True(t, ok)
+	assert.Equal(t, 6, len(loadErrors))
+
+	assert.Contains(t, err.Error(), `field "network.Bullet.id": id must be specified`)
+	assert.Contains(t, err.Error(), `field "network.Empty.owner": prefab must be specified`)
+	assert.Contains(t, err.Error(), `field "network.Huge.id": id 65536 isn't between 0 and 65535`)
+	assert.Contains(t, err.Error(), `field "network.Wall.id": id 0 is already used by network prefab "Player"`)
+	assert.Contains(t, err.Error(), `field "network.Player.peer": prefab "Player_ghost" doesn't exist`)
+	assert.Contains(t, err.Error(), `field "player": network prefab "Hero" doesn't exist`)
+}
+
+func TestPrefabData_CreatePrefabWithOverrides(t *testing.T) {
+	pm := createScenePrefabs(t)
+
+	entity, err := pm.CreatePrefab(0,
+		game.WithPosition(math.NewVectorInt(3, 4)),
+		ecs.WithComponentJson(`{"Type":"CircleRendererComponent", "Radius":2, "Color":"#ffffff"}`),
+		ecs.WithComponent(&game.CollisionComponent{Velocity: math.NewVector(1, 0)}),
+		game.WithVelocity(math.NewVector(5, 6)),
+	)
+
+	assert.NoError(t, err)
+	assert.Equal(t, math.NewVectorInt(3, 4), entity.Components[int(ecs.PositionComponentType)].(*game.PositionComponent).Position)
+	assert.Equal(t, float32(2), entity.Components[int(ecs.CircleComponentType)].(*game.CircleRendererComponent).Radius)
+	assert.Equal(t, math.NewVector(5, 6), entity.Components[int(ecs.CollisionComponentType)].(*game.CollisionComponent).Velocity)
+
+	// the prefab isn't changed.
+	prefab, err := pm.CreatePrefab(0)
+	assert.NoError(t, err)
+	assert.Equal(t, math.NewVectorInt(0, 0), prefab.Components[int(ecs.PositionComponentType)].(*game.PositionComponent).Position)
+	assert.Nil(t, prefab.Components[int(ecs.CollisionComponentType)])
+}
+
+func TestPrefabData_OverridesSurviveAddingToTheWorld(t *testing.T) {
+	pm := createScenePrefabs(t)
+	world := ecs.NewWorld()
+
+	typed, err := pm.CreatePrefab(0,
+		ecs.WithComponent(&game.CollisionComponent{Size: math.NewVectorInt(6, 6)}),
+		game.WithVelocity(math.NewVector(5, 6)),
+	)
+	assert.NoError(t, err)
+
+	fromJson, err := pm.CreatePrefab(0,
+		ecs.WithComponent(&game.CollisionComponent{}),
+		ecs.WithComponentJson(`{"Type":"CollisionComponent", "Velocity":[1,2]}`),
+	)
+	assert.NoError(t, err)
+
+	typed.Id = world.FetchAndIncrementId()
+	world.AddEntityToWorld(typed)
+
+	fromJson.Id = world.FetchAndIncrementId()
+	world.AddEntityToWorld(fromJson)
+
+	collision := world.Entities[typed.Id].Components[int(ecs.CollisionComponentType)].(*game.CollisionComponent)
+	assert.Equal(t, math.NewVector(5, 6), collision.Velocity)
+	assert.Equal(t, math.NewVectorInt(6, 6), collision.Size)
+
+	collision = world.Entities[fromJson.Id].Components[int(ecs.CollisionComponentType)].(*game.CollisionComponent)
+	assert.Equal(t, math.NewVector(1, 2), collision.Velocity)
+}
+
+func TestPrefabData_CreatePrefabOverrideErrors(t *testing.T) {
+	pm := createScenePrefabs(t)
+
+	_, err := pm.CreatePrefabByName("wall",
+		ecs.WithComponentJson(`{"Type":"CircleRendererComponent", "Radius":"big", "Colour":"#fff"}`),
+		game.WithVelocity(math.NewVector(5, 6)),
+		ecs.WithComponentJson(`{"Type":"ArcadeMovementComponent", "Speed":1}`),
+	)
+
+	loadErrors, ok := err.(ecs.LoadErrors)
+	assert.True(t, ok)
+	assert.Equal(t, 4, len(loadErrors))
+
+	assert.Contains(t, err.Error(), `prefab "wall" component 0 (CircleRendererComponent) field "Colour": unknown field`)
+	assert.Contains(t, err.Error(), `prefab "wall" component 0 (CircleRendererComponent) field "Radius"`)
+	assert.Contains(t, err.Error(), `prefab "wall" component 1 (CollisionComponent): prefab doesn't have this component`)
+	assert.Contains(t, err.Error(), `prefab "wall" component 2 (ArcadeMovementComponent) field "Type": prefab doesn't have this component`)
+}
+
+func TestPrefabData_CheckNetworkPrefabIds(t *testing.T) {
+	game.RegisterComponents()
+
+	load := func(network string) *ecs.PrefabData {
+		w := ecs.NewWorld()
+		w.StrictLoading = true
+
+		pm, err := ecs.NewPrefabManager(`{
+			"network": `+network+`,
+			"prefabs": { "player": { "id": "0", "components": [] } }
+		}`, w)
+
+		assert.NoError(t, err)
+		return pm
+	}
+
+	previous := load(`{ "Player": { "id": 0, "owner": "player" }, "Bullet": { "id": 1, "owner": "player" } }`)
+
+	assert.NoError(t, load(`{ "Player": { "id": 0, "owner": "player" }, "Rocket": { "id": 2, "owner": "player" } }`).CheckNetworkPrefabIds(previous))
+	assert.NoError(t, previous.CheckNetworkPrefabIds(nil))
+
+	err := load(`{ "Player": { "id": 2, "owner": "player" }, "Rocket": { "id": 0, "owner": "player" } }`).CheckNetworkPrefabIds(previous)
+
+	assert.EqualError(t, err, "2 problem(s) loading game data:\n"+
+		"  - field \"network.Player.id\": id changed from 0 to 2, network prefab ids can't change\n"+
+		"  - field \"network.Rocket.id\": id 0 was network prefab \"Player\", network prefab ids can't change")
+}
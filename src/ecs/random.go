@@ -0,0 +1,54 @@
+package ecs
+
+/**
+Random
+
+Deterministic random number generator for gameplay code. Never use math/rand in a
+system, the client would predict different values than the server.
+
+The whole generator is one uint64 so it's cached with every tick and restored by
+ResetToTick. The server picks the seed and sends the generator's state at the current tick to
+clients in the handshake.
+*/
+
+type Random struct {
+	State uint64
+}
+
+func NewRandom(seed uint64) *Random {
+	return &Random{State: seed}
+}
+
+// splitmix64
+func (self *Random) Uint64() uint64 {
+	self.State += 0x9E3779B97F4A7C15
+	z := self.State
+	z = (z ^ (z >> 30)) * 0xBF58476D1CE4E5B9
+	z = (z ^ (z >> 27)) * 0x94D049BB133111EB
+	return z ^ (z >> 31)
+}
+
+// returns a value in [0, n). Returns 0 if n <= 0.
+func (self *Random) Intn(n int) int {
+	if n <= 0 {
+		return 0
+	}
+	return int(self.Uint64() % uint64(n))
+}
+
+// returns a value in [0, 1).
+func (self *Random) Float64() float64 {
+	return float64(self.Uint64()>>11) / (1 << 53)
+}
+
+// returns a value in [min, max).
+func (self *Random) Range(min float64, max float64) float64 {
+	return min + self.Float64()*(max-min)
+}
+
+// Seeds the world generator. The server calls this once on startup and clients
+// call it with the generator state from the connection handshake.
+func (w *World) Seed(seed uint64) {
+	w.RandomSeed = seed
+	w.Rand = NewRandom(seed)
+}
@@ -0,0 +1,80 @@
+package ecs_test
+
+import (
+	"github.com/Banyango/io-engine/src/ecs"
+	"github.com/Banyango/io-engine/src/game"
+	"github.com/Banyango/io-engine/src/math"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+// moves every entity by a random amount each tick.
+type randomMoveSystem struct {
+}
+
+func (*randomMoveSystem) Init(w *ecs.World)                    {}
+func (*randomMoveSystem) AddToStorage(entity *ecs.Entity)      {}
+func (*randomMoveSystem) RemoveFromStorage(entity *ecs.Entity) {}
+
+func (*randomMoveSystem) RequiredComponentTypes() []ecs.ComponentType {
+	return []ecs.ComponentType{ecs.PositionComponentType}
+}
+
+func (*randomMoveSystem) UpdateSystem(delta float64, world *ecs.World) {
+	for i := range world.Entities {
+		comp := world.Entities[i].Components[int(ecs.PositionComponentType)].(*game.PositionComponent)
+		comp.Position = comp.Position.Add(math.NewVectorInt(world.Rand.Intn(100), world.Rand.Intn(100)))
+	}
+}
+
+func TestRandom_SameSeedSameSequence(t *testing.T) {
+	a := ecs.NewRandom(1234)
+	b := ecs.NewRandom(1234)
+
+	for i := 0; i < 100; i++ {
+		assert.Equal(t, a.Uint64(), b.Uint64())
+	}
+
+	c := ecs.NewRandom(1235)
+	assert.NotEqual(t, a.Uint64(), c.Uint64())
+}
+
+func TestRandom_Ranges(t *testing.T) {
+	r := ecs.NewRandom(99)
+
+	for i := 0; i < 1000; i++ {
+		n := r.Intn(10)
+		assert.True(t, n >= 0 && n < 10)
+
+		f := r.Range(-2, 3)
+		assert.True(t, f >= -2 && f < 3)
+	}
+
+	assert.Equal(t, 0, r.Intn(0))
+}
+
+func TestWorld_RandomResimulate(t *testing.T) {
+	world, position := createTimerWorld()
+	world.Seed(42)
+	world.AddSystem(new(randomMoveSystem))
+
+	for i := 0; i < 10; i++ {
+		world.Update(0.016)
+	}
+
+	world.ResetToTick(4)
+	world.Resimulate(4)
+
+	// ResetToTick restores the cached state of the tick after the one given,
+	// so the resimulated world has run one more tick.
+	expectedWorld, expectedPosition := createTimerWorld()
+	expectedWorld.Seed(42)
+	expectedWorld.AddSystem(new(randomMoveSystem))
+
+	for i := 0; i < 11; i++ {
+		expectedWorld.Update(0.016)
+	}
+
+	assert.Equal(t, expectedPosition.Position, position.Position)
+	assert.Equal(t, expectedWorld.Rand.State, world.Rand.State)
+}
@@ -0,0 +1,33 @@
+package ecs
+
+import (
+	"github.com/goburrow/dynamic"
+	"sync"
+)
+
+var (
+	registeredComponents   = map[string]func() interface{}{}
+	registeredComponentMux sync.Mutex
+)
+
+// Registers a component type with the json loader.
+// Systems register their components in Init, so registering the same name again is a no-op.
+func RegisterComponent(name string, factory func() interface{}) {
+	registeredComponentMux.Lock()
+	defer registeredComponentMux.Unlock()
+
+	if _, ok := registeredComponents[name]; ok {
+		return
+	}
+
+	dynamic.Register(name, factory)
+	registeredComponents[name] = factory
+}
+
+func componentFactory(name string) (func() interface{}, bool) {
+	registeredComponentMux.Lock()
+	defer registeredComponentMux.Unlock()
+
+	factory, ok := registeredComponents[name]
+	return factory, ok
+}
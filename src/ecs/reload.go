@@ -0,0 +1,95 @@
+package ecs
+
+import (
+	"reflect"
+	"sort"
+)
+
+/**
+Reloading
+
+Game data can be reloaded while the game is running. Entities keep their state, only the
+values tuned in game data (speeds, sizes, colors...) are patched, and only for components
+that implement TunableComponent.
+*/
+
+// Implemented by components with values that are tuned in game data. ApplyTuning copies the
+// tuned values from the reloaded prefab component without touching the entity's state.
+type TunableComponent interface {
+	ApplyTuning(prefab Component)
+}
+
+// Swaps in reloaded game data and returns the ids of the prefabs that changed. Must run on the
+// tick goroutine, use Enqueue. When patch is true the tunable components of entities created
+// from changed prefabs are updated, cached and static copies included so a rollback or a reset
+// keeps the new values.
+func (w *World) ReloadPrefabs(prefabData *PrefabData, patch bool) []int {
+	changed := changedPrefabs(w.PrefabData, prefabData)
+
+	w.PrefabData = prefabData
+
+	if !patch || len(changed) == 0 {
+		return changed
+	}
+
+	isChanged := map[int]bool{}
+	for _, id := range changed {
+		isChanged[id] = true
+	}
+
+	for _, entity := range w.Entities {
+		if isChanged[entity.PrefabId] {
+			applyTuning(entity, prefabData.Prefabs[entity.PrefabId])
+		}
+	}
+
+	for i := range w.Static {
+		if isChanged[w.Static[i].PrefabId] {
+			applyTuning(&w.Static[i], prefabData.Prefabs[w.Static[i].PrefabId])
+		}
+	}
+
+	for i := range w.Cache {
+		for _, entity := range w.Cache[i] {
+			if isChanged[entity.PrefabId] {
+				applyTuning(entity, prefabData.Prefabs[entity.PrefabId])
+			}
+		}
+	}
+
+	return changed
+}
+
+func applyTuning(entity *Entity, prefab Entity) {
+	for id, component := range entity.Components {
+		tunable, ok := component.(TunableComponent)
+
+		if !ok {
+			continue
+		}
+
+		if prefabComponent, ok := prefab.Components[id]; ok {
+			tunable.ApplyTuning(prefabComponent)
+		}
+	}
+}
+
+// Prefabs that are new or whose components are different. Removed prefabs aren't included,
+// entities created from them are left as they are.
+func changedPrefabs(old *PrefabData, new *PrefabData) []int {
+	changed := []int{}
+
+	for id, prefab := range new.Prefabs {
+		if old != nil {
+			if oldPrefab, ok := old.Prefabs[id]; ok && reflect.DeepEqual(oldPrefab.Components, prefab.Components) {
+				continue
+			}
+		}
+
+		changed = append(changed, id)
+	}
+
+	sort.Ints(changed)
+
+	return changed
+}
@@ -0,0 +1,139 @@
+package ecs_test
+
+import (
+	"github.com/Banyango/io-engine/src/ecs"
+	"github.com/Banyango/io-engine/src/game"
+	"github.com/Banyango/io-engine/src/math"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+const reloadGameJson = `{
+	"name": "test",
+	"version": "0.0.1",
+	"prefabs": {
+		"player" : {
+			"id": "0",
+			"components": [
+				{"Type":"PositionComponent", "Position":[0,0] },
+				{"Type":"ArcadeMovementComponent", "MaxSpeed":[200,200], "Speed":400, "Drag":0.8, "Gravity":[0,0] }
+			]
+		},
+		"wall" : {
+			"id": "1",
+			"components": [
+				{"Type":"PositionComponent", "Position":[0,0] },
+				{"Type":"ArcadeMovementComponent", "MaxSpeed":[0,0], "Speed":0, "Drag":0, "Gravity":[0,0] }
+			]
+		}
+	}
+}`
+
+func createReloadWorld(t *testing.T) (*ecs.World, *ecs.Entity) {
+	game.RegisterComponents()
+
+	world := ecs.NewWorld()
+
+	prefabData, err := ecs.NewPrefabManager(reloadGameJson, world)
+	assert.NoError(t, err)
+
+	world.PrefabData = prefabData
+
+	entity, err := prefabData.CreatePrefab(0)
+	assert.NoError(t, err)
+
+	entity.Id = world.FetchAndIncrementId()
+	entity.Components[int(ecs.PositionComponentType)].(*game.PositionComponent).Position = math.NewVectorInt(5, 5)
+
+	world.AddEntityToWorld(entity)
+
+	for i := 0; i < 3; i++ {
+		world.Update(ecs.FIXED_DELTA)
+	}
+
+	return world, world.Entities[entity.Id]
+}
+
+func TestWorld_ReloadPrefabsPatchesTuning(t *testing.T) {
+	world, entity := createReloadWorld(t)
+
+	prefabData, err := ecs.NewPrefabManager(strings.Replace(reloadGameJson, `"Speed":400`, `"Speed":800`, 1), world)
+	assert.NoError(t, err)
+
+	changed := world.ReloadPrefabs(prefabData, true)
+
+	assert.Equal(t, []int{0}, changed)
+	assert.Equal(t, prefabData, world.PrefabData)
+
+	movement := entity.Components[int(ecs.ArcadeMovementComponentType)].(*game.ArcadeMovementComponent)
+	assert.Equal(t, 800.0, movement.Speed)
+
+	// state isn't touched.
+	assert.Equal(t, math.NewVectorInt(5, 5), entity.Components[int(ecs.PositionComponentType)].(*game.PositionComponent).Position)
+
+	// a rollback keeps the new values.
+	world.ResetToTick(1)
+	assert.Equal(t, 800.0, movement.Speed)
+}
+
+func TestWorld_ReloadPrefabsWithoutPatch(t *testing.T) {
+	world, entity := createReloadWorld(t)
+
+	prefabData, err := ecs.NewPrefabManager(strings.Replace(reloadGameJson, `"Speed":400`, `"Speed":800`, 1), world)
+	assert.NoError(t, err)
+
+	world.ReloadPrefabs(prefabData, false)
+
+	movement := entity.Components[int(ecs.ArcadeMovementComponentType)].(*game.ArcadeMovementComponent)
+	assert.Equal(t, 400.0, movement.Speed)
+
+	// new entities use the reloaded prefab.
+	created, err := world.PrefabData.CreatePrefab(0)
+	assert.NoError(t, err)
+	assert.Equal(t, 800.0, created.Components[int(ecs.ArcadeMovementComponentType)].(*game.ArcadeMovementComponent).Speed)
+}
+
+func TestWorld_ReloadPrefabsUnchanged(t *testing.T) {
+	world, entity := createReloadWorld(t)
+
+	movement := entity.Components[int(ecs.ArcadeMovementComponentType)].(*game.ArcadeMovementComponent)
+	movement.Speed = 1
+
+	prefabData, err := ecs.NewPrefabManager(reloadGameJson, world)
+	assert.NoError(t, err)
+
+	assert.Empty(t, world.ReloadPrefabs(prefabData, true))
+	assert.Equal(t, 1.0, movement.Speed)
+}
+
+// Cached entities keep their prefab, a rollback after a reload patches only the prefab that changed.
+func TestWorld_ReloadPrefabsRollbackKeepsPrefab(t *testing.T) {
+	world, player := createReloadWorld(t)
+
+	wall, err := world.PrefabData.CreatePrefab(1)
+	assert.NoError(t, err)
+
+	wall.Id = world.FetchAndIncrementId()
+	world.AddEntityToWorld(wall)
+
+	for i := 0; i < 3; i++ {
+		world.Update(ecs.FIXED_DELTA)
+	}
+
+	prefabData, err := ecs.NewPrefabManager(strings.Replace(reloadGameJson, `"Speed":0`, `"Speed":20`, 1), world)
+	assert.NoError(t, err)
+
+	assert.Equal(t, []int{1}, world.ReloadPrefabs(prefabData, true))
+
+	tick := world.CurrentTick - 2
+	world.ResetToTick(tick)
+	world.Resimulate(tick)
+
+	speed := func(entity *ecs.Entity) float64 {
+		return entity.Components[int(ecs.ArcadeMovementComponentType)].(*game.ArcadeMovementComponent).Speed
+	}
+
+	assert.Equal(t, 400.0, speed(player))
+	assert.Equal(t, 20.0, speed(world.Entities[wall.Id]))
+}
@@ -0,0 +1,170 @@
+package ecs
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+/**
+Scenes
+
+Scenes list the entities a world starts with. They're separate json files referenced from game.json:
+
+	"scene": "arena",
+	"scenes": { "arena": "scenes/arena.json" }
+
+	{
+		"name": "arena",
+		"entities": [
+			{ "prefab": "Wall", "position": [100,0], "components": [ {"Type":"CollisionComponent", "Size":[50,10]} ] }
+		]
+	}
+
+Every entity is created from a network prefab so it can be replicated to clients. "position" is
+short for a PositionComponent override, "components" patch fields of the prefab's components.
+*/
+
+type SceneJson struct {
+	Name     string            `json:"name"`
+	Format   int               `json:"format,omitempty"`
+	Entities []SceneEntityJson `json:"entities"`
+}
+
+type SceneEntityJson struct {
+	Prefab     string            `json:"prefab"`
+	Position   json.RawMessage   `json:"position,omitempty"`
+	Components []json.RawMessage `json:"components,omitempty"`
+}
+
+type SceneEntity struct {
+	NetworkPrefab NetworkPrefab
+	Entity        Entity // owner view of the network prefab with the overrides applied
+	Overrides     []byte // json array of the component overrides, sent to clients to apply to their view
+}
+
+// Creates the entities of a scene, nothing is added to a world. Scenes are always loaded strictly,
+// if anything is wrong no entities are returned and every problem is in the LoadErrors.
+func (self *PrefabData) LoadScene(name string, data []byte) ([]SceneEntity, error) {
+	scene := SceneJson{}
+
+	data, _, err := MigrateScene(data)
+
+	if err != nil {
+		return nil, LoadErrors{{Scene: name, ComponentIndex: -1, Field: "format", Err: err}}
+	}
+
+	if err := json.Unmarshal(data, &scene); err != nil {
+		return nil, LoadErrors{{Scene: name, ComponentIndex: -1, Err: err}}
+	}
+
+	errs := LoadErrors{}
+	result := []SceneEntity{}
+
+	for i, sceneEntity := range scene.Entities {
+		networkPrefab, ok := self.NetworkPrefabByName(sceneEntity.Prefab)
+
+		if !ok {
+			errs = append(errs, LoadError{Scene: name, Entity: i, ComponentIndex: -1, Field: "prefab", Err: fmt.Errorf("network prefab %q doesn't exist", sceneEntity.Prefab)})
+			continue
+		}
+
+		overrides := sceneEntity.Components
+
+		if sceneEntity.Position != nil {
+			position, err := json.Marshal(map[string]json.RawMessage{"Type": json.RawMessage(`"PositionComponent"`), "Position": sceneEntity.Position})
+
+			if err != nil {
+				errs = append(errs, LoadError{Scene: name, Entity: i, Prefab: sceneEntity.Prefab, ComponentIndex: -1, Field: "position", Err: err})
+				continue
+			}
+
+			overrides = append([]json.RawMessage{position}, overrides...)
+		}
+
+		prefabOverrides := make([]PrefabOverride, len(overrides))
+		for j := range overrides {
+			prefabOverrides[j] = WithComponentJson(string(overrides[j]))
+		}
+
+		entity, err := self.CreatePrefab(networkPrefab.Owner, prefabOverrides...)
+
+		if err != nil {
+			problems, ok := err.(LoadErrors)
+
+			if !ok {
+				problems = LoadErrors{{ComponentIndex: -1, Err: err}}
+			}
+
+			for _, problem := range problems {
+				problem.Scene = name
+				problem.Entity = i
+				problem.Prefab = sceneEntity.Prefab
+
+				// the position override wasn't written as a component.
+				if sceneEntity.Position != nil && problem.ComponentIndex >= 0 {
+					problem.ComponentIndex--
+					if problem.ComponentIndex < 0 {
+						problem.ComponentIndex = -1
+						problem.ComponentType = ""
+						problem.Field = "position"
+					}
+				}
+
+				errs = append(errs, problem)
+			}
+			continue
+		}
+
+		loaded := SceneEntity{NetworkPrefab: networkPrefab, Entity: entity}
+
+		if len(overrides) > 0 {
+			if loaded.Overrides, err = json.Marshal(overrides); err != nil {
+				errs = append(errs, LoadError{Scene: name, Entity: i, ComponentIndex: -1, Err: err})
+				continue
+			}
+		}
+
+		result = append(result, loaded)
+	}
+
+	if len(errs) > 0 {
+		return nil, errs
+	}
+
+	return result, nil
+}
+
+// Applies a json array of component overrides to an entity. When strict, overriding a component
+// the entity doesn't have is reported, otherwise it's skipped. Used by clients, whose peer view
+// of a prefab may not have every component. Returns nil or LoadErrors.
+func ApplyComponentOverrides(entity *Entity, data []byte, strict bool) error {
+	overrides := []json.RawMessage{}
+
+	if err := json.Unmarshal(data, &overrides); err != nil {
+		return LoadErrors{{ComponentIndex: -1, Err: err}}
+	}
+
+	if problems := applyComponentOverrides(entity, overrides, strict); len(problems) > 0 {
+		return problems
+	}
+
+	return nil
+}
+
+func applyComponentOverrides(entity *Entity, overrides []json.RawMessage, strict bool) LoadErrors {
+	errs := LoadErrors{}
+
+	if entity.Components == nil {
+		return LoadErrors{{ComponentIndex: -1, Err: errors.New("entity has no components")}}
+	}
+
+	for i := range overrides {
+		for _, problem := range overrideComponent(entity, overrides[i], strict) {
+			problem.ComponentIndex = i
+			errs = append(errs, problem)
+		}
+	}
+
+	return errs
+}
@@ -0,0 +1,108 @@
+package ecs_test
+
+import (
+	"github.com/Banyango/io-engine/src/ecs"
+	"github.com/Banyango/io-engine/src/game"
+	"github.com/Banyango/io-engine/src/math"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+const sceneGameJson = `{
+	"name": "test",
+	"version": "0.0.1",
+	"network": {
+		"Wall": { "id": 0, "owner": "wall", "peer": "wall_peer" }
+	},
+	"scene": "arena",
+	"scenes": { "arena": "scenes/arena.json" },
+	"prefabs": {
+		"wall" : {
+			"id": "0",
+			"components": [
+				{"Type":"PositionComponent", "Position":[0,0] },
+				{"Type":"CircleRendererComponent", "Size":[6,6], "Radius":12.3, "Color":"#001121" }
+			]
+		},
+		"wall_peer" : {
+			"id": "1",
+			"components": [
+				{"Type":"PositionComponent", "Position":[0,0] }
+			]
+		}
+	}
+}`
+
+func createScenePrefabs(t *testing.T) *ecs.PrefabData {
+	game.RegisterComponents()
+
+	world := ecs.NewWorld()
+	world.StrictLoading = true
+
+	prefabData, err := ecs.NewPrefabManager(sceneGameJson, world)
+	assert.NoError(t, err)
+
+	return prefabData
+}
+
+func TestPrefabData_LoadScene(t *testing.T) {
+	prefabData := createScenePrefabs(t)
+
+	assert.Equal(t, "arena", prefabData.Scene)
+	assert.Equal(t, "scenes/arena.json", prefabData.Scenes["arena"])
+
+	entities, err := prefabData.LoadScene("arena", []byte(`{
+		"name": "arena",
+		"entities": [
+			{ "prefab": "Wall" },
+			{ "prefab": "Wall", "position": [10, 20], "components": [ {"Type":"CircleRendererComponent", "Radius":4 } ] }
+		]
+	}`))
+
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(entities))
+
+	assert.Equal(t, "Wall", entities[0].NetworkPrefab.Name)
+	assert.Equal(t, math.NewVectorInt(0, 0), entities[0].Entity.Components[int(ecs.PositionComponentType)].(*game.PositionComponent).Position)
+
+	moved := entities[1].Entity
+	assert.Equal(t, math.NewVectorInt(10, 20), moved.Components[int(ecs.PositionComponentType)].(*game.PositionComponent).Position)
+	assert.Equal(t, float32(4), moved.Components[int(ecs.CircleComponentType)].(*game.CircleRendererComponent).Radius)
+
+	// the prefab itself isn't changed.
+	prefab, _ := prefabData.CreatePrefab(0)
+	assert.Equal(t, float32(12.3), prefab.Components[int(ecs.CircleComponentType)].(*game.CircleRendererComponent).Radius)
+
+	// clients apply the same overrides to the peer view, which has no renderer.
+	peer, _ := prefabData.CreateNetworkPrefab(entities[1].NetworkPrefab.Id, true)
+	assert.NoError(t, ecs.ApplyComponentOverrides(&peer, entities[1].Overrides, false))
+	assert.Equal(t, math.NewVectorInt(10, 20), peer.Components[int(ecs.PositionComponentType)].(*game.PositionComponent).Position)
+}
+
+func TestPrefabData_LoadSceneErrors(t *testing.T) {
+	prefabData := createScenePrefabs(t)
+
+	entities, err := prefabData.LoadScene("arena", []byte(`{
+		"name": "arena",
+		"entities": [
+			{ "prefab": "Tower" },
+			{ "prefab": "Wall", "position": [1.5, 0] },
+			{ "prefab": "Wall", "components": [
+				{"Type":"CircleRendererComponent", "Radius":"big", "Colour":"#fff" },
+				{"Type":"ArcadeMovementComponent", "Speed":1 }
+			] }
+		]
+	}`))
+
+	assert.Nil(t, entities)
+
+	loadErrors, ok := err.(ecs.LoadErrors)
+	assert.True(t, ok)
+	assert.Equal(t, 5, len(loadErrors))
+
+	assert.Contains(t, err.Error(), `scene "arena" entity 0 field "prefab": network prefab "Tower" doesn't exist`)
+	assert.Contains(t, err.Error(), `scene "arena" entity 1 prefab "Wall" field "position"`)
+	assert.Contains(t, err.Error(), `scene "arena" entity 2 prefab "Wall" component 0 (CircleRendererComponent) field "Colour": unknown field`)
+	assert.Contains(t, err.Error(), `scene "arena" entity 2 prefab "Wall" component 0 (CircleRendererComponent) field "Radius"`)
+	assert.Contains(t, err.Error(), `scene "arena" entity 2 prefab "Wall" component 1 (ArcadeMovementComponent) field "Type": prefab doesn't have this component`)
+}
@@ -0,0 +1,256 @@
+package ecs
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+/**
+Schema
+
+GameDataSchema generates a JSON Schema (draft-07) for game.json from the registered
+component types so editors can check game data while it's written. ValidateGameData
+checks game data against the same schema.
+
+Fields are described from their Go types. Types that are written differently in game data,
+like vectors written as [x,y], describe themselves by implementing JsonSchemaType.
+*/
+
+const JSON_SCHEMA_VERSION = "http://json-schema.org/draft-07/schema#"
+
+// Implemented by field types that aren't written as their Go structure in game data.
+// Returns the schema as a plain json object since math can't import ecs.
+type JsonSchemaType interface {
+	JsonSchema() map[string]interface{}
+}
+
+type JsonSchema struct {
+	Schema               string                 `json:"$schema,omitempty"`
+	Title                string                 `json:"title,omitempty"`
+	Ref                  string                 `json:"$ref,omitempty"`
+	Type                 interface{}            `json:"type,omitempty"` // a type name or a list of them
+	Properties           map[string]*JsonSchema `json:"properties,omitempty"`
+	AdditionalProperties interface{}            `json:"additionalProperties,omitempty"` // false or a schema
+	Required             []string               `json:"required,omitempty"`
+	Items                *JsonSchema            `json:"items,omitempty"`
+	MinItems             *int                   `json:"minItems,omitempty"`
+	MaxItems             *int                   `json:"maxItems,omitempty"`
+	Enum                 []interface{}          `json:"enum,omitempty"`
+	Const                interface{}            `json:"const,omitempty"`
+	Pattern              string                 `json:"pattern,omitempty"`
+	AllOf                []*JsonSchema          `json:"allOf,omitempty"`
+	If                   *JsonSchema            `json:"if,omitempty"`
+	Then                 *JsonSchema            `json:"then,omitempty"`
+	Definitions          map[string]*JsonSchema `json:"definitions,omitempty"`
+}
+
+// Generates the schema for game.json from the currently registered components.
+func GameDataSchema() *JsonSchema {
+	names := registeredComponentNames()
+
+	typeNames := make([]interface{}, len(names))
+	for i := range names {
+		typeNames[i] = names[i]
+	}
+
+	definitions := map[string]*JsonSchema{}
+
+	// the component schema only checks fields once the Type is known.
+	component := &JsonSchema{
+		Type:       "object",
+		Required:   []string{"Type"},
+		Properties: map[string]*JsonSchema{"Type": {Enum: typeNames}},
+	}
+
+	for _, name := range names {
+		factory, _ := componentFactory(name)
+
+		definition := schemaForType(reflect.TypeOf(factory()))
+		definition.Properties["Type"] = &JsonSchema{Const: name}
+
+		definitions[name] = definition
+
+		component.AllOf = append(component.AllOf, &JsonSchema{
+			If:   &JsonSchema{Required: []string{"Type"}, Properties: map[string]*JsonSchema{"Type": {Const: name}}},
+			Then: &JsonSchema{Ref: "#/definitions/" + name},
+		})
+	}
+
+	definitions["component"] = component
+
+	definitions["prefab"] = &JsonSchema{
+		Type: "object",
+		Properties: map[string]*JsonSchema{
+			"id":         {Type: []string{"integer", "string"}, Pattern: "^-?[0-9]+$"},
+			"name":       {Type: "string"},
+			"extends":    {Type: "string"},
+			"remove":     {Type: "array", Items: &JsonSchema{Enum: typeNames}},
+			"components": {Type: "array", Items: &JsonSchema{Ref: "#/definitions/component"}},
+		},
+		Required:             []string{"id"},
+		AdditionalProperties: false,
+	}
+
+	definitions["networkPrefab"] = &JsonSchema{
+		Type: "object",
+		Properties: map[string]*JsonSchema{
+			"id":    {Type: "integer"},
+			"owner": {Type: "string"},
+			"peer":  {Type: "string"},
+		},
+		Required:             []string{"id", "owner"},
+		AdditionalProperties: false,
+	}
+
+	definitions["inputAction"] = &JsonSchema{
+		Type: "object",
+		Properties: map[string]*JsonSchema{
+			"name":    {Type: "string"},
+			"keys":    {Type: "array", Items: &JsonSchema{Type: "string"}},
+			"mouse":   {Type: "array", Items: &JsonSchema{Type: "integer"}},
+			"gamepad": {Type: "array", Items: &JsonSchema{Type: "integer"}},
+			"predict": {Type: "string", Enum: []interface{}{PREDICT_REPEAT, PREDICT_RELEASE}},
+		},
+		Required:             []string{"name"},
+		AdditionalProperties: false,
+	}
+
+	definitions["inputAxis"] = &JsonSchema{
+		Type: "object",
+		Properties: map[string]*JsonSchema{
+			"name":     {Type: "string"},
+			"negative": {Type: "array", Items: &JsonSchema{Type: "string"}},
+			"positive": {Type: "array", Items: &JsonSchema{Type: "string"}},
+			"gamepad":  {Type: "integer"},
+		},
+		Required:             []string{"name"},
+		AdditionalProperties: false,
+	}
+
+	definitions["input"] = &JsonSchema{
+		Type: "object",
+		Properties: map[string]*JsonSchema{
+			"actions": {Type: "array", Items: &JsonSchema{Ref: "#/definitions/inputAction"}},
+			"axes":    {Type: "array", Items: &JsonSchema{Ref: "#/definitions/inputAxis"}},
+			"cursor":  {Type: "boolean"},
+		},
+		AdditionalProperties: false,
+	}
+
+	return &JsonSchema{
+		Schema: JSON_SCHEMA_VERSION,
+		Title:  "game data",
+		Type:   "object",
+		Properties: map[string]*JsonSchema{
+			"name":    {Type: "string"},
+			"version": {Type: "string"},
+			"format":  {Type: "integer", Const: float64(GameDataFormat)},
+			"globals": {},
+			"player":  {Type: "string"},
+			"network": {Type: "object", AdditionalProperties: &JsonSchema{Ref: "#/definitions/networkPrefab"}},
+			"prefabs": {Type: "object", AdditionalProperties: &JsonSchema{Ref: "#/definitions/prefab"}},
+			"scene":   {Type: "string"},
+			"map":     {Type: "string"},
+			"input":   {Ref: "#/definitions/input"},
+			"scenes":  {Type: "object", AdditionalProperties: &JsonSchema{Type: "string"}},
+		},
+		AdditionalProperties: false,
+		Definitions:          definitions,
+	}
+}
+
+func schemaForType(t reflect.Type) *JsonSchema {
+	if t.Implements(reflect.TypeOf((*JsonSchemaType)(nil)).Elem()) || reflect.PtrTo(t).Implements(reflect.TypeOf((*JsonSchemaType)(nil)).Elem()) {
+		return schemaFromProvider(t)
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		return schemaForType(t.Elem())
+	case reflect.Bool:
+		return &JsonSchema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &JsonSchema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &JsonSchema{Type: "number"}
+	case reflect.String:
+		return &JsonSchema{Type: "string"}
+	case reflect.Slice:
+		return &JsonSchema{Type: "array", Items: schemaForType(t.Elem())}
+	case reflect.Array:
+		length := t.Len()
+		return &JsonSchema{Type: "array", Items: schemaForType(t.Elem()), MinItems: &length, MaxItems: &length}
+	case reflect.Map:
+		return &JsonSchema{Type: "object", AdditionalProperties: schemaForType(t.Elem())}
+	case reflect.Struct:
+		schema := &JsonSchema{Type: "object", Properties: map[string]*JsonSchema{}, AdditionalProperties: false}
+
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+
+			if name, ok := schemaFieldName(field); ok {
+				schema.Properties[name] = schemaForType(field.Type)
+			}
+		}
+
+		return schema
+	}
+
+	// interfaces and anything else accept any value.
+	return &JsonSchema{}
+}
+
+func schemaFromProvider(t reflect.Type) *JsonSchema {
+	value := reflect.New(t)
+
+	provider, ok := value.Interface().(JsonSchemaType)
+
+	if !ok {
+		provider = value.Elem().Interface().(JsonSchemaType)
+	}
+
+	data, _ := json.Marshal(provider.JsonSchema())
+
+	schema := &JsonSchema{}
+	_ = json.Unmarshal(data, schema)
+
+	return schema
+}
+
+// Game data is written with the Go field names and the loader matches them case insensitively,
+// so a json tag that only changes the case keeps the Go name.
+func schemaFieldName(field reflect.StructField) (string, bool) {
+	if field.PkgPath != "" {
+		return "", false
+	}
+
+	name := field.Name
+
+	if tag := field.Tag.Get("json"); tag != "" {
+		tagName := strings.Split(tag, ",")[0]
+		if tagName == "-" {
+			return "", false
+		}
+		if tagName != "" && !strings.EqualFold(tagName, name) {
+			name = tagName
+		}
+	}
+
+	return name, true
+}
+
+func registeredComponentNames() []string {
+	registeredComponentMux.Lock()
+	defer registeredComponentMux.Unlock()
+
+	names := make([]string, 0, len(registeredComponents))
+	for name := range registeredComponents {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
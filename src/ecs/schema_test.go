@@ -0,0 +1,96 @@
+package ecs_test
+
+import (
+	"github.com/Banyango/io-engine/src/ecs"
+	"github.com/Banyango/io-engine/src/game"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"testing"
+)
+
+func TestGameDataSchema(t *testing.T) {
+	game.RegisterComponents()
+
+	schema := ecs.GameDataSchema()
+
+	position := schema.Definitions["PositionComponent"].Properties["Position"]
+	assert.Equal(t, "array", position.Type)
+	assert.Equal(t, "integer", position.Items.Type)
+	assert.Equal(t, 2, *position.MinItems)
+	assert.Equal(t, 2, *position.MaxItems)
+
+	renderer := schema.Definitions["CircleRendererComponent"]
+	assert.Equal(t, "number", renderer.Properties["Size"].Items.Type)
+	assert.Equal(t, "string", renderer.Properties["Color"].Type)
+	assert.NotEmpty(t, renderer.Properties["Color"].Pattern)
+	assert.Equal(t, "number", renderer.Properties["Radius"].Type)
+
+	// the json tag only changes the case so game data keeps using the Go name.
+	collision := schema.Definitions["CollisionComponent"]
+	assert.NotNil(t, collision.Properties["Size"])
+	assert.Nil(t, collision.Properties["entitiesCollidingWith"])
+}
+
+func TestValidateGameData_GameJson(t *testing.T) {
+	game.RegisterComponents()
+
+	gameJson, err := ioutil.ReadFile("../../game.json")
+	assert.NoError(t, err)
+
+	assert.NoError(t, ecs.ValidateGameData(gameJson))
+}
+
+func TestValidateGameData_ReportsLines(t *testing.T) {
+	game.RegisterComponents()
+
+	json := `{
+	"name": "test",
+	"network": { "Player": { "id": 0, "owner": "player" } },
+	"prefabs": {
+		"player" : {
+			"id": "0",
+			"components": [
+				{"Type":"PositionComponent", "Position":[0,0,1] },
+				{"Type":"CircleRendererComponent", "Color":"red", "Radius":"big" },
+				{"Type":"WallComponent" }
+			]
+		}
+	},
+	"extra": true
+}`
+
+	err := ecs.ValidateGameData([]byte(json))
+
+	loadErrors, ok := err.(ecs.LoadErrors)
+	assert.True(t, ok)
+	assert.Equal(t, 5, len(loadErrors))
+
+	assert.Contains(t, err.Error(), `line 8: prefab "player" component 0 (PositionComponent) field "Position": expected 2 items, got 3`)
+	assert.Contains(t, err.Error(), `line 9: prefab "player" component 1 (CircleRendererComponent) field "Color": "red" doesn't match`)
+	assert.Contains(t, err.Error(), `line 9: prefab "player" component 1 (CircleRendererComponent) field "Radius": expected number, got string`)
+	assert.Contains(t, err.Error(), `line 10: prefab "player" component 2 (WallComponent) field "Type": unknown value WallComponent`)
+	assert.Contains(t, err.Error(), `line 14: field "extra": unknown field`)
+}
+
+func TestValidateGameData_ReportsReferences(t *testing.T) {
+	game.RegisterComponents()
+
+	json := `{
+	"name": "test",
+	"player": "Player",
+	"network": { "Player": { "id": 0, "owner": "player", "peer": "ghost" } },
+	"prefabs": {
+		"player" : { "id": "0", "components": [] },
+		"child" : { "id": "0", "extends": "parent" }
+	}
+}`
+
+	err := ecs.ValidateGameData([]byte(json))
+
+	loadErrors, ok := err.(ecs.LoadErrors)
+	assert.True(t, ok)
+	assert.Equal(t, 2, len(loadErrors))
+
+	assert.Contains(t, err.Error(), `line 7: prefab "child" field "extends": parent prefab "parent" doesn't exist`)
+	assert.Contains(t, err.Error(), `line 4: field "network.Player.peer": prefab "ghost" doesn't exist`)
+}
@@ -0,0 +1,334 @@
+package ecs
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+)
+
+/**
+Tiled maps
+
+Arenas are built in Tiled (https://www.mapeditor.org) and exported as JSON with embedded tilesets
+and CSV tile layers. The map is referenced from game.json and loaded by the server and every client,
+so its entities are static and never sent over the network:
+
+	"map": "maps/arena.json"
+
+Every tile of a tile layer and every object of an object layer becomes an entity with a
+PositionComponent at its center and a CollisionComponent of its size. A "prefab" custom property
+creates it from that prefab instead, the prefab must have both components. The property is looked
+up on the object, then on its tile, then on the layer. Layers with "collision" set to false only
+create the tiles and objects that have a prefab. Problems are reported with the map as the scene
+and the path of the tile or object in the map as the field.
+*/
+
+type TiledMapJson struct {
+	Orientation string             `json:"orientation"`
+	Infinite    bool               `json:"infinite"`
+	Width       int                `json:"width"`
+	Height      int                `json:"height"`
+	TileWidth   int                `json:"tilewidth"`
+	TileHeight  int                `json:"tileheight"`
+	Layers      []TiledLayerJson   `json:"layers"`
+	Tilesets    []TiledTilesetJson `json:"tilesets"`
+}
+
+type TiledLayerJson struct {
+	Name       string              `json:"name"`
+	Type       string              `json:"type"` // tilelayer, objectgroup, imagelayer or group
+	Width      int                 `json:"width"`
+	Height     int                 `json:"height"`
+	OffsetX    float64             `json:"offsetx"`
+	OffsetY    float64             `json:"offsety"`
+	Encoding   string              `json:"encoding"`
+	Data       json.RawMessage     `json:"data"` // array of gids for CSV layers, a string for base64 ones
+	Objects    []TiledObjectJson   `json:"objects"`
+	Layers     []TiledLayerJson    `json:"layers"`
+	Properties []TiledPropertyJson `json:"properties"`
+}
+
+type TiledObjectJson struct {
+	Id         int                 `json:"id"`
+	Name       string              `json:"name"`
+	X          float64             `json:"x"`
+	Y          float64             `json:"y"`
+	Width      float64             `json:"width"`
+	Height     float64             `json:"height"`
+	Rotation   float64             `json:"rotation"`
+	Gid        uint32              `json:"gid"`
+	Polygon    json.RawMessage     `json:"polygon"`
+	Polyline   json.RawMessage     `json:"polyline"`
+	Properties []TiledPropertyJson `json:"properties"`
+}
+
+type TiledTilesetJson struct {
+	FirstGid uint32          `json:"firstgid"`
+	Name     string          `json:"name"`
+	Source   string          `json:"source"` // external tilesets aren't supported
+	Tiles    []TiledTileJson `json:"tiles"`
+}
+
+type TiledTileJson struct {
+	Id         uint32              `json:"id"`
+	Properties []TiledPropertyJson `json:"properties"`
+}
+
+type TiledPropertyJson struct {
+	Name  string      `json:"name"`
+	Type  string      `json:"type"`
+	Value interface{} `json:"value"`
+}
+
+// gids keep the flip flags in their high bits.
+const TILED_GID_MASK = 0x0FFFFFFF
+
+// Creates the entities of a Tiled map, nothing is added to a world. Maps are always loaded strictly,
+// if anything is wrong no entities are returned and every problem is in the LoadErrors.
+func (self *PrefabData) LoadTiledMap(name string, data []byte) ([]Entity, error) {
+	tiledMap := TiledMapJson{}
+
+	if err := json.Unmarshal(data, &tiledMap); err != nil {
+		return nil, LoadErrors{{Scene: name, ComponentIndex: -1, Err: err}}
+	}
+
+	loader := tiledLoader{prefabData: self, name: name, tiledMap: &tiledMap, tiles: map[uint32][]TiledPropertyJson{}}
+
+	if tiledMap.Orientation != "" && tiledMap.Orientation != "orthogonal" {
+		loader.report("orientation", fmt.Errorf("%s maps aren't supported", tiledMap.Orientation))
+	}
+
+	if tiledMap.Infinite {
+		loader.report("infinite", errors.New("infinite maps aren't supported"))
+	}
+
+	for i, tileset := range tiledMap.Tilesets {
+		if tileset.Source != "" {
+			loader.report(fmt.Sprintf("tilesets.%d", i), fmt.Errorf("external tileset %q isn't supported, embed it in the map", tileset.Source))
+			continue
+		}
+
+		for _, tile := range tileset.Tiles {
+			loader.tiles[tileset.FirstGid+tile.Id] = tile.Properties
+		}
+	}
+
+	if len(loader.errs) == 0 {
+		loader.loadLayers(tiledMap.Layers, "layers", 0, 0, nil)
+	}
+
+	if len(loader.errs) > 0 {
+		return nil, loader.errs
+	}
+
+	return loader.entities, nil
+}
+
+// Loads a Tiled map with the world's prefabs and adds its entities to the world. They aren't networked,
+// so they're kept in Static for Reset to add back.
+func (w *World) LoadTiledMap(name string, data []byte) error {
+	entities, err := w.PrefabData.LoadTiledMap(name, data)
+
+	if err != nil {
+		return err
+	}
+
+	for _, entity := range entities {
+		w.Static = append(w.Static, *entity.Clone())
+		w.addStaticEntity(entity)
+	}
+
+	return nil
+}
+
+func (w *World) addStaticEntity(entity Entity) {
+	entity = *entity.Clone()
+	entity.Id = w.FetchAndIncrementId()
+	w.AddEntityToWorld(entity)
+}
+
+type tiledLoader struct {
+	prefabData *PrefabData
+	name       string
+	tiledMap   *TiledMapJson
+	tiles      map[uint32][]TiledPropertyJson // tile properties by gid
+	entities   []Entity
+	errs       LoadErrors
+}
+
+func (self *tiledLoader) loadLayers(layers []TiledLayerJson, path string, offsetX float64, offsetY float64, parent []TiledPropertyJson) {
+	for _, layer := range layers {
+		layerPath := path + "." + layer.Name
+		x := offsetX + layer.OffsetX
+		y := offsetY + layer.OffsetY
+
+		// properties of group layers apply to the layers in them.
+		properties := append(append([]TiledPropertyJson{}, parent...), layer.Properties...)
+
+		switch layer.Type {
+		case "tilelayer":
+			self.loadTileLayer(layer, layerPath, x, y, properties)
+		case "objectgroup":
+			self.loadObjectLayer(layer, layerPath, x, y, properties)
+		case "group":
+			self.loadLayers(layer.Layers, layerPath, x, y, properties)
+		}
+	}
+}
+
+func (self *tiledLoader) loadTileLayer(layer TiledLayerJson, path string, offsetX float64, offsetY float64, properties []TiledPropertyJson) {
+	if layer.Encoding != "" && layer.Encoding != "csv" {
+		self.report(path, fmt.Errorf("%s tile layers aren't supported, export them as CSV", layer.Encoding))
+		return
+	}
+
+	gids := []uint32{}
+
+	if err := json.Unmarshal(layer.Data, &gids); err != nil {
+		self.report(path+".data", err)
+		return
+	}
+
+	width := float64(self.tiledMap.TileWidth)
+	height := float64(self.tiledMap.TileHeight)
+
+	for i, gid := range gids {
+		gid &= TILED_GID_MASK
+
+		if gid == 0 || layer.Width == 0 {
+			continue
+		}
+
+		column := float64(i % layer.Width)
+		row := float64(i / layer.Width)
+
+		center := [2]float64{offsetX + column*width + width/2, offsetY + row*height + height/2}
+
+		self.create(fmt.Sprintf("%s.data.%d", path, i), center, [2]float64{width, height}, properties, self.tiles[gid])
+	}
+}
+
+func (self *tiledLoader) loadObjectLayer(layer TiledLayerJson, path string, offsetX float64, offsetY float64, properties []TiledPropertyJson) {
+	for i, object := range layer.Objects {
+		objectPath := fmt.Sprintf("%s.objects.%d", path, i)
+
+		if object.Polygon != nil || object.Polyline != nil {
+			self.report(objectPath, errors.New("polygons aren't supported, use rectangles"))
+			continue
+		}
+
+		if object.Rotation != 0 {
+			self.report(objectPath, errors.New("rotated objects aren't supported"))
+			continue
+		}
+
+		center := [2]float64{offsetX + object.X + object.Width/2, offsetY + object.Y + object.Height/2}
+
+		var tile []TiledPropertyJson
+
+		// tile objects are positioned by their bottom left corner.
+		if object.Gid != 0 {
+			center[1] -= object.Height
+			tile = self.tiles[object.Gid&TILED_GID_MASK]
+		}
+
+		self.create(objectPath, center, [2]float64{object.Width, object.Height}, properties, tile, object.Properties)
+	}
+}
+
+// Creates the entity from its prefab, or a bare collider if it doesn't have one. Later property
+// lists win, they're passed from the layer down to the object.
+func (self *tiledLoader) create(path string, center [2]float64, size [2]float64, properties ...[]TiledPropertyJson) {
+	prefab := ""
+	collision := true
+
+	for _, list := range properties {
+		for _, property := range list {
+			switch property.Name {
+			case "prefab":
+				if value, ok := property.Value.(string); ok {
+					prefab = value
+				} else {
+					self.report(path, errors.New("the prefab property must be a string"))
+					return
+				}
+			case "collision":
+				if value, ok := property.Value.(bool); ok {
+					collision = value
+				}
+			}
+		}
+	}
+
+	if prefab == "" && !collision {
+		return
+	}
+
+	components := []string{fmt.Sprintf(`{"Type":"PositionComponent", "Position":[%d,%d]}`, roundToInt(center[0]), roundToInt(center[1]))}
+
+	// points keep the size of their prefab.
+	if size[0] > 0 && size[1] > 0 {
+		components = append(components, fmt.Sprintf(`{"Type":"CollisionComponent", "Size":[%d,%d]}`, roundToInt(size[0]), roundToInt(size[1])))
+	}
+
+	var entity Entity
+	var problems LoadErrors
+
+	if prefab != "" {
+		overrides := make([]PrefabOverride, len(components))
+		for i := range components {
+			overrides[i] = WithComponentJson(components[i])
+		}
+
+		var err error
+		entity, err = self.prefabData.CreatePrefabByName(prefab, overrides...)
+
+		if loadErrors, ok := err.(LoadErrors); ok {
+			problems = loadErrors
+		} else if err != nil {
+			problems = LoadErrors{{ComponentIndex: -1, Field: "prefab", Err: err}}
+		}
+	} else {
+		if len(components) < 2 {
+			self.report(path, errors.New("colliders without a prefab need a size"))
+			return
+		}
+
+		entity = NewEntity()
+		entity.PrefabId = -1
+
+		for i := range components {
+			component, componentProblems := decodeComponent(json.RawMessage(components[i]), true)
+
+			for _, problem := range componentProblems {
+				problem.ComponentIndex = i
+				problems = append(problems, problem)
+			}
+
+			if len(componentProblems) == 0 {
+				entity.Components[component.Id()] = component
+			}
+		}
+	}
+
+	for _, problem := range problems {
+		problem.Scene = self.name
+		problem.Entity = len(self.entities)
+		problem.Field = strings.TrimSuffix(path+"."+problem.Field, ".")
+		self.errs = append(self.errs, problem)
+	}
+
+	if len(problems) == 0 {
+		self.entities = append(self.entities, entity)
+	}
+}
+
+func (self *tiledLoader) report(path string, err error) {
+	self.errs = append(self.errs, LoadError{Scene: self.name, Entity: len(self.entities), ComponentIndex: -1, Field: path, Err: err})
+}
+
+func roundToInt(value float64) int {
+	return int(math.Round(value))
+}
@@ -0,0 +1,166 @@
+package ecs_test
+
+import (
+	"github.com/Banyango/io-engine/src/ecs"
+	"github.com/Banyango/io-engine/src/game"
+	"github.com/Banyango/io-engine/src/math"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+const tiledGameJson = `{
+	"prefabs": {
+		"block" : {
+			"id": "0",
+			"components": [
+				{"Type":"PositionComponent", "Position":[0,0] },
+				{"Type":"CollisionComponent", "Size":[1,1] },
+				{"Type":"CircleRendererComponent", "Size":[6,6], "Radius":4, "Color":"#001121" }
+			]
+		},
+		"decoration" : {
+			"id": "1",
+			"components": [
+				{"Type":"PositionComponent", "Position":[0,0] }
+			]
+		}
+	}
+}`
+
+// 3x2 map of 10x10 tiles, gid 1 is a plain tile and gid 2 is a block.
+const tiledMapJson = `{
+	"orientation": "orthogonal",
+	"width": 3, "height": 2, "tilewidth": 10, "tileheight": 10,
+	"tilesets": [
+		{ "firstgid": 1, "name": "tiles", "tiles": [ { "id": 1, "properties": [ { "name": "prefab", "type": "string", "value": "block" } ] } ] }
+	],
+	"layers": [
+		{ "name": "ground", "type": "tilelayer", "width": 3, "height": 2, "data": [1,0,0, 0,0,2147483650] },
+		{ "name": "art", "type": "tilelayer", "width": 3, "height": 2, "data": [1,1,1, 1,1,1],
+		  "properties": [ { "name": "collision", "type": "bool", "value": false } ] },
+		{ "name": "walls", "type": "group", "offsetx": 100, "offsety": 0, "layers": [
+			{ "name": "objects", "type": "objectgroup", "objects": [
+				{ "id": 1, "x": 0, "y": 0, "width": 40, "height": 20 },
+				{ "id": 2, "x": 10, "y": 30, "width": 10, "height": 10, "gid": 1,
+				  "properties": [ { "name": "prefab", "type": "string", "value": "block" } ] },
+				{ "id": 3, "x": 50, "y": 50, "point": true, "properties": [ { "name": "prefab", "type": "string", "value": "block" } ] }
+			] }
+		] }
+	]
+}`
+
+func createTiledPrefabs(t *testing.T) *ecs.PrefabData {
+	game.RegisterComponents()
+
+	world := ecs.NewWorld()
+	world.StrictLoading = true
+
+	prefabData, err := ecs.NewPrefabManager(tiledGameJson, world)
+	assert.NoError(t, err)
+
+	return prefabData
+}
+
+func position(entity ecs.Entity) math.VectorInt {
+	return entity.Components[int(ecs.PositionComponentType)].(*game.PositionComponent).Position
+}
+
+func collisionSize(entity ecs.Entity) math.VectorInt {
+	return entity.Components[int(ecs.CollisionComponentType)].(*game.CollisionComponent).Size
+}
+
+func TestPrefabData_LoadTiledMap(t *testing.T) {
+	prefabData := createTiledPrefabs(t)
+
+	entities, err := prefabData.LoadTiledMap("arena", []byte(tiledMapJson))
+
+	assert.NoError(t, err)
+	assert.Equal(t, 5, len(entities))
+
+	// plain tile, a bare collider.
+	assert.Equal(t, -1, entities[0].PrefabId)
+	assert.Equal(t, 2, len(entities[0].Components))
+	assert.Equal(t, math.NewVectorInt(5, 5), position(entities[0]))
+	assert.Equal(t, math.NewVectorInt(10, 10), collisionSize(entities[0]))
+
+	// flipped block tile, created from its prefab.
+	assert.Equal(t, 0, entities[1].PrefabId)
+	assert.Equal(t, 3, len(entities[1].Components))
+	assert.Equal(t, math.NewVectorInt(25, 15), position(entities[1]))
+	assert.Equal(t, math.NewVectorInt(10, 10), collisionSize(entities[1]))
+
+	// the art layer has no colliders, rectangles are offset by their group.
+	assert.Equal(t, -1, entities[2].PrefabId)
+	assert.Equal(t, math.NewVectorInt(120, 10), position(entities[2]))
+	assert.Equal(t, math.NewVectorInt(40, 20), collisionSize(entities[2]))
+
+	// tile objects are positioned by their bottom left corner.
+	assert.Equal(t, 0, entities[3].PrefabId)
+	assert.Equal(t, math.NewVectorInt(115, 25), position(entities[3]))
+
+	// points keep the prefab's size.
+	assert.Equal(t, math.NewVectorInt(150, 50), position(entities[4]))
+	assert.Equal(t, math.NewVectorInt(1, 1), collisionSize(entities[4]))
+}
+
+func TestPrefabData_LoadTiledMapErrors(t *testing.T) {
+	prefabData := createTiledPrefabs(t)
+
+	_, err := prefabData.LoadTiledMap("arena", []byte(`{
+		"tilewidth": 10, "tileheight": 10,
+		"tilesets": [ { "firstgid": 1, "source": "tiles.tsx" } ]
+	}`))
+
+	assert.EqualError(t, err, "1 problem(s) loading game data:\n"+
+		"  - scene \"arena\" entity 0 field \"tilesets.0\": external tileset \"tiles.tsx\" isn't supported, embed it in the map")
+
+	_, err = prefabData.LoadTiledMap("arena", []byte(`{
+		"tilewidth": 10, "tileheight": 10,
+		"layers": [
+			{ "name": "ground", "type": "tilelayer", "width": 1, "height": 1, "encoding": "base64", "data": "AQAAAA==" },
+			{ "name": "objects", "type": "objectgroup", "objects": [
+				{ "x": 0, "y": 0, "polygon": [ {"x":0, "y":0}, {"x":1, "y":1} ] },
+				{ "x": 0, "y": 0, "width": 10, "height": 10, "properties": [ { "name": "prefab", "type": "string", "value": "missing" } ] },
+				{ "x": 0, "y": 0, "width": 10, "height": 10, "properties": [ { "name": "prefab", "type": "string", "value": "decoration" } ] },
+				{ "x": 0, "y": 0 }
+			] }
+		]
+	}`))
+
+	assert.EqualError(t, err, "5 problem(s) loading game data:\n"+
+		"  - scene \"arena\" entity 0 field \"layers.ground\": base64 tile layers aren't supported, export them as CSV\n"+
+		"  - scene \"arena\" entity 0 field \"layers.objects.objects.0\": polygons aren't supported, use rectangles\n"+
+		"  - scene \"arena\" entity 0 field \"layers.objects.objects.1.prefab\": Prefab \"missing\" Doesn't exist\n"+
+		"  - scene \"arena\" entity 0 prefab \"decoration\" component 1 (CollisionComponent) field \"layers.objects.objects.2.Type\": prefab doesn't have this component\n"+
+		"  - scene \"arena\" entity 0 field \"layers.objects.objects.3\": colliders without a prefab need a size")
+}
+
+func TestWorld_LoadTiledMap(t *testing.T) {
+	world := ecs.NewWorld()
+	world.PrefabData = createTiledPrefabs(t)
+	world.IdIndex = 7
+
+	assert.NoError(t, world.LoadTiledMap("arena", []byte(tiledMapJson)))
+
+	assert.Equal(t, 5, len(world.Entities))
+	assert.Equal(t, math.NewVectorInt(10, 10), collisionSize(*world.Entities[7]))
+	assert.Equal(t, int64(12), world.IdIndex)
+}
+
+// The map isn't networked, a resync that resets the world keeps it.
+func TestWorld_ResetKeepsTheMap(t *testing.T) {
+	world := ecs.NewWorld()
+	world.PrefabData = createTiledPrefabs(t)
+
+	assert.NoError(t, world.LoadTiledMap("arena", []byte(tiledMapJson)))
+
+	world.Entities[0].Components[int(ecs.PositionComponentType)].(*game.PositionComponent).Position = math.NewVectorInt(-1, -1)
+	world.AddEntityToWorld(ecs.Entity{Id: world.FetchAndIncrementId(), PrefabId: 0, Components: map[int]ecs.Component{}})
+
+	world.Reset()
+
+	assert.Equal(t, 5, len(world.Entities))
+	assert.Equal(t, int64(5), world.IdIndex)
+	assert.Equal(t, math.NewVectorInt(5, 5), position(*world.Entities[0]))
+	assert.Equal(t, math.NewVectorInt(25, 15), position(*world.Entities[1]))
+}
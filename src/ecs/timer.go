@@ -0,0 +1,95 @@
+package ecs
+
+/**
+Timers
+
+Timers count down in ticks, not wall clock time, so they run the same on the server
+and while the client is resimulating. The scheduler is cached with every tick and
+restored by ResetToTick, so a rollback to before a timer fired will fire it again
+during Resimulate and a rollback to after it fired won't.
+
+Actions should only change world state and look up entities by id, entity pointers
+don't survive a rollback.
+*/
+
+type TimerId int64
+
+type Timer struct {
+	Id        TimerId
+	Remaining int64
+	Action    Command
+}
+
+type TimerScheduler struct {
+	NextId TimerId
+	Timers []Timer
+}
+
+func NewTimerScheduler() *TimerScheduler {
+	return &TimerScheduler{Timers: []Timer{}}
+}
+
+func (self *TimerScheduler) Clone() *TimerScheduler {
+	clone := NewTimerScheduler()
+	clone.NextId = self.NextId
+	clone.Timers = append(clone.Timers, self.Timers...)
+	return clone
+}
+
+// Schedules the action to run at the start of the update the given number of ticks from now.
+// Must only be called from the tick goroutine.
+func (w *World) After(ticks int64, action Command) TimerId {
+	if ticks < 1 {
+		ticks = 1
+	}
+
+	id := w.Timers.NextId
+	w.Timers.NextId++
+
+	w.Timers.Timers = append(w.Timers.Timers, Timer{Id: id, Remaining: ticks, Action: action})
+
+	return id
+}
+
+// returns false if the timer already fired or doesn't exist.
+func (w *World) CancelTimer(id TimerId) bool {
+	for i, timer := range w.Timers.Timers {
+		if timer.Id == id {
+			w.Timers.Timers = append(w.Timers.Timers[:i], w.Timers.Timers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// returns the ticks left before the timer fires. Used for cooldowns.
+func (w *World) TimerRemaining(id TimerId) (int64, bool) {
+	for _, timer := range w.Timers.Timers {
+		if timer.Id == id {
+			return timer.Remaining, true
+		}
+	}
+	return 0, false
+}
+
+func (w *World) updateTimers() {
+	var fired []Timer
+
+	pending := make([]Timer, 0, len(w.Timers.Timers))
+
+	for _, timer := range w.Timers.Timers {
+		timer.Remaining--
+		if timer.Remaining <= 0 {
+			fired = append(fired, timer)
+		} else {
+			pending = append(pending, timer)
+		}
+	}
+
+	w.Timers.Timers = pending
+
+	// timers fire in the order they were scheduled.
+	for _, timer := range fired {
+		timer.Action(w)
+	}
+}
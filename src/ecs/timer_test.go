@@ -0,0 +1,103 @@
+package ecs_test
+
+import (
+	"github.com/Banyango/io-engine/src/ecs"
+	"github.com/Banyango/io-engine/src/game"
+	"github.com/Banyango/io-engine/src/math"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func createTimerWorld() (*ecs.World, *game.PositionComponent) {
+	world := ecs.NewWorld()
+
+	entity := ecs.NewEntity()
+	entity.Id = world.FetchAndIncrementId()
+	entity.Components[int(ecs.PositionComponentType)] = &game.PositionComponent{Position: math.NewVectorInt(0, 0)}
+
+	world.AddEntityToWorld(entity)
+
+	return world, entity.Components[int(ecs.PositionComponentType)].(*game.PositionComponent)
+}
+
+func moveRight(entityId int64) ecs.Command {
+	return func(w *ecs.World) {
+		position := w.Entities[entityId].Components[int(ecs.PositionComponentType)].(*game.PositionComponent)
+		position.Position = position.Position.Add(math.NewVectorInt(1, 0))
+	}
+}
+
+func TestWorld_After(t *testing.T) {
+	world, position := createTimerWorld()
+
+	id := world.After(3, moveRight(0))
+
+	world.Update(0.016)
+	world.Update(0.016)
+
+	remaining, ok := world.TimerRemaining(id)
+	assert.True(t, ok)
+	assert.Equal(t, int64(1), remaining)
+	assert.Equal(t, 0, position.Position.X())
+
+	world.Update(0.016)
+
+	_, ok = world.TimerRemaining(id)
+	assert.False(t, ok)
+	assert.Equal(t, 1, position.Position.X())
+
+	world.Update(0.016)
+	assert.Equal(t, 1, position.Position.X())
+}
+
+func TestWorld_CancelTimer(t *testing.T) {
+	world, position := createTimerWorld()
+
+	id := world.After(2, moveRight(0))
+
+	world.Update(0.016)
+
+	assert.True(t, world.CancelTimer(id))
+	assert.False(t, world.CancelTimer(id))
+
+	world.Update(0.016)
+	world.Update(0.016)
+
+	assert.Equal(t, 0, position.Position.X())
+}
+
+func TestWorld_TimerResetBeforeFired(t *testing.T) {
+	world, position := createTimerWorld()
+
+	world.After(3, moveRight(0))
+
+	for i := 0; i < 5; i++ {
+		world.Update(0.016)
+	}
+
+	assert.Equal(t, 1, position.Position.X())
+
+	world.ResetToTick(1)
+
+	assert.Equal(t, 0, position.Position.X())
+
+	world.Resimulate(1)
+
+	assert.Equal(t, 1, position.Position.X())
+}
+
+func TestWorld_TimerResetAfterFired(t *testing.T) {
+	world, position := createTimerWorld()
+
+	world.After(3, moveRight(0))
+
+	for i := 0; i < 5; i++ {
+		world.Update(0.016)
+	}
+
+	world.ResetToTick(3)
+	world.Resimulate(3)
+
+	assert.Equal(t, 1, position.Position.X())
+	assert.Equal(t, 0, len(world.Timers.Timers))
+}
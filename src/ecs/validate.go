@@ -0,0 +1,427 @@
+package ecs
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+/**
+Validation
+
+ValidateGameData checks game data against GameDataSchema, then loads it strictly to check
+prefab references (extends, network views, the player prefab and duplicate ids). Every
+problem is returned as a LoadError with the line it was found on. Data in an older format
+is migrated first, its problems have no line since they're found in the migrated data.
+*/
+
+// Checks game data without running it. Components must be registered first.
+// Returns nil or LoadErrors.
+func ValidateGameData(data []byte) error {
+	root, err := parseJsonNode(data)
+
+	if err != nil {
+		return LoadErrors{err.(LoadError)}
+	}
+
+	migrated, ok, err := MigrateGameData(data)
+
+	if err != nil {
+		return LoadErrors{{ComponentIndex: -1, Field: "format", Err: err}}
+	}
+
+	if ok {
+		if err := ValidateGameData(migrated); err != nil {
+			problems := err.(LoadErrors)
+			for i := range problems {
+				problems[i].Line = 0
+			}
+			return problems
+		}
+		return nil
+	}
+
+	validator := schemaValidator{schema: GameDataSchema(), data: data, root: root}
+	validator.validate(validator.schema, root, nil)
+
+	// references are only checked once the structure is right, otherwise the loader
+	// would report the same problems again.
+	if len(validator.errs) == 0 {
+		world := NewWorld()
+		world.StrictLoading = true
+
+		_, err := NewPrefabManager(string(data), world)
+
+		if loadErrors, ok := err.(LoadErrors); ok {
+			for _, loadError := range loadErrors {
+				loadError.Line = validator.lineOf(loadErrorPath(loadError))
+				validator.errs = append(validator.errs, loadError)
+			}
+		} else if err != nil {
+			validator.errs = append(validator.errs, LoadError{ComponentIndex: -1, Err: err})
+		}
+	}
+
+	if len(validator.errs) == 0 {
+		return nil
+	}
+
+	return validator.errs
+}
+
+/**
+Json nodes keep the offset of every value so errors can point at a line.
+*/
+
+type jsonNode struct {
+	Value  interface{} // json.Number, string, bool or nil for scalars
+	Fields map[string]*jsonNode
+	Keys   []string // object keys in the order they were written
+	Items  []*jsonNode
+	Kind   string // object, array, string, number, boolean or null
+	Offset int64
+}
+
+func parseJsonNode(data []byte) (*jsonNode, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	node, err := decodeJsonNode(decoder)
+
+	if err != nil {
+		offset := decoder.InputOffset()
+
+		if syntaxError, ok := err.(*json.SyntaxError); ok {
+			offset = syntaxError.Offset
+		}
+
+		return nil, LoadError{ComponentIndex: -1, Line: lineAt(data, offset), Err: err}
+	}
+
+	return node, nil
+}
+
+func decodeJsonNode(decoder *json.Decoder) (*jsonNode, error) {
+	token, err := decoder.Token()
+
+	if err != nil {
+		return nil, err
+	}
+
+	node := &jsonNode{Offset: decoder.InputOffset()}
+
+	switch value := token.(type) {
+	case json.Delim:
+		if value == '{' {
+			node.Kind = "object"
+			node.Fields = map[string]*jsonNode{}
+
+			for decoder.More() {
+				key, err := decoder.Token()
+
+				if err != nil {
+					return nil, err
+				}
+
+				child, err := decodeJsonNode(decoder)
+
+				if err != nil {
+					return nil, err
+				}
+
+				node.Keys = append(node.Keys, key.(string))
+				node.Fields[key.(string)] = child
+			}
+		} else {
+			node.Kind = "array"
+
+			for decoder.More() {
+				child, err := decodeJsonNode(decoder)
+
+				if err != nil {
+					return nil, err
+				}
+
+				node.Items = append(node.Items, child)
+			}
+		}
+
+		// closing delimiter
+		if _, err := decoder.Token(); err != nil {
+			return nil, err
+		}
+	case json.Number:
+		node.Kind = "number"
+		node.Value = value
+	case string:
+		node.Kind = "string"
+		node.Value = value
+	case bool:
+		node.Kind = "boolean"
+		node.Value = value
+	default:
+		node.Kind = "null"
+	}
+
+	return node, nil
+}
+
+func (self *jsonNode) isInteger() bool {
+	if number, ok := self.Value.(json.Number); ok {
+		_, err := strconv.ParseInt(string(number), 10, 64)
+		return err == nil
+	}
+	return false
+}
+
+func (self *jsonNode) equals(value interface{}) bool {
+	switch expected := value.(type) {
+	case string:
+		return self.Value == expected
+	case bool:
+		return self.Value == expected
+	case nil:
+		return self.Kind == "null"
+	case float64:
+		number, ok := self.Value.(json.Number)
+		if !ok {
+			return false
+		}
+		actual, err := number.Float64()
+		return err == nil && actual == expected
+	}
+	return false
+}
+
+func lineAt(data []byte, offset int64) int {
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	return bytes.Count(data[:offset], []byte("\n")) + 1
+}
+
+/**
+Schema validation
+
+Only the parts of JSON Schema that GameDataSchema generates are supported.
+*/
+
+type schemaValidator struct {
+	schema *JsonSchema
+	data   []byte
+	root   *jsonNode
+	errs   LoadErrors
+}
+
+func (self *schemaValidator) validate(schema *JsonSchema, node *jsonNode, path []string) {
+	if schema.Ref != "" {
+		definition, ok := self.schema.Definitions[strings.TrimPrefix(schema.Ref, "#/definitions/")]
+
+		if !ok {
+			self.report(path, node, fmt.Errorf("schema reference %q doesn't exist", schema.Ref))
+			return
+		}
+
+		self.validate(definition, node, path)
+		return
+	}
+
+	if types := schemaTypes(schema.Type); len(types) > 0 && !nodeMatchesTypes(node, types) {
+		self.report(path, node, fmt.Errorf("expected %s, got %s", strings.Join(types, " or "), node.Kind))
+		return
+	}
+
+	if schema.Const != nil && !node.equals(schema.Const) {
+		self.report(path, node, fmt.Errorf("must be %v", schema.Const))
+	}
+
+	if len(schema.Enum) > 0 {
+		found := false
+		for _, value := range schema.Enum {
+			found = found || node.equals(value)
+		}
+
+		if !found {
+			self.report(path, node, fmt.Errorf("unknown value %v", node.Value))
+		}
+	}
+
+	if value, ok := node.Value.(string); ok && schema.Pattern != "" {
+		if matched, _ := regexp.MatchString(schema.Pattern, value); !matched {
+			self.report(path, node, fmt.Errorf("%q doesn't match %s", value, schema.Pattern))
+		}
+	}
+
+	if node.Kind == "object" {
+		for _, required := range schema.Required {
+			if _, ok := node.Fields[required]; !ok {
+				self.report(append(append([]string{}, path...), required), node, fmt.Errorf("missing required field"))
+			}
+		}
+
+		for _, key := range node.Keys {
+			child := node.Fields[key]
+			childPath := append(append([]string{}, path...), key)
+
+			if property, ok := schema.Properties[key]; ok {
+				self.validate(property, child, childPath)
+				continue
+			}
+
+			switch additional := schema.AdditionalProperties.(type) {
+			case bool:
+				if !additional {
+					self.report(childPath, child, fmt.Errorf("unknown field"))
+				}
+			case *JsonSchema:
+				self.validate(additional, child, childPath)
+			}
+		}
+	}
+
+	if node.Kind == "array" {
+		if schema.MinItems != nil && schema.MaxItems != nil && *schema.MinItems == *schema.MaxItems && len(node.Items) != *schema.MinItems {
+			self.report(path, node, fmt.Errorf("expected %d items, got %d", *schema.MinItems, len(node.Items)))
+		} else if schema.MinItems != nil && len(node.Items) < *schema.MinItems {
+			self.report(path, node, fmt.Errorf("expected at least %d items, got %d", *schema.MinItems, len(node.Items)))
+		} else if schema.MaxItems != nil && len(node.Items) > *schema.MaxItems {
+			self.report(path, node, fmt.Errorf("expected at most %d items, got %d", *schema.MaxItems, len(node.Items)))
+		}
+
+		if schema.Items != nil {
+			for i, item := range node.Items {
+				self.validate(schema.Items, item, append(append([]string{}, path...), strconv.Itoa(i)))
+			}
+		}
+	}
+
+	for _, subSchema := range schema.AllOf {
+		self.validate(subSchema, node, path)
+	}
+
+	if schema.If != nil && schema.Then != nil {
+		check := schemaValidator{schema: self.schema, data: self.data, root: self.root}
+		check.validate(schema.If, node, path)
+
+		if len(check.errs) == 0 {
+			self.validate(schema.Then, node, path)
+		}
+	}
+}
+
+// Turns a path like prefabs/Player/components/1/Size into a LoadError naming the prefab and component.
+func (self *schemaValidator) report(path []string, node *jsonNode, err error) {
+	loadError := LoadError{ComponentIndex: -1, Line: lineAt(self.data, node.Offset), Err: err}
+
+	if len(path) >= 2 && path[0] == "prefabs" {
+		loadError.Prefab = path[1]
+		rest := path[2:]
+
+		if len(rest) >= 2 && rest[0] == "components" {
+			if index, err := strconv.Atoi(rest[1]); err == nil {
+				loadError.ComponentIndex = index
+
+				if component := self.find(path[:4]); component != nil && component.Fields["Type"] != nil {
+					if typeName, ok := component.Fields["Type"].Value.(string); ok {
+						loadError.ComponentType = typeName
+					}
+				}
+
+				rest = rest[2:]
+			}
+		}
+
+		loadError.Field = strings.Join(rest, ".")
+	} else {
+		loadError.Field = strings.Join(path, ".")
+	}
+
+	self.errs = append(self.errs, loadError)
+}
+
+// Finds the node at the path, nil if it doesn't exist.
+func (self *schemaValidator) find(path []string) *jsonNode {
+	node := self.root
+
+	for _, key := range path {
+		if node == nil {
+			return nil
+		}
+
+		switch node.Kind {
+		case "object":
+			node = node.Fields[key]
+		case "array":
+			index, err := strconv.Atoi(key)
+			if err != nil || index < 0 || index >= len(node.Items) {
+				return nil
+			}
+			node = node.Items[index]
+		default:
+			return nil
+		}
+	}
+
+	return node
+}
+
+// Line of the deepest node on the path that exists.
+func (self *schemaValidator) lineOf(path []string) int {
+	for i := len(path); i >= 0; i-- {
+		if node := self.find(path[:i]); node != nil {
+			return lineAt(self.data, node.Offset)
+		}
+	}
+	return 0
+}
+
+func loadErrorPath(loadError LoadError) []string {
+	if loadError.Prefab == "" {
+		if loadError.Field == "" {
+			return nil
+		}
+		return strings.Split(loadError.Field, ".")
+	}
+
+	path := []string{"prefabs", loadError.Prefab}
+
+	if loadError.ComponentIndex >= 0 {
+		path = append(path, "components", strconv.Itoa(loadError.ComponentIndex))
+	}
+
+	if loadError.Field != "" {
+		path = append(path, loadError.Field)
+	}
+
+	return path
+}
+
+func schemaTypes(value interface{}) []string {
+	switch types := value.(type) {
+	case string:
+		return []string{types}
+	case []string:
+		return types
+	case []interface{}:
+		result := []string{}
+		for _, t := range types {
+			if name, ok := t.(string); ok {
+				result = append(result, name)
+			}
+		}
+		return result
+	}
+	return nil
+}
+
+func nodeMatchesTypes(node *jsonNode, types []string) bool {
+	for _, t := range types {
+		if t == node.Kind || (t == "integer" && node.isInteger()) {
+			return true
+		}
+	}
+	return false
+}
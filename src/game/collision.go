@@ -2,7 +2,6 @@ package game
 
 import (
 	"github.com/SolarLune/resolv/resolv"
-	"github.com/goburrow/dynamic"
 	. "github.com/Banyango/io-engine/src/ecs"
 	"github.com/Banyango/io-engine/src/math"
 	"github.com/Banyango/io-engine/src/server"
@@ -22,13 +21,7 @@ type CollisionSystem struct {
 }
 
 func (self *CollisionSystem) Init(w *World) {
-	dynamic.Register("PositionComponent", func() interface{} {
-		return &PositionComponent{}
-	})
-
-	dynamic.Register("CollisionComponent", func() interface{} {
-		return &CollisionComponent{}
-	})
+	RegisterComponents()
 
 	self.positionComponents = NewStorage()
 	self.collisionComponents = NewStorage()
@@ -131,26 +124,21 @@ func (self *PositionComponent) Reset(component Component) {
 }
 
 func (self *PositionComponent) ReadUDP(networkPacket *server.NetworkData) {
-	var data struct {
-		X int
-		Y int
-	}
-
-	server.DecodeNetworkDataBytes(networkPacket, self.Id(), &data)
+	var x, y int64
 
-	self.Position = math.NewVectorInt(data.X, data.Y)
+	if networkPacket.ReadComponent(self.Id(), func(reader *server.NetworkReader) {
+		x = reader.Int()
+		y = reader.Int()
+	}) {
+		self.Position = math.NewVectorInt(int(x), int(y))
+	}
 }
 
 func (self *PositionComponent) WriteUDP(networkPacket *server.NetworkData) {
-	var data struct {
-		X int
-		Y int
-	}
-
-	data.X = self.Position.X()
-	data.Y = self.Position.Y()
-
-	server.EncodeNetworkDataBytes(networkPacket, self.Id(), data)
+	networkPacket.WriteComponent(self.Id(), func(writer *server.NetworkWriter) {
+		writer.Int(int64(self.Position.X()))
+		writer.Int(int64(self.Position.Y()))
+	})
 }
 
 func (self *PositionComponent) Id() int {
@@ -238,10 +226,9 @@ func (c *CollisionComponent) Id() int {
 	return int(CollisionComponentType);
 }
 
+// the size and velocity are kept, they come from game data, the map or prefab overrides.
 func (c *CollisionComponent) CreateComponent() {
-	c.Size = math.NewVectorInt(0, 0)
 	c.Remaining = math.NewVector(0, 0)
-	c.Velocity = math.NewVector(0, 0)
 	c.entitiesCollidingWith = []int64{}
 	c.shape = resolv.NewRectangle(int32(0), int32(0), int32(c.Size.X()), int32(c.Size.Y()))
 }
@@ -261,36 +248,30 @@ func (c *CollisionComponent) Extents(position math.VectorInt) (math.VectorInt, m
 	return c.Min(position), c.Max(position)
 }
 
+// Velocity and the sub pixel remainder are sent in 1/256ths of a pixel.
+const COLLISION_NETWORK_PRECISION = 1.0 / 256
+
 func (self *CollisionComponent) ReadUDP(networkPacket *server.NetworkData) {
-	var data struct {
-		VelX       float32
-		VelY       float32
-		RemainingX float32
-		RemainingY float32
-		//Collision byte
+	var velX, velY, remainingX, remainingY float64
+
+	if networkPacket.ReadComponent(self.Id(), func(reader *server.NetworkReader) {
+		velX = reader.Float(COLLISION_NETWORK_PRECISION)
+		velY = reader.Float(COLLISION_NETWORK_PRECISION)
+		remainingX = reader.Float(COLLISION_NETWORK_PRECISION)
+		remainingY = reader.Float(COLLISION_NETWORK_PRECISION)
+	}) {
+		self.Velocity.Set(velX, velY)
+		self.Remaining.Set(remainingX, remainingY)
 	}
-
-	server.DecodeNetworkDataBytes(networkPacket, self.Id(), &data)
-
-	self.Velocity.Set(float64(data.VelX), float64(data.VelY))
-	self.Remaining.Set(float64(data.RemainingX), float64(data.RemainingY))
 }
 
 func (self *CollisionComponent) WriteUDP(networkPacket *server.NetworkData) {
-	var data struct {
-		VelX       float32
-		VelY       float32
-		RemainingX float32
-		RemainingY float32
-		//Collision byte
-	}
-
-	data.VelX = float32(self.Velocity.X())
-	data.VelY = float32(self.Velocity.Y())
-	data.RemainingX = float32(self.Remaining.X())
-	data.RemainingY = float32(self.Remaining.Y())
-
-	server.EncodeNetworkDataBytes(networkPacket, self.Id(), data)
+	networkPacket.WriteComponent(self.Id(), func(writer *server.NetworkWriter) {
+		writer.Float(self.Velocity.X(), COLLISION_NETWORK_PRECISION)
+		writer.Float(self.Velocity.Y(), COLLISION_NETWORK_PRECISION)
+		writer.Float(self.Remaining.X(), COLLISION_NETWORK_PRECISION)
+		writer.Float(self.Remaining.Y(), COLLISION_NETWORK_PRECISION)
+	})
 }
 
 func (self *CollisionComponent) AreEquals(component Component) bool {
@@ -0,0 +1,81 @@
+package game_test
+
+import (
+	"bytes"
+	"encoding/gob"
+	"github.com/Banyango/io-engine/src/game"
+	"github.com/Banyango/io-engine/src/math"
+	"github.com/Banyango/io-engine/src/server"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestPositionAndCollision_UDP(t *testing.T) {
+	position := game.PositionComponent{Position: math.NewVectorInt(-120, 4000)}
+	collision := game.CollisionComponent{Velocity: math.NewVector(2.5, -0.3), Remaining: math.NewVector(0.1, 0)}
+
+	data := server.NetworkData{NetworkId: 12, OwnerId: 1, Data: map[int][]byte{}}
+	position.WriteUDP(&data)
+	collision.WriteUDP(&data)
+
+	var receivedPosition game.PositionComponent
+	var receivedCollision game.CollisionComponent
+	receivedPosition.ReadUDP(&data)
+	receivedCollision.ReadUDP(&data)
+
+	assert.Equal(t, position.Position, receivedPosition.Position)
+	assert.InDelta(t, 2.5, receivedCollision.Velocity.X(), game.COLLISION_NETWORK_PRECISION)
+	assert.InDelta(t, -0.3, receivedCollision.Velocity.Y(), game.COLLISION_NETWORK_PRECISION)
+	assert.InDelta(t, 0.1, receivedCollision.Remaining.X(), game.COLLISION_NETWORK_PRECISION)
+	assert.InDelta(t, 0, receivedCollision.Remaining.Y(), game.COLLISION_NETWORK_PRECISION)
+
+	// data of another entity is left alone when it's missing.
+	empty := server.NetworkData{Data: map[int][]byte{}}
+	receivedPosition.ReadUDP(&empty)
+	assert.Equal(t, position.Position, receivedPosition.Position)
+}
+
+// An update of a moving entity with a position and collision, as it was sent with gob and as it's sent now.
+func TestPositionAndCollision_BytesPerEntity(t *testing.T) {
+	position := game.PositionComponent{Position: math.NewVectorInt(312, 95)}
+	collision := game.CollisionComponent{Velocity: math.NewVector(1.75, -3.2), Remaining: math.NewVector(0.4, 0.6)}
+
+	data := server.NetworkData{NetworkId: 40, OwnerId: 2, Data: map[int][]byte{}}
+	position.WriteUDP(&data)
+	collision.WriteUDP(&data)
+
+	encoded, err := data.MarshalBinary()
+	assert.NoError(t, err)
+
+	// NetworkData marshals itself now, this is its fields as gob sent them.
+	type gobNetworkData struct {
+		OwnerId         uint16
+		NetworkId       uint16
+		NetworkPrefabId uint16
+		Data            map[int][]byte
+		Overrides       []byte
+	}
+
+	gobData := gobNetworkData{NetworkId: 40, OwnerId: 2, Data: map[int][]byte{
+		position.Id():  gobBytes(t, struct{ X, Y int }{312, 95}),
+		collision.Id(): gobBytes(t, struct{ VelX, VelY, RemainingX, RemainingY float32 }{1.75, -3.2, 0.4, 0.6}),
+	}}
+
+	// a stream sending many states only describes the types once, the second encode is what each one costs.
+	var stream bytes.Buffer
+	encoder := gob.NewEncoder(&stream)
+	assert.NoError(t, encoder.Encode(gobData))
+	first := stream.Len()
+	assert.NoError(t, encoder.Encode(gobData))
+	gobSize := stream.Len() - first
+
+	// the numbers in the server codec doc.
+	assert.Equal(t, 21, len(encoded))
+	assert.Equal(t, 140, gobSize)
+}
+
+func gobBytes(t *testing.T, value interface{}) []byte {
+	var buffer bytes.Buffer
+	assert.NoError(t, gob.NewEncoder(&buffer).Encode(value))
+	return buffer.Bytes()
+}
@@ -0,0 +1,26 @@
+package game
+
+import (
+	. "github.com/Banyango/io-engine/src/ecs"
+)
+
+// Registers every component in this package with the json loader.
+// Systems call this in Init, the server and tools call it before loading game data
+// because they don't add every system (the server never renders).
+func RegisterComponents() {
+	RegisterComponent("PositionComponent", func() interface{} {
+		return &PositionComponent{}
+	})
+
+	RegisterComponent("CollisionComponent", func() interface{} {
+		return &CollisionComponent{}
+	})
+
+	RegisterComponent("ArcadeMovementComponent", func() interface{} {
+		return &ArcadeMovementComponent{}
+	})
+
+	RegisterComponent("CircleRendererComponent", func() interface{} {
+		return &CircleRendererComponent{}
+	})
+}
@@ -1,7 +1,6 @@
 package game
 
 import (
-	"github.com/goburrow/dynamic"
 	. "github.com/Banyango/io-engine/src/ecs"
 	"github.com/Banyango/io-engine/src/math"
 	"github.com/Banyango/io-engine/src/server"
@@ -15,9 +14,7 @@ type KeyboardMovementSystem struct {
 
 func (self *KeyboardMovementSystem) Init(w *World) {
 
-	dynamic.Register("ArcadeMovementComponent", func() interface{} {
-		return &ArcadeMovementComponent{}
-	})
+	RegisterComponents()
 
 	self.collisionComponents = NewStorage()
 	self.arcadeComponents = NewStorage()
@@ -63,33 +60,42 @@ func (self *KeyboardMovementSystem) UpdateSystem(delta float64, world *World) {
 		collider := (*self.collisionComponents.Components[entity]).(*CollisionComponent)
 		net := (*self.networkInstance.Components[entity]).(*server.NetworkInstanceComponent)
 
-		direction := math.NewVector(float64(0), float64(0))
+		ApplyMovement(world.InputForPlayer(net.OwnerId), arcade, collider)
+	}
+}
 
-		if input, ok := world.Input.Player[net.OwnerId]; ok {
-			if input.AnyKeyPressed() {
+// Accelerates the collider towards the held move actions, or the move_x and move_y axes when none are
+// held, then applies gravity, drag and the max speed. input is nil for a player nothing is known about.
+func ApplyMovement(input *Input, arcade *ArcadeMovementComponent, collider *CollisionComponent) {
+	direction := math.NewVector(float64(0), float64(0))
 
-				if input.KeyPressed[Up] {
-					direction = direction.Add(math.VectorUp())
-				}
+	if input != nil {
 
-				if input.KeyPressed[Down] {
-					direction = direction.Add(math.VectorDown())
-				}
+		if input.Action("move_up") {
+			direction = direction.Add(math.VectorUp())
+		}
+
+		if input.Action("move_down") {
+			direction = direction.Add(math.VectorDown())
+		}
 
-				if input.KeyPressed[Left] {
-					direction = direction.Add(math.VectorRight())
-				}
+		if input.Action("move_right") {
+			direction = direction.Add(math.VectorRight())
+		}
 
-				if input.KeyPressed[Right] {
-					direction = direction.Add(math.VectorLeft())
-				}
+		if input.Action("move_left") {
+			direction = direction.Add(math.VectorLeft())
+		}
 
-				collider.Velocity = collider.Velocity.Add(direction.Scale(arcade.Speed))
-			}
+		// sticks only move with axes declared in game data, see the input section of game.json.
+		if direction.X() == 0 && direction.Y() == 0 {
+			direction = math.NewVector(input.Axis("move_x"), input.Axis("move_y"))
 		}
 
-		collider.Velocity = collider.Velocity.Add(arcade.Gravity).Scale(arcade.Drag).Clamp(arcade.MaxSpeed.Neg(), arcade.MaxSpeed)
+		collider.Velocity = collider.Velocity.Add(direction.Scale(arcade.Speed))
 	}
+
+	collider.Velocity = collider.Velocity.Add(arcade.Gravity).Scale(arcade.Drag).Clamp(arcade.MaxSpeed.Neg(), arcade.MaxSpeed)
 }
 
 type ArcadeMovementComponent struct {
@@ -128,6 +134,11 @@ func (self *ArcadeMovementComponent) Reset(component Component) {
 	}
 }
 
+// every field of the component is tuned in game data.
+func (self *ArcadeMovementComponent) ApplyTuning(prefab Component) {
+	self.Reset(prefab)
+}
+
 func (self *ArcadeMovementComponent) Clone() Component {
 	component := new(ArcadeMovementComponent)
 	component.Speed = self.Speed
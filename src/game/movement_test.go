@@ -0,0 +1,104 @@
+package game_test
+
+import (
+	"github.com/Banyango/io-engine/src/ecs"
+	"github.com/Banyango/io-engine/src/game"
+	"github.com/Banyango/io-engine/src/math"
+	"github.com/Banyango/io-engine/src/server"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"testing"
+)
+
+// A peer on a client moves with its remote input, resimulating replays the input the server confirmed.
+func TestKeyboardMovementSystem_MovesPeersWithConfirmedInput(t *testing.T) {
+	game.RegisterComponents()
+
+	world := ecs.NewWorld()
+	world.AddSystem(new(game.KeyboardMovementSystem))
+	world.AddSystem(new(game.CollisionSystem))
+
+	prefabData, err := ecs.NewPrefabManager(`{
+		"input": {
+			"actions": [
+				{ "name": "move_right", "keys": ["D"] }
+			]
+		},
+		"prefabs": {
+			"peer": {
+				"id": "0",
+				"components": [
+					{"Type":"PositionComponent", "Position":[0,0] },
+					{"Type":"CollisionComponent", "Size":[2,2] },
+					{"Type":"ArcadeMovementComponent", "MaxSpeed":[200,200], "Speed":400, "Drag":0.8, "Gravity":[0,0] }
+				]
+			}
+		}
+	}`, world)
+	assert.NoError(t, err)
+
+	world.PrefabData = prefabData
+
+	peer, err := prefabData.CreatePrefab(0)
+	assert.NoError(t, err)
+
+	peer.Id = world.FetchAndIncrementId()
+	peer.Components[int(ecs.NetworkInstanceComponentType)] = &server.NetworkInstanceComponent{OwnerId: 5}
+	world.AddEntityToWorld(peer)
+
+	position := world.Entities[peer.Id].Components[int(ecs.PositionComponentType)].(*game.PositionComponent)
+
+	world.ConfirmRemoteInput(5, 2, ecs.NetworkInput{Buttons: []byte{0x0}})
+	world.ConfirmRemoteInput(5, 3, ecs.NetworkInput{Buttons: []byte{0x1}})
+
+	// held from tick 3, predicted to be held after it.
+	for i := 0; i < 5; i++ {
+		world.Update(ecs.FIXED_DELTA)
+	}
+
+	assert.True(t, position.Position.X() > 0)
+
+	// the server says the peer let go on tick 3, it never moved.
+	assert.True(t, world.ConfirmRemoteInput(5, 3, ecs.NetworkInput{Buttons: []byte{0x0}}))
+
+	// ResetToTick restores the state after tick 2.
+	world.ResetToTick(1)
+	world.Resimulate(1)
+
+	assert.Equal(t, int64(5), world.CurrentTick)
+	assert.Equal(t, 0, position.Position.X())
+}
+
+// The left stick moves the player through the move_x and move_y axes game.json declares on it.
+func TestApplyMovement_Stick(t *testing.T) {
+	gameJson, err := ioutil.ReadFile("../../game.json")
+	assert.NoError(t, err)
+
+	game.RegisterComponents()
+
+	prefabData, err := ecs.NewPrefabManager(string(gameJson), ecs.NewWorld())
+	assert.NoError(t, err)
+
+	input := ecs.NewInput()
+	input.SetGamepads([]ecs.GamepadState{{Axes: []float64{0.5, -1}}})
+	prefabData.InputMap.Resolve(input)
+
+	// the stick goes over the network like any other axis.
+	prefabData.InputMap.Decode(prefabData.InputMap.Encode(input), input)
+
+	arcade := &game.ArcadeMovementComponent{Speed: 100, Drag: 1, MaxSpeed: math.NewVector(200, 200)}
+	collider := &game.CollisionComponent{}
+
+	game.ApplyMovement(input, arcade, collider)
+
+	assert.InDelta(t, 50, collider.Velocity.X(), 0.5)
+	assert.InDelta(t, -100, collider.Velocity.Y(), 0.5)
+
+	// the move actions win over the stick.
+	input.Actions["move_right"] = true
+	collider.Velocity = math.VectorZero()
+
+	game.ApplyMovement(input, arcade, collider)
+
+	assert.Equal(t, math.NewVector(100, 0), collider.Velocity)
+}
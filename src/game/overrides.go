@@ -0,0 +1,42 @@
+package game
+
+import (
+	. "github.com/Banyango/io-engine/src/ecs"
+	"github.com/Banyango/io-engine/src/math"
+)
+
+/*
+----------------------------------------------------------------------------------------------------------------
+Prefab overrides
+
+Typed overrides for CreatePrefab, e.g. world.PrefabData.CreatePrefab(id, game.WithPosition(position))
+----------------------------------------------------------------------------------------------------------------
+*/
+
+func WithPosition(position math.VectorInt) PrefabOverride {
+	return PrefabOverrideFunc(func(entity *Entity) error {
+		component, err := OverrideTarget(entity, new(PositionComponent))
+
+		if err != nil {
+			return err
+		}
+
+		component.(*PositionComponent).Position = position
+
+		return nil
+	})
+}
+
+func WithVelocity(velocity math.Vector) PrefabOverride {
+	return PrefabOverrideFunc(func(entity *Entity) error {
+		component, err := OverrideTarget(entity, new(CollisionComponent))
+
+		if err != nil {
+			return err
+		}
+
+		component.(*CollisionComponent).Velocity = velocity
+
+		return nil
+	})
+}
@@ -0,0 +1,112 @@
+package game
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	. "github.com/Banyango/io-engine/src/ecs"
+	"github.com/Banyango/io-engine/src/math"
+	. "github.com/lucasb-eyer/go-colorful"
+	"reflect"
+)
+
+/*
+----------------------------------------------------------------------------------------------------------------
+Circle Renderer Component
+
+Only drawn by the web client, but it lives here so the server can load the same prefabs.
+----------------------------------------------------------------------------------------------------------------
+*/
+
+type CircleRendererComponent struct {
+	Size   math.Vector
+	Color  MyHexColor
+	Radius float32
+}
+
+func (self *CircleRendererComponent) Id() int {
+	return int(CircleComponentType)
+}
+
+func (self *CircleRendererComponent) CreateComponent() {
+
+}
+
+func (self *CircleRendererComponent) DestroyComponent() {
+
+}
+
+func (self *CircleRendererComponent) Clone() Component {
+	component := new(CircleRendererComponent)
+	component.Size = self.Size
+	component.Color = self.Color
+	component.Radius = self.Radius
+	return component
+}
+
+func (self *CircleRendererComponent) Reset(component Component) {
+
+}
+
+func (self *CircleRendererComponent) ApplyTuning(prefab Component) {
+	if val, ok := prefab.(*CircleRendererComponent); ok {
+		self.Size = val.Size
+		self.Color = val.Color
+		self.Radius = val.Radius
+	}
+}
+
+type MyHexColor Color
+
+type errUnsupportedType struct {
+	got  interface{}
+	want reflect.Type
+}
+
+func (hc *MyHexColor) Scan(value interface{}) error {
+	s, ok := value.(string)
+	if !ok {
+		return errUnsupportedType{got: reflect.TypeOf(value), want: reflect.TypeOf("")}
+	}
+	c, err := Hex(s)
+	if err != nil {
+		return err
+	}
+	*hc = MyHexColor(c)
+	return nil
+}
+
+func (hc *MyHexColor) Value() (driver.Value, error) {
+	return Color(*hc).Hex(), nil
+}
+
+func (e errUnsupportedType) Error() string {
+	return fmt.Sprintf("unsupported type: got %v, want a %s", e.got, e.want)
+}
+
+// Colors are written as "#rgb" or "#rrggbb" in game data.
+func (self *MyHexColor) JsonSchema() map[string]interface{} {
+	return map[string]interface{}{"type": "string", "pattern": "^#([0-9a-fA-F]{3}|[0-9a-fA-F]{6})$"}
+}
+
+func (self *MyHexColor) UnmarshalJSON(bytes []byte) error {
+	data := ""
+
+	err := json.Unmarshal(bytes, &data)
+
+	if err != nil {
+		return err
+	}
+
+	color, err := Hex(data)
+
+	if err != nil {
+		return err
+	}
+
+	self.R = color.R
+	self.G = color.G
+	self.B = color.B
+
+	return nil
+}
@@ -1,9 +1,9 @@
-package web_test
+package game_test
 
 import (
 	json2 "encoding/json"
 	"github.com/stretchr/testify/assert"
-	"github.com/Banyango/io-engine/src/client"
+	"github.com/Banyango/io-engine/src/game"
 	"testing"
 )
 
@@ -11,7 +11,7 @@ func TestParseCircleRendererComponent(t *testing.T) {
 
 	json := `{"Type":"CircleRendererComponent", "Size":[2,2], "Radius":4.3, "Color":"#001121" }`
 
-	var comp client.CircleRendererComponent
+	var comp game.CircleRendererComponent
 
 	err := json2.Unmarshal([]byte(json), &comp)
 
@@ -0,0 +1,88 @@
+package game_test
+
+import (
+	"github.com/Banyango/io-engine/src/ecs"
+	"github.com/Banyango/io-engine/src/game"
+	"github.com/Banyango/io-engine/src/server"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+// Scene entities are created before anyone connects, a client that joins later still gets their overrides.
+func TestServer_LateJoinerGetsSceneOverrides(t *testing.T) {
+	gameJson, err := ioutil.ReadFile("../../game.json")
+	assert.NoError(t, err)
+
+	game.RegisterComponents()
+
+	world := ecs.NewWorld()
+	world.Input.Player = map[ecs.PlayerId]*ecs.Input{}
+
+	gameServer := &server.Server{World: world, DataDir: "../.."}
+
+	world.AddSystem(new(server.NetworkInputFutureCollectionSystem))
+	world.AddSystem(new(game.SpawnSystem))
+	world.AddSystem(server.NewNetworkInstanceDataCollectionSystem(gameServer))
+
+	prefabData, err := ecs.NewPrefabManager(string(gameJson), world)
+	assert.NoError(t, err)
+
+	world.PrefabData = prefabData
+	gameServer.SetDataVersion(prefabData.Version)
+
+	assert.NoError(t, gameServer.LoadScene(prefabData.Scene))
+
+	tick := func() {
+		gameServer.HandleIncomingData(ecs.FIXED_DELTA)
+		world.Update(ecs.FIXED_DELTA)
+		gameServer.SendNetworkData(ecs.FIXED_DELTA)
+	}
+
+	for i := 0; i < 10; i++ {
+		tick()
+	}
+
+	transport := server.NewMemoryTransport()
+	defer transport.Close()
+
+	go gameServer.Listen(transport)
+
+	client, err := transport.Dial()
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer client.Close()
+
+	var state server.WorldState
+
+	for deadline := time.Now().Add(time.Second); len(state.Updates) == 0 && time.Now().Before(deadline); {
+		tick()
+
+		select {
+		case message := <-client.Receive():
+			if !message.Reliable {
+				var packet server.ClientWorldStatePacket
+				assert.NoError(t, packet.UnmarshalBinary(message.Data))
+				assert.NoError(t, state.UnmarshalBinary(packet.State))
+			}
+		default:
+		}
+	}
+
+	assert.Empty(t, state.Created)
+	assert.Equal(t, 3, len(state.Updates))
+
+	clientWorld := ecs.NewWorld()
+	clientWorld.PrefabData = prefabData
+
+	radius := map[float32]int{}
+	for _, update := range state.Updates {
+		entity := update.DeserializeNewEntity(clientWorld, true)
+		radius[entity.Components[int(ecs.CircleComponentType)].(*game.CircleRendererComponent).Radius]++
+	}
+
+	// the third wall of the arena is bigger.
+	assert.Equal(t, map[float32]int{16: 2, 24: 1}, radius)
+}
@@ -90,6 +90,11 @@ func (self *Vector) UnmarshalJSON(b []byte) error {
 	return nil
 }
 
+// Vectors are written as [x,y] in game data.
+func (self *Vector) JsonSchema() map[string]interface{} {
+	return map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "number"}, "minItems": 2, "maxItems": 2}
+}
+
 func (self *Vector) Set(x float64, y float64) {
 	self.position[0] = x
 	self.position[1] = y
@@ -298,6 +303,11 @@ func (self *VectorInt) MarshalJSON() ([]byte, error) {
 	return bytes, nil
 }
 
+// Vectors are written as [x,y] in game data.
+func (self *VectorInt) JsonSchema() map[string]interface{} {
+	return map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "integer"}, "minItems": 2, "maxItems": 2}
+}
+
 func (self *VectorInt) UnmarshalJSON(b []byte) error {
 	data := new([2]int)
 
@@ -0,0 +1,193 @@
+package server
+
+import (
+	. "github.com/Banyango/io-engine/src/ecs"
+	"github.com/stretchr/testify/assert"
+	"math"
+	"testing"
+)
+
+func TestNetworkWriter_RoundTrip(t *testing.T) {
+	writer := NetworkWriter{}
+
+	writer.Uint(300)
+	writer.Int(-2)
+	writer.Float(1.3, 1.0/256)
+	writer.Float(math.NaN(), 1)
+	writer.Float(math.Inf(-1), 1)
+	writer.Bools(true, false, false, false, false, false, false, false, true)
+	writer.Uint64(math.MaxUint64)
+	writer.Bytes([]byte{1, 2, 3})
+	writer.Bytes(nil)
+	writer.Uint(1 << 16)
+
+	reader := NewNetworkReader(writer.Data())
+
+	assert.Equal(t, uint64(300), reader.Uint())
+	assert.Equal(t, int64(-2), reader.Int())
+	assert.InDelta(t, 1.3, reader.Float(1.0/256), 1.0/512)
+	assert.Equal(t, 0.0, reader.Float(1))
+	assert.True(t, reader.Float(1) < -1e18)
+
+	var first, second, ninth bool
+	unused := false
+	reader.Bools(&first, &second, &unused, &unused, &unused, &unused, &unused, &unused, &ninth)
+	assert.True(t, first)
+	assert.False(t, second)
+	assert.True(t, ninth)
+
+	assert.Equal(t, uint64(math.MaxUint64), reader.Uint64())
+	assert.Equal(t, []byte{1, 2, 3}, reader.Bytes())
+	assert.Nil(t, reader.Bytes())
+	assert.NoError(t, reader.Err())
+
+	assert.Equal(t, uint64(0), reader.UintN(16))
+	assert.EqualError(t, reader.Done(), "65536 doesn't fit in 16 bits")
+}
+
+func TestNetworkReader_Errors(t *testing.T) {
+	reader := NewNetworkReader([]byte{0x80})
+	assert.Equal(t, uint64(0), reader.Uint())
+	assert.Equal(t, ErrPacketTruncated, reader.Err())
+
+	// the first error is kept.
+	reader.Uint64()
+	assert.Equal(t, ErrPacketTruncated, reader.Done())
+
+	// a count larger than what's left is refused before anything is allocated.
+	reader = NewNetworkReader([]byte{0xff, 0xff, 0xff, 0xff, 0x0f})
+	assert.Nil(t, reader.Bytes())
+	assert.Equal(t, ErrPacketTruncated, reader.Err())
+
+	reader = NewNetworkReader([]byte{1, 2})
+	reader.Uint()
+	assert.EqualError(t, reader.Done(), "1 trailing bytes")
+}
+
+func TestNetworkData_Binary(t *testing.T) {
+	data := NetworkData{
+		OwnerId:         3,
+		NetworkId:       600,
+		NetworkPrefabId: 2,
+		Data:            map[int][]byte{4: {1, 2}, 1: {3}, 12: {}},
+		Overrides:       []byte(`{"size":[2,2]}`),
+	}
+
+	bytes, err := data.MarshalBinary()
+	assert.NoError(t, err)
+
+	// the map is written in id order.
+	again, _ := data.MarshalBinary()
+	assert.Equal(t, bytes, again)
+
+	received := NetworkData{}
+	assert.NoError(t, received.UnmarshalBinary(bytes))
+
+	data.Data[12] = nil
+	assert.Equal(t, data, received)
+
+	assert.Equal(t, ErrPacketTruncated, received.UnmarshalBinary(bytes[:len(bytes)-1]))
+	assert.Error(t, received.UnmarshalBinary(append(bytes, 0)))
+}
+
+func TestWorldState_Binary(t *testing.T) {
+	state := WorldState{
+		Tick:      -1,
+		RandState: 0xdeadbeefcafe,
+		Baseline:  -3,
+		Destroyed: []int{4, 9},
+		Created:   []*NetworkData{{OwnerId: 1, NetworkId: 4, Data: map[int][]byte{0: {1}}, Overrides: []byte("{}")}},
+		Updates: []*NetworkData{
+			{NetworkId: 1, Data: map[int][]byte{}},
+			{NetworkId: 2, Data: map[int][]byte{1: {5, 6}}},
+		},
+		Removed: []int{7},
+		Inputs:  []PlayerInputs{{PlayerId: 1, Inputs: []NetworkInput{{Buttons: []byte{0x3}, Axes: []int8{-20}}, {Buttons: []byte{0}}}}},
+	}
+
+	bytes, err := state.MarshalBinary()
+	assert.NoError(t, err)
+
+	received := WorldState{}
+	assert.NoError(t, received.UnmarshalBinary(bytes))
+	assert.Equal(t, state, received)
+
+	// a broken packet leaves the state as it was.
+	for i := 0; i < len(bytes); i++ {
+		assert.Error(t, received.UnmarshalBinary(bytes[:i]))
+	}
+	assert.Equal(t, state, received)
+
+	empty := WorldState{}
+	bytes, _ = empty.MarshalBinary()
+	assert.NoError(t, received.UnmarshalBinary(bytes))
+	assert.Equal(t, empty, received)
+}
+
+func TestClientWorldStatePacket_Binary(t *testing.T) {
+	state, _ := (&WorldState{Tick: 20}).MarshalBinary()
+
+	packet := ClientWorldStatePacket{
+		RTT:      &RoundTripTime{PlayerId: 2, RecTime: 10, SentTimeClient: 11, SentTimeServer: 12},
+		State:    state,
+		InputAck: 19,
+	}
+
+	bytes, err := packet.MarshalBinary()
+	assert.NoError(t, err)
+
+	received := ClientWorldStatePacket{}
+	assert.NoError(t, received.UnmarshalBinary(bytes))
+	assert.Equal(t, packet, received)
+
+	packet.RTT = nil
+	bytes, _ = packet.MarshalBinary()
+	assert.NoError(t, received.UnmarshalBinary(bytes))
+	assert.Equal(t, packet, received)
+
+	assert.Equal(t, ErrPacketTruncated, received.UnmarshalBinary(nil))
+}
+
+func TestNetworkInput_Binary(t *testing.T) {
+	input := NetworkInput{Buttons: []byte{0x5, 0x80}, Axes: []int8{127, -127, 0}, HasCursor: true, CursorX: -300, CursorY: 32767}
+
+	writer := NetworkWriter{}
+	assert.NoError(t, writeNetworkInput(&writer, input))
+
+	reader := NewNetworkReader(writer.Data())
+	assert.Equal(t, input, readNetworkInput(reader))
+	assert.NoError(t, reader.Done())
+
+	// nothing held and no cursor is three bytes.
+	writer = NetworkWriter{}
+	assert.NoError(t, writeNetworkInput(&writer, NetworkInput{}))
+	assert.Equal(t, []byte{0, 0, 0}, writer.Data())
+
+	reader = NewNetworkReader(writer.Data())
+	assert.Equal(t, NetworkInput{}, readNetworkInput(reader))
+	assert.NoError(t, reader.Done())
+}
+
+func TestNetworkInput_BinaryErrors(t *testing.T) {
+	writer := NetworkWriter{}
+	assert.NoError(t, writeNetworkInput(&writer, NetworkInput{Buttons: []byte{1}, Axes: []int8{5}, HasCursor: true, CursorX: 1000, CursorY: 2}))
+	data := writer.Data()
+
+	for i := 0; i < len(data); i++ {
+		reader := NewNetworkReader(data[:i])
+		assert.Equal(t, NetworkInput{}, readNetworkInput(reader))
+		assert.Equal(t, ErrPacketTruncated, reader.Err(), "truncated at %d", i)
+	}
+
+	decode := func(data []byte) error {
+		reader := NewNetworkReader(data)
+		readNetworkInput(reader)
+		return reader.Done()
+	}
+
+	assert.EqualError(t, decode(append(data, 0)), "1 trailing bytes")
+	assert.EqualError(t, decode(append([]byte{33}, make([]byte, 40)...)), "33 buttons is more than the 32 allowed")
+	assert.EqualError(t, decode([]byte{0, 0, 1, 0x80, 0x80, 0x04, 0}), "32768 doesn't fit in 16 bits")
+
+	assert.EqualError(t, writeNetworkInput(&NetworkWriter{}, NetworkInput{Axes: make([]int8, 65)}), "65 axes is more than the 64 allowed")
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"github.com/Banyango/io-engine/src/ecs"
 	"github.com/Banyango/io-engine/src/game"
@@ -9,11 +10,17 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"path/filepath"
 	"time"
 )
 
 func main() {
 
+	reload := flag.Bool("reload", true, "reload game.json when it changes")
+	patch := flag.Bool("patch", true, "patch the tuned values of live entities when game.json is reloaded")
+	udp := flag.String("udp", ":8082", "address native clients connect to over udp, empty to turn it off")
+	flag.Parse()
+
 	gameJson, err := ioutil.ReadFile("./game.json");
 
 	if err != nil {
@@ -24,8 +31,9 @@ func main() {
 	w := ecs.NewWorld()
 
 	w.Log = new(server.ServerLogger)
+	w.Seed(uint64(time.Now().UnixNano()))
 
-	gameServer := server.Server{World: w}
+	gameServer := server.Server{World: w, DataDir: "."}
 
 	netInputBuffer := new(server.NetworkInputFutureCollectionSystem)
 	movement := new(game.KeyboardMovementSystem)
@@ -39,6 +47,9 @@ func main() {
 	w.AddSystem(spawn)
 	w.AddSystem(networkCollect)
 
+	// refuse to start with broken game data, every problem is listed in the error.
+	w.StrictLoading = true
+
 	pm, err := ecs.NewPrefabManager(string(gameJson), w)
 
 	if err != nil {
@@ -46,6 +57,26 @@ func main() {
 	}
 
 	w.PrefabData = pm
+	gameServer.SetDataVersion(pm.Version)
+
+	// the map isn't networked, clients load the same file.
+	if pm.Map != "" {
+		mapJson, err := ioutil.ReadFile(filepath.Join(gameServer.DataDir, pm.Map))
+
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		if err := w.LoadTiledMap(pm.Map, mapJson); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if pm.Scene != "" {
+		if err := gameServer.LoadScene(pm.Scene); err != nil {
+			log.Fatal(err)
+		}
+	}
 
 	w.CurrentFrameTime = time.Now().UnixNano() / int64(time.Millisecond)
 	w.TimeElapsed = 0
@@ -54,11 +85,32 @@ func main() {
 
 	go mainLoop(&gameServer)
 
+	if *reload {
+		go gameServer.WatchGameData("./game.json", time.Second, *patch)
+	}
+
+	if *udp != "" {
+		transport, err := server.ListenUDP(*udp)
+
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		transport.Version = gameServer.DataVersion
+
+		go func() {
+			if err := gameServer.Listen(transport); err != nil {
+				log.Fatal(err)
+			}
+		}()
+	}
+
 	http.HandleFunc("/connect", gameServer.Ws)
 	http.Handle("/", http.FileServer(http.Dir("./app/main/")))
 	http.HandleFunc("/game.json", func(w http.ResponseWriter, r *http.Request) {
 		http.ServeFile(w, r, "./game.json")
 	})
+	http.Handle("/maps/", http.FileServer(http.Dir(".")))
 
 	if err := http.ListenAndServe(":8081", nil); err != nil {
 		log.Fatal(err)
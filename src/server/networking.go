@@ -1,6 +1,7 @@
 package server
 
 import (
+	"fmt"
 	. "github.com/Banyango/io-engine/src/ecs"
 )
 
@@ -19,28 +20,190 @@ type RoundTripTime struct {
 
 // Serialized Bytes of the entity and components.
 type NetworkData struct {
-	OwnerId   PlayerId
-	NetworkId uint16
-	PrefabId  uint16
-	Data      map[int][]byte
+	OwnerId         PlayerId
+	NetworkId       uint16
+	NetworkPrefabId NetworkPrefabId
+	Data            map[int][]byte
+	Overrides       []byte // json component overrides, sent until the client has the entity, see WorldState.Delta
 }
 
+// Sent over the unreliable channel every SEND_TICK_RATE, see ClientWorldStatePacket.MarshalBinary.
 type ClientWorldStatePacket struct {
-	State []byte // gob serialized WorldState struct
-	RTT   *RoundTripTime
+	State    []byte // encoded WorldState
+	RTT      *RoundTripTime
+	InputAck int64 // newest tick of the client's input the server has applied, see InputHistory
 }
 
 type WorldState struct {
 	Tick      int64
+	RandState uint64 // world random generator state at Tick
+	Baseline  int64  // tick of the snapshot Updates are a delta against, 0 when they're the full state, see SnapshotHistory
 	Destroyed []int
 	Created   []*NetworkData
 	Updates   []*NetworkData
+	Removed   []int          // network ids of the baseline's entities to drop before the delta is applied
+	Inputs    []PlayerInputs // inputs of every player so clients can replay their peers
 }
 
+// Inputs the server has for a player, the one it applied at the tick of the state followed by the
+// ones buffered for the ticks after it.
+type PlayerInputs struct {
+	PlayerId PlayerId
+	Inputs   []NetworkInput
+}
+
+// Sent over the websocket as json when the client connects, before webrtc signaling.
+type ServerConnectionHandshakePacket struct {
+	PlayerId  PlayerId
+	Tick      int64
+	RandState uint64 // world random generator state at Tick
+	Version   string // game data version, clients with another version disconnect
+}
+
+// Sent over the websocket as json when the server reloads game.json.
+type GameDataPacket struct {
+	GameData string
+	Patch    bool // patch the tuned values of live entities
+}
+
+// Sent over the data channel every tick. Packets can be lost so they carry the inputs of every tick
+// the server hasn't acked yet, up to INPUT_REDUNDANCY, oldest first and ending with the input of Tick.
+//
+// Wire format: tick | time | snapshot ack | input count | inputs, see the codec.
 type ClientPacket struct {
-	Tick  int64
-	Time  int64
-	Input byte
+	Tick        int64
+	Time        int64
+	SnapshotAck int64 // tick of the newest world state the client has in full, 0 before the first
+	Inputs      []NetworkInput
+}
+
+// Ticks of input a client packet repeats, a packet can be lost for this many ticks in a row before the
+// server misses an input.
+const INPUT_REDUNDANCY = 8
+
+// The most inputs a decoded packet may carry.
+const MAX_CLIENT_PACKET_INPUTS = 32
+
+// Tick of the input at index i of Inputs.
+func (self *ClientPacket) InputTick(i int) int64 {
+	return self.Tick - int64(len(self.Inputs)-1-i)
+}
+
+func (self ClientPacket) MarshalBinary() ([]byte, error) {
+	if len(self.Inputs) > MAX_CLIENT_PACKET_INPUTS {
+		return nil, fmt.Errorf("%d inputs is more than the %d allowed", len(self.Inputs), MAX_CLIENT_PACKET_INPUTS)
+	}
+
+	writer := NetworkWriter{}
+
+	writer.Int(self.Tick)
+	writer.Int(self.Time)
+	writer.Int(self.SnapshotAck)
+	writer.Uint(uint64(len(self.Inputs)))
+
+	for _, networkInput := range self.Inputs {
+		if err := writeNetworkInput(&writer, networkInput); err != nil {
+			return nil, err
+		}
+	}
+
+	return writer.Data(), nil
+}
+
+func (self *ClientPacket) UnmarshalBinary(data []byte) error {
+	reader := NewNetworkReader(data)
+
+	result := ClientPacket{}
+
+	result.Tick = reader.Int()
+	result.Time = reader.Int()
+	result.SnapshotAck = reader.Int()
+
+	if count := reader.CountUpTo(MAX_CLIENT_PACKET_INPUTS, "inputs"); count > 0 {
+		result.Inputs = make([]NetworkInput, count)
+
+		for i := range result.Inputs {
+			result.Inputs[i] = readNetworkInput(reader)
+		}
+	}
+
+	if err := reader.Done(); err != nil {
+		return err
+	}
+
+	*self = result
+
+	return nil
+}
+
+/**
+InputHistory
+
+The inputs a client has sent that the server hasn't acked. Every packet repeats them so a lost packet
+doesn't lose its input, they're dropped once the server acks their tick.
+*/
+type InputHistory struct {
+	Redundancy int   // most inputs sent per packet, INPUT_REDUNDANCY if 0
+	Acked      int64 // newest tick the server has applied
+
+	ticks  []int64
+	inputs []NetworkInput
+}
+
+// Adds the input of the next tick. Ticks are sent as a run, if the client resynced to another tick
+// the older inputs are dropped.
+func (self *InputHistory) Add(tick int64, input NetworkInput) {
+	if len(self.ticks) > 0 && self.ticks[len(self.ticks)-1] != tick-1 {
+		self.ticks = self.ticks[:0]
+		self.inputs = self.inputs[:0]
+	}
+
+	// acks of the ticks before a resync back don't apply to the new ones.
+	if tick <= self.Acked {
+		self.Acked = tick - 1
+	}
+
+	self.ticks = append(self.ticks, tick)
+	self.inputs = append(self.inputs, input)
+
+	self.trim()
+}
+
+// Drops the inputs up to the tick the server has applied.
+func (self *InputHistory) Ack(tick int64) {
+	if tick > self.Acked {
+		self.Acked = tick
+	}
+
+	self.trim()
+}
+
+func (self *InputHistory) trim() {
+	redundancy := self.Redundancy
+	if redundancy <= 0 {
+		redundancy = INPUT_REDUNDANCY
+	}
+
+	start := 0
+	for start < len(self.ticks) && (self.ticks[start] <= self.Acked || len(self.ticks)-start > redundancy) {
+		start++
+	}
+
+	self.ticks = append(self.ticks[:0], self.ticks[start:]...)
+	self.inputs = append(self.inputs[:0], self.inputs[start:]...)
+}
+
+// Packet with every unacked input, nil if there's nothing to send.
+func (self *InputHistory) Packet(sentTime int64) *ClientPacket {
+	if len(self.ticks) == 0 {
+		return nil
+	}
+
+	return &ClientPacket{
+		Tick:   self.ticks[len(self.ticks)-1],
+		Time:   sentTime,
+		Inputs: append([]NetworkInput{}, self.inputs...),
+	}
 }
 
 type ReadSyncUDP interface {
@@ -64,10 +227,14 @@ Network Instance Component
 ----------------------------------------------------------------------------------------------------------------
 */
 
+// Owner of entities the server creates itself, like scene entities.
+const SERVER_PLAYER_ID PlayerId = 0xFFFF
+
 type NetworkInstanceComponent struct {
-	OwnerId   PlayerId
-	NetworkId uint16
-	PrefabId  int
+	OwnerId         PlayerId
+	NetworkId       uint16
+	NetworkPrefabId NetworkPrefabId
+	Overrides       []byte // json component overrides the entity was created with
 }
 
 func (*NetworkInstanceComponent) Id() int {
@@ -86,7 +253,8 @@ func (self *NetworkInstanceComponent) Clone() Component {
 	component := new(NetworkInstanceComponent)
 	component.OwnerId = self.OwnerId
 	component.NetworkId = self.NetworkId
-	component.PrefabId = self.PrefabId
+	component.NetworkPrefabId = self.NetworkPrefabId
+	component.Overrides = self.Overrides
 	return component
 }
 
@@ -147,10 +315,11 @@ func (self *NetworkInstanceDataCollectionSystem) UpdateSystem(delta float64, wor
 		entity := world.Entities[entity]
 
 		data := NetworkData{
-			OwnerId:   instance.OwnerId,
-			NetworkId: instance.NetworkId,
-			PrefabId:  uint16(entity.PrefabId),
-			Data:      map[int][]byte{},
+			OwnerId:         instance.OwnerId,
+			NetworkId:       instance.NetworkId,
+			NetworkPrefabId: instance.NetworkPrefabId,
+			Data:            map[int][]byte{},
+			Overrides:       instance.Overrides,
 		}
 
 		for _, val := range entity.Components {
@@ -191,14 +360,29 @@ func (self *NetworkInputFutureCollectionSystem) RequiredComponentTypes() []Compo
 }
 
 func (self *NetworkInputFutureCollectionSystem) UpdateSystem(delta float64, world *World) {
+	var inputs map[PlayerId]NetworkInput
+
 	for i := range world.Future {
 		if world.Future[i].Tick == world.CurrentTick {
-			for id, inputBytes := range world.Future[i].Bytes {
-				input := world.InputForPlayer(id)
-				if input != nil {
-					input.InputFromBytes(inputBytes)
-				}
-			}
+			inputs = world.Future[i].Inputs
+		}
+	}
+
+	// edges are from the input the tick before ran with, players without new input keep holding it.
+	var previous *InputController
+	if len(world.CacheInput) > 0 {
+		previous = world.CacheInput[len(world.CacheInput)-1]
+	}
+
+	for id, input := range world.Input.Player {
+		if networkInput, ok := inputs[id]; ok {
+			world.InputMap().Decode(networkInput, input)
+		}
+
+		if previous != nil {
+			input.SetEdges(previous.Player[id])
+		} else {
+			input.SetEdges(nil)
 		}
 	}
 
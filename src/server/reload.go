@@ -0,0 +1,95 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	. "github.com/Banyango/io-engine/src/ecs"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+/*
+----------------------------------------------------------------------------------------------------------------
+Game data reloading
+----------------------------------------------------------------------------------------------------------------
+*/
+
+// Rebuilds the prefabs from new game data on the next tick and pushes the data to every connected client
+// so their prediction uses the same values. Broken game data is returned as an error and the old data is kept,
+// game data that changes network prefab ids is only found on the tick and is printed.
+// Safe to call from any goroutine.
+func (self *Server) ReloadGameData(gameJson []byte, patch bool) error {
+
+	// loaded into a scratch world, the live world is only touched on the tick goroutine.
+	scratch := NewWorld()
+	scratch.StrictLoading = self.World.StrictLoading
+
+	prefabData, err := NewPrefabManager(string(gameJson), scratch)
+
+	if err != nil {
+		return err
+	}
+
+	packet, err := json.Marshal(GameDataPacket{GameData: string(gameJson), Patch: patch})
+
+	if err != nil {
+		return err
+	}
+
+	self.World.Enqueue(func(w *World) {
+		// clients have entities with the old ids.
+		if err := prefabData.CheckNetworkPrefabIds(w.PrefabData); err != nil {
+			fmt.Println("game data not reloaded")
+			fmt.Println(err)
+			return
+		}
+
+		changed := w.ReloadPrefabs(prefabData, patch)
+
+		self.SetDataVersion(prefabData.Version)
+
+		fmt.Println("game data reloaded, changed prefabs", changed)
+
+		// clients that are still signaling only handle signaling messages and miss this reload.
+		for _, clientConn := range self.ConnectedClients() {
+			if clientConn.IsDataChannelOpen() {
+				clientConn.SendReliable(packet)
+			}
+		}
+	})
+
+	return nil
+}
+
+// Polls the game data file and reloads it when it changes. Blocks, run it on its own goroutine.
+func (self *Server) WatchGameData(path string, interval time.Duration, patch bool) {
+
+	lastModified := time.Time{}
+
+	if info, err := os.Stat(path); err == nil {
+		lastModified = info.ModTime()
+	}
+
+	for range time.Tick(interval) {
+		info, err := os.Stat(path)
+
+		if err != nil || info.ModTime().Equal(lastModified) {
+			continue
+		}
+
+		lastModified = info.ModTime()
+
+		gameJson, err := ioutil.ReadFile(path)
+
+		if err != nil {
+			fmt.Println("error reading game data", err)
+			continue
+		}
+
+		if err := self.ReloadGameData(gameJson, patch); err != nil {
+			fmt.Println("game data not reloaded")
+			fmt.Println(err)
+		}
+	}
+}
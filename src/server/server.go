@@ -1,17 +1,16 @@
 package server
 
 import (
-	"bytes"
 	"encoding/base64"
-	"encoding/gob"
 	"encoding/json"
 	"fmt"
 	. "github.com/Banyango/io-engine/src/ecs"
-	"github.com/Banyango/socker"
 	"github.com/gorilla/websocket"
-	"github.com/pion/webrtc/v2"
 	"github.com/thoas/go-funk"
+	"io/ioutil"
 	"net/http"
+	"path/filepath"
+	"sort"
 	"sync"
 	"time"
 )
@@ -29,6 +28,12 @@ type Server struct {
 	Clients      []*ClientConnection
 	deltaCounter float64
 	World        *World
+	DataDir      string // directory of game.json, scene files are relative to it
+	dataVersion  string
+	snapshots    SnapshotHistory // states sent to the clients, deltas are against them
+
+	// browsers fall back to the websocket when their data channel isn't open after this, DATA_CHANNEL_TIMEOUT if zero.
+	DataChannelTimeout time.Duration
 }
 
 func (self *Server) EntityWasSpawned(entity *Entity) {
@@ -37,9 +42,10 @@ func (self *Server) EntityWasSpawned(entity *Entity) {
 	networkData := self.SerializeEntity(entity)
 	networkData.NetworkId = component.NetworkId
 	networkData.OwnerId = component.OwnerId
-	networkData.PrefabId = uint16(component.PrefabId)
+	networkData.NetworkPrefabId = component.NetworkPrefabId
+	networkData.Overrides = component.Overrides
 
-	fmt.Println("network spawn {owner=", component.OwnerId, " networkId=", component.NetworkId, " prefab=", component.PrefabId, "}")
+	fmt.Println("network spawn {owner=", component.OwnerId, " networkId=", component.NetworkId, " prefab=", component.NetworkPrefabId, "}")
 
 	self.CurrentState.Created = append(self.CurrentState.Created, networkData)
 
@@ -71,6 +77,13 @@ func (self *Server) Ws(writer http.ResponseWriter, request *http.Request) {
 
 	fmt.Println("Client is connecting..")
 
+	// clients send the version of their game data, a client with other data would mispredict everything.
+	if version := request.URL.Query().Get("version"); version != self.DataVersion() {
+		fmt.Println("Refusing client with game data version", version)
+		http.Error(writer, fmt.Sprintf("game data version %q doesn't match the server's %q", version, self.DataVersion()), http.StatusConflict)
+		return
+	}
+
 	conn, err := upgrader.Upgrade(writer, request, nil)
 
 	if err != nil {
@@ -78,8 +91,13 @@ func (self *Server) Ws(writer http.ResponseWriter, request *http.Request) {
 		return
 	}
 
-	self.createClientConnection(conn)
+	timeout := self.DataChannelTimeout
 
+	if timeout == 0 {
+		timeout = DATA_CHANNEL_TIMEOUT
+	}
+
+	self.Accept(NewWebRTCConnection(conn, timeout))
 }
 
 func (self *Server) SerializeEntity(entity *Entity) *NetworkData {
@@ -109,19 +127,20 @@ func (self *NetworkData) DeserializeNewEntity(world *World, isPeer bool) *Entity
 
 	world.Log.LogInfo("deserializing entity")
 
-	prefabId := int(self.PrefabId)
-
-	if isPeer {
-		prefabId = prefabId + 1
-	}
-
-	entity, err := world.PrefabData.CreatePrefab(prefabId)
+	entity, err := world.PrefabData.CreateNetworkPrefab(self.NetworkPrefabId, isPeer)
 
 	if err != nil {
 		world.Log.LogInfo("error creating prefab")
 		return nil
 	}
 
+	// the peer view may not have every overridden component, those are skipped.
+	if len(self.Overrides) > 0 {
+		if err := ApplyComponentOverrides(&entity, self.Overrides, false); err != nil {
+			world.Log.LogInfo("error applying overrides", err.Error())
+		}
+	}
+
 	if entity.Components != nil {
 		for _, comp := range entity.Components {
 			if val, ok := comp.(ReadSyncUDP); ok {
@@ -134,90 +153,156 @@ func (self *NetworkData) DeserializeNewEntity(world *World, isPeer bool) *Entity
 	return &entity
 }
 
-func (self *Server) createClientConnection(conn *websocket.Conn) {
+// Gives the connection a player id and connects it, the client is disconnected when the connection closes.
+// Safe to call from any goroutine.
+func (self *Server) Accept(connection Connection) *ClientConnection {
 
-	clientConn := new(ClientConnection)
+	clientConn := NewClientConnection(self.FetchAndIncrementPlayerId())
+	clientConn.Connection = connection
 
-	self.AddClient(clientConn)
+	fmt.Println("Client Given playerId: ", clientConn.PlayerId, connection.RemoteAddr())
 
-	clientConn.WSConnHandler = socker.NewClientConnection(conn)
-	clientConn.WSConnHandler.Connection.SetCloseHandler(func(code int, text string) error {
-		clientConn.Close(self.World)
-		self.RemoveClient(clientConn)
-		return nil
+	// connected before the pumps run so a connection that closes right away is still removed.
+	self.Connect(clientConn)
+
+	go clientConn.ReadPump(func() {
+		self.Disconnect(clientConn)
 	})
-	self.World.Mux.Lock()
+	go clientConn.WritePump()
 
-	clientConn.PlayerId = self.FetchAndIncrementPlayerId()
-	self.World.Input.Player[PlayerId(clientConn.PlayerId)] = NewInput()
-	clientConn.HasNotRecInputPacketYet = true
-	self.World.Mux.Unlock()
+	return clientConn
+}
 
-	fmt.Println("Client Given playerId: ", clientConn.PlayerId)
+// Accepts clients from the transport until it's closed. Blocks, run it on its own goroutine.
+func (self *Server) Listen(transport Transport) error {
+	return transport.Serve(func(connection Connection) {
+		self.Accept(connection)
+	})
+}
 
-	clientConn.WSConnHandler.Add(func(message []byte) bool {
-		if clientConn.PeerConnection == nil {
-			fmt.Println("Setting up webrtc data channel -> player", clientConn.PlayerId)
-			clientConn.ConnectToDataChannel(message, func() {
-				clientConn.Close(self.World)
-				self.RemoveClient(clientConn)
-			})
-		}
+// Registers the client with the server and queues the creation of its input for the tick goroutine.
+// The handshake is sent from the tick goroutine so it carries the current tick.
+// Safe to call from any goroutine.
+func (self *Server) Connect(clientConn *ClientConnection) {
+	self.AddClient(clientConn)
 
-		fmt.Println("Handling messages -> player", clientConn.PlayerId, string(message))
-		clientConn.HandleSignal(message)
+	playerId := clientConn.PlayerId
 
-		return clientConn.IsDataChannelOpen
-	})
+	self.World.Enqueue(func(w *World) {
+		w.Input.Player[playerId] = NewInput()
 
-	clientConn.WSConnHandler.Add(func(message []byte) bool {
-		var handshake map[string]interface{}
-		err := json.Unmarshal(message, &handshake)
+		handshake, err := json.Marshal(ServerConnectionHandshakePacket{
+			PlayerId:  playerId,
+			Tick:      w.CurrentTick,
+			RandState: w.Rand.State,
+			Version:   w.PrefabData.Version,
+		})
 
 		if err != nil {
-			// close connection
-			// todo handle this better. Unsubscribe the player.
-			fmt.Println("messaging failed!")
-			panic(err)
+			fmt.Println("Error encoding handshake player:", playerId, err)
+			return
 		}
 
-		if val, ok := handshake["event"]; ok {
-			if val == "spawn" {
-				entity, err := self.World.PrefabData.CreatePrefab(0)
+		clientConn.SendReliable(handshake)
+	})
+}
+
+// Version of the game data clients must have to connect.
+func (self *Server) DataVersion() string {
+	self.mux.Lock()
+	defer self.mux.Unlock()
+	return self.dataVersion
+}
 
-				if err != nil {
-					// close connection
-					// todo handle this better. Unsubscribe the player.
-					fmt.Println("Handshake failed!")
-					panic(err)
-				}
+// Sets the version of the game data clients must have to connect. Clients already connected are kept.
+// Safe to call from any goroutine.
+func (self *Server) SetDataVersion(version string) {
+	self.mux.Lock()
+	defer self.mux.Unlock()
+	self.dataVersion = version
+}
 
-				fmt.Println("spawn -> player ", clientConn.PlayerId)
-				self.mux.Lock()
-				defer self.mux.Unlock()
-				networkInstanceComponent := new(NetworkInstanceComponent)
-				networkInstanceComponent.OwnerId = clientConn.PlayerId
-				networkInstanceComponent.NetworkId = self.FetchAndIncrementNetworkId()
-				networkInstanceComponent.PrefabId = entity.PrefabId
-				entity.Components[int(NetworkInstanceComponentType)] = networkInstanceComponent
+// Removes the client from the server and queues the removal of its entities and input.
+// Safe to call from any goroutine and more than once.
+func (self *Server) Disconnect(clientConn *ClientConnection) {
+	if self.RemoveClient(clientConn) {
+		clientConn.Close(self.World)
+	}
+}
 
-				self.World.Mux.Lock()
-				defer self.World.Mux.Unlock()
-				self.World.Spawn(entity)
+// Queues the player prefab to be spawned for the client on the next tick.
+// Safe to call from any goroutine.
+func (self *Server) SpawnPlayer(clientConn *ClientConnection) error {
+	networkPrefab, ok := self.World.PrefabData.NetworkPrefabByName(self.World.PrefabData.Player)
 
-			}
-			//else if val == "resync" {
-			//	fmt.Println("resync -> player ", clientConn.PlayerId)
-			//	clientConn.SendHandshakePacket(self.World.CurrentTick, self.Buffered)
-			//}
-		}
+	if !ok {
+		return fmt.Errorf("player network prefab %q doesn't exist", self.World.PrefabData.Player)
+	}
 
-		return false
+	networkInstanceComponent := new(NetworkInstanceComponent)
+	networkInstanceComponent.OwnerId = clientConn.PlayerId
+	networkInstanceComponent.NetworkPrefabId = networkPrefab.Id
+
+	entity, err := self.World.PrefabData.CreatePrefab(networkPrefab.Owner, WithComponent(networkInstanceComponent))
+
+	if err != nil {
+		return err
+	}
+
+	// only taken once the player can be created, network ids are a uint16 and never reused.
+	networkInstanceComponent.NetworkId = self.FetchAndIncrementNetworkId()
+
+	fmt.Println("spawn -> player ", clientConn.PlayerId)
+
+	self.World.Enqueue(func(w *World) {
+		// the player may have disconnected before the spawn was run.
+		if _, ok := w.Input.Player[networkInstanceComponent.OwnerId]; ok {
+			w.Spawn(entity)
+		}
 	})
 
-	go clientConn.WSConnHandler.ReadPump()
-	go clientConn.WSConnHandler.WritePump()
+	return nil
+}
+
+// Loads a scene from the game data and queues its entities to be spawned. The server owns them.
+// Call it before the tick loop starts or on the tick goroutine, e.g. when a room is created.
+func (self *Server) LoadScene(name string) error {
+	prefabData := self.World.PrefabData
+
+	file, ok := prefabData.Scenes[name]
+
+	if !ok {
+		return fmt.Errorf("scene %q doesn't exist", name)
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(self.DataDir, file))
+
+	if err != nil {
+		return err
+	}
+
+	sceneEntities, err := prefabData.LoadScene(name, data)
+
+	if err != nil {
+		return err
+	}
+
+	for i := range sceneEntities {
+		entity := sceneEntities[i].Entity
+
+		networkInstanceComponent := new(NetworkInstanceComponent)
+		networkInstanceComponent.OwnerId = SERVER_PLAYER_ID
+		networkInstanceComponent.NetworkId = self.FetchAndIncrementNetworkId()
+		networkInstanceComponent.NetworkPrefabId = sceneEntities[i].NetworkPrefab.Id
+		networkInstanceComponent.Overrides = sceneEntities[i].Overrides
+		entity.Components[int(NetworkInstanceComponentType)] = networkInstanceComponent
+
+		self.World.Spawn(entity)
+	}
 
+	fmt.Println("scene", name, "loaded with", len(sceneEntities), "entities")
+
+	return nil
 }
 
 func (self *Server) Clear() {
@@ -231,6 +316,8 @@ func (self *Server) ClearUpdates() {
 }
 
 func (self *Server) FetchAndIncrementPlayerId() PlayerId {
+	self.mux.Lock()
+	defer self.mux.Unlock()
 
 	temp := self.PlayerIndex
 
@@ -240,6 +327,8 @@ func (self *Server) FetchAndIncrementPlayerId() PlayerId {
 }
 
 func (self *Server) FetchAndIncrementNetworkId() uint16 {
+	self.mux.Lock()
+	defer self.mux.Unlock()
 
 	temp := self.NetworkIndex
 
@@ -249,51 +338,90 @@ func (self *Server) FetchAndIncrementNetworkId() uint16 {
 }
 
 func (self *Server) HandleIncomingData(delta float64) {
-	for _, client := range self.Clients {
+	for _, client := range self.ConnectedClients() {
 
-		// Handle WS messages
-		client.WSConnHandler.Handle()
+		for handled := false; !handled; {
+			select {
+			case message := <-client.ReliableIn:
+				self.HandleReliable(client, message)
+			default:
+				handled = true
+			}
+		}
 
-		if client.IsDataChannelOpen {
+		if client.IsDataChannelOpen() {
 			// Handle WebRTC messages
 			select {
 			case message, ok := <-client.UdpIn:
 				if ok {
-					if len(message) > 1 {
+					self.HandleClientPacket(client, message)
+				}
+			default:
+			}
+		} else {
+			self.World.ResetInput(client.PlayerId)
+		}
+	}
+}
 
-						var input ClientPacket
-						err := gob.NewDecoder(bytes.NewBuffer(message)).Decode(&input)
+// Handles the json events clients send over the reliable channel.
+func (self *Server) HandleReliable(client *ClientConnection, message []byte) {
+	var event map[string]interface{}
 
-						if err != nil {
-							fmt.Println("errror decoding input packet for player ", client.PlayerId, err)
-						}
+	if err := json.Unmarshal(message, &event); err != nil {
+		fmt.Println("Unknown reliable message player:", client.PlayerId, err)
+		return
+	}
 
-						if input.Tick < self.World.CurrentTick {
-							//fmt.Println("tick before current frame ", input.Tick, self.World.CurrentTick)
-							self.World.ResetInput(client.PlayerId)
-						}
+	if val, ok := event["event"]; ok {
+		if val == "spawn" {
+			if err := self.SpawnPlayer(client); err != nil {
+				fmt.Println("Spawn failed player:", client.PlayerId, err)
+			}
+		}
+	}
+}
+
+// Buffers the inputs of a client packet and advances the tick acked back to the client.
+func (self *Server) HandleClientPacket(client *ClientConnection, message []byte) {
+	if len(message) <= 1 {
+		return
+	}
 
-						if input.Tick % 3 == 0 || client.HasNotRecInputPacketYet {
+	var input ClientPacket
 
-							if client.HasNotRecInputPacketYet {
-								client.HasNotRecInputPacketYet = false
-							}
+	if err := input.UnmarshalBinary(message); err != nil {
+		fmt.Println("error decoding input packet for player ", client.PlayerId, err)
+		return
+	}
 
-							client.PlayerId = client.PlayerId
-							client.RoundTripTime = new(RoundTripTime)
-							client.RoundTripTimeTickToSendOn = input.Tick
-							client.RoundTripTime.SentTimeClient = input.Time
-							client.RoundTripTime.RecTime = time.Now().UnixNano()
+	if input.Tick < self.World.CurrentTick {
+		//fmt.Println("tick before current frame ", input.Tick, self.World.CurrentTick)
+		self.World.ResetInput(client.PlayerId)
+	}
 
-						}
+	if input.Tick%3 == 0 || client.HasNotRecInputPacketYet {
 
-						self.World.SetFutureInput(input.Tick, message[8], client.PlayerId)
-					}
-				}
-			default:
-			}
-		} else {
-			self.World.ResetInput(client.PlayerId)
+		if client.HasNotRecInputPacketYet {
+			client.HasNotRecInputPacketYet = false
+		}
+
+		client.RoundTripTime = new(RoundTripTime)
+		client.RoundTripTimeTickToSendOn = input.Tick
+		client.RoundTripTime.SentTimeClient = input.Time
+		client.RoundTripTime.RecTime = time.Now().UnixNano()
+
+	}
+
+	if input.SnapshotAck > client.SnapshotAck {
+		client.SnapshotAck = input.SnapshotAck
+	}
+
+	for i := range input.Inputs {
+		tick := input.InputTick(i)
+
+		if self.World.SetFutureInput(tick, input.Inputs[i], client.PlayerId) && tick > client.InputAck {
+			client.InputAck = tick
 		}
 	}
 }
@@ -307,43 +435,49 @@ func (self *Server) SendNetworkData(delta float64) {
 		return
 	}
 
-	var worldStateBytes []byte
-	var gameStateBuffer bytes.Buffer
-	enc := gob.NewEncoder(&gameStateBuffer)
-
 	self.CurrentState.Tick = self.World.CurrentTick
+	self.CurrentState.RandState = self.World.Rand.State
+	self.CurrentState.Inputs = self.collectInputs()
 
-	if err := enc.Encode(self.CurrentState); err != nil {
-		fmt.Printf("Encoding Failed")
-		return
-	}
+	self.snapshots.Add(self.CurrentState.Tick, self.CurrentState.Updates)
 
-	worldStateBytes = gameStateBuffer.Bytes()
+	// the full state is encoded once for every client without a snapshot to delta against.
+	worldStateBytes, err := self.CurrentState.MarshalBinary()
 
-	for _, client := range self.Clients {
-		// only send data changes if the webrtc connection is open.
-		if client.IsDataChannelOpen {
-			if client.DataChannel != nil && len(worldStateBytes) > 0 {
+	if err != nil {
+		fmt.Println("Encoding Failed", err)
+		return
+	}
 
-				var clientStateBuffer bytes.Buffer
-				enc := gob.NewEncoder(&clientStateBuffer)
+	for _, client := range self.ConnectedClients() {
+		// only send data changes if the unreliable channel is open.
+		if client.IsDataChannelOpen() {
+			if client.RoundTripTime != nil {
+				client.RoundTripTime.SentTimeServer = time.Now().UnixNano()
+			}
 
-				if client.RoundTripTime != nil {
-					client.RoundTripTime.SentTimeServer = time.Now().UnixNano()
-				}
+			state := worldStateBytes
 
-				if err := enc.Encode(ClientWorldStatePacket{
-					RTT:   client.RoundTripTime,
-					State: worldStateBytes,
-				}); err != nil {
-					fmt.Printf("Encoding Failed")
+			if baseline, found := self.snapshots.Find(client.SnapshotAck); found && client.SnapshotAck < self.CurrentState.Tick {
+				if state, err = self.CurrentState.Delta(client.SnapshotAck, baseline).MarshalBinary(); err != nil {
+					fmt.Println("Encoding Failed", err)
 					continue
 				}
+			}
 
-				err := client.DataChannel.Send(clientStateBuffer.Bytes())
-				if err != nil {
-					fmt.Println("Error Writing to data channel player:", client.PlayerId)
-				}
+			packet, err := (&ClientWorldStatePacket{
+				RTT:      client.RoundTripTime,
+				State:    state,
+				InputAck: client.InputAck,
+			}).MarshalBinary()
+
+			if err != nil {
+				fmt.Println("Encoding Failed", err)
+				continue
+			}
+
+			if err := client.SendUnreliable(packet); err != nil {
+				fmt.Println("Error Writing to data channel player:", client.PlayerId)
 			}
 		}
 
@@ -353,21 +487,72 @@ func (self *Server) SendNetworkData(delta float64) {
 		self.deltaCounter = 0
 	}
 
-	// todo right now send state but this needs to be rm when deltas are brought in.
 	self.Clear()
 }
 
+// Inputs of every player from the current tick on, in player order.
+func (self *Server) collectInputs() []PlayerInputs {
+	inputMap := self.World.InputMap()
+
+	ids := []PlayerId{}
+	for id := range self.World.Input.Player {
+		ids = append(ids, id)
+	}
+
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	result := []PlayerInputs{}
+
+	for _, id := range ids {
+		playerInputs := PlayerInputs{PlayerId: id, Inputs: []NetworkInput{inputMap.Encode(self.World.Input.Player[id])}}
+
+		for tick := self.World.CurrentTick + 1; ; tick++ {
+			input, ok := self.World.FutureInput(tick, id)
+
+			if !ok {
+				break
+			}
+
+			playerInputs.Inputs = append(playerInputs.Inputs, input)
+		}
+
+		result = append(result, playerInputs)
+	}
+
+	return result
+}
+
 func (self *Server) AddClient(connection *ClientConnection) {
 	self.mux.Lock()
 	self.Clients = append(self.Clients, connection)
 	self.mux.Unlock()
 }
 
-func (self *Server) RemoveClient(connection *ClientConnection) {
+// returns false if the client was already removed.
+func (self *Server) RemoveClient(connection *ClientConnection) bool {
 	self.mux.Lock()
+	defer self.mux.Unlock()
+
 	indexOf := funk.IndexOf(self.Clients, connection)
+
+	if indexOf == -1 {
+		return false
+	}
+
 	self.Clients = append(self.Clients[:indexOf], self.Clients[indexOf+1:]...)
-	self.mux.Unlock()
+
+	return true
+}
+
+// returns a copy of the client list that is safe to range over while clients connect and disconnect.
+func (self *Server) ConnectedClients() []*ClientConnection {
+	self.mux.Lock()
+	defer self.mux.Unlock()
+
+	clients := make([]*ClientConnection, len(self.Clients))
+	copy(clients, self.Clients)
+
+	return clients
 }
 
 func (self *Server) FindNetworkId(entityId int64) (uint16, bool) {
@@ -381,20 +566,31 @@ func (self *Server) FindNetworkId(entityId int64) (uint16, bool) {
 	return 0, false
 }
 
+const (
+	UDP_IN_BUFFER_SIZE       = 64
+	RELIABLE_IN_BUFFER_SIZE  = 16
+	RELIABLE_OUT_BUFFER_SIZE = 64
+)
+
+/**
+ClientConnection
+
+A connected player on top of its transport Connection. ReadPump and WritePump run on their own
+goroutines, they only ever write to UdpIn and ReliableIn or write to the connection, everything
+else is read on the tick goroutine.
+*/
 type ClientConnection struct {
-	PlayerId PlayerId
-	// websocket handler.
-	WSConnHandler *socker.SockerClientConnection
+	PlayerId   PlayerId
+	Connection Connection
 
-	// WebRTC channel
+	// unreliable messages
 	UdpIn chan []byte
 
-	// Udp - unreliable
-	PeerConnection            *webrtc.PeerConnection
-	DataChannel               *webrtc.DataChannel
-	Offer                     webrtc.SessionDescription
-	AnswerSent                bool
-	IsDataChannelOpen         bool
+	// reliable messages
+	ReliableIn chan []byte
+
+	reliableOut               chan []byte
+	closed                    chan struct{}
 	Resync                    bool
 	RoundTripTimeTickToSendOn int64
 	RoundTripTime             *RoundTripTime
@@ -402,145 +598,119 @@ type ClientConnection struct {
 	SimulateFaster          bool
 	Data                    NetworkData
 	HasNotRecInputPacketYet bool
+	InputAck                int64 // newest tick of input buffered for the world, sent back so the client stops resending it
+	SnapshotAck             int64 // newest world state tick the client has, its states are sent as deltas against it
 }
 
-func (self *ClientConnection) ConnectToDataChannel(data []byte, onClose func()) {
-
-	self.UdpIn = make(chan []byte)
+func NewClientConnection(playerId PlayerId) *ClientConnection {
+	clientConn := new(ClientConnection)
+	clientConn.PlayerId = playerId
+	clientConn.UdpIn = make(chan []byte, UDP_IN_BUFFER_SIZE)
+	clientConn.ReliableIn = make(chan []byte, RELIABLE_IN_BUFFER_SIZE)
+	clientConn.reliableOut = make(chan []byte, RELIABLE_OUT_BUFFER_SIZE)
+	clientConn.closed = make(chan struct{})
+	clientConn.HasNotRecInputPacketYet = true
+	return clientConn
+}
 
-	fmt.Println("Configuring ICE server")
-	config := webrtc.Configuration{
-		ICEServers: []webrtc.ICEServer{
-			{
-				URLs: []string{"stun:stun.l.google.com:19302"},
-			},
-		},
-	}
+func (self *ClientConnection) IsDataChannelOpen() bool {
+	return self.Connection != nil && self.Connection.UnreliableOpen()
+}
 
-	fmt.Println("Created Peer Connection")
-	peerConnection, err := webrtc.NewPeerConnection(config)
-	if err != nil {
-		panic(err)
+func (self *ClientConnection) SendUnreliable(data []byte) error {
+	if self.Connection == nil {
+		return ErrUnreliableNotOpen
 	}
 
-	// Set the handler for ICE connection state
-	// This will notify you when the peer has connected/disconnected
-	peerConnection.OnICEConnectionStateChange(func(connectionState webrtc.ICEConnectionState) {
-		fmt.Printf("ICE Connection State has changed: %s\n", connectionState.String())
-		if connectionState == webrtc.ICEConnectionStateDisconnected {
-			self.IsDataChannelOpen = false
-		} else if connectionState == webrtc.ICEConnectionStateConnected {
-			self.IsDataChannelOpen = true
-		}
-	})
-
-	peerConnection.OnDataChannel(func(d *webrtc.DataChannel) {
-		fmt.Printf("New DataChannel %s %d\n", d.Label(), d.ID())
-
-		self.DataChannel = d
-
-		d.OnOpen(func() {
-			fmt.Printf("Opened DataChannel player{%d} %d\n", self.PlayerId, d.ID())
-			self.IsDataChannelOpen = true
-		})
-
-		d.OnMessage(func(msg webrtc.DataChannelMessage) {
-			self.UdpIn <- msg.Data
-		})
-
-		d.OnClose(func() {
-			fmt.Printf("Closing connection ->", self.PlayerId)
-		})
-	})
-
-	self.PeerConnection = peerConnection
+	return self.Connection.SendUnreliable(data)
 }
 
-func (self *ClientConnection) HandleSignal(data []byte) {
-
-	var handshake map[string]interface{}
-	err := json.Unmarshal(data, &handshake)
-
-	if err != nil {
-		// close connection
-		// todo handle this better. Unsubscribe the player.
-		fmt.Println("Handshake failed!")
-		panic(err)
+// Queues a reliable message. Messages are written in order by WritePump so the tick
+// goroutine never blocks on a slow or closed connection.
+func (self *ClientConnection) SendReliable(data []byte) {
+	select {
+	case self.reliableOut <- data:
+	default:
+		fmt.Println("Dropping reliable message, buffer full player:", self.PlayerId)
 	}
+}
 
-	if val, ok := handshake["offer"]; ok {
-		fmt.Println("Rec Offer: ", handshake["offer"])
-		offer := webrtc.SessionDescription{}
-		Decode(val.(string), &offer)
-
-		err = self.PeerConnection.SetRemoteDescription(offer)
-		if err != nil {
-			panic(err)
-		}
-
-		answer, err := self.PeerConnection.CreateAnswer(nil)
-
-		if err != nil {
-			panic(err)
+func (self *ClientConnection) WritePump() {
+	for {
+		select {
+		case data := <-self.reliableOut:
+			if self.Connection != nil {
+				if err := self.Connection.SendReliable(data); err != nil {
+					fmt.Println("Error writing reliable message player:", self.PlayerId, err)
+				}
+			}
+		case <-self.closed:
+			return
 		}
+	}
+}
 
-		err = self.PeerConnection.SetLocalDescription(answer)
-		if err != nil {
-			panic(err)
+// Moves messages from the connection to the tick goroutine and calls onClose once the connection closes.
+func (self *ClientConnection) ReadPump(onClose func()) {
+	for message := range self.Connection.Receive() {
+		if !message.Reliable {
+			self.ReceiveUnreliable(message.Data)
+			continue
 		}
 
-		handshake["answer"] = Encode(answer)
-		marshal, err := json.Marshal(handshake)
-
-		if err != nil {
-			panic(err)
+		select {
+		case self.ReliableIn <- message.Data:
+		case <-self.closed:
 		}
+	}
 
-		self.WSConnHandler.Write(marshal)
-
-	} else if val, ok := handshake["candidate"]; ok {
-		fmt.Println("Adding candidate: ", handshake["candidate"])
-		candidateInit := webrtc.ICECandidateInit{}
-
-		err = json.Unmarshal([]byte(val.(string)), &candidateInit)
-		if err != nil {
-			panic(err)
-		}
+	onClose()
+}
 
-		err := self.PeerConnection.AddICECandidate(candidateInit)
-		if err != nil {
-			fmt.Println("Ice candidate error")
-			panic(err)
-		}
+// Queues an unreliable message for the tick goroutine.
+// The message is dropped if the tick goroutine has fallen behind, the same as a lost packet.
+func (self *ClientConnection) ReceiveUnreliable(data []byte) {
+	select {
+	case self.UdpIn <- data:
+	default:
 	}
 }
 
+// Queues the removal of the players entities and input, then closes the connection.
 func (self *ClientConnection) Close(world *World) {
-	world.Mux.Lock()
-	defer world.Mux.Unlock()
-
-	for id, ent := range world.Entities {
-		if comp, ok := ent.Components[int(NetworkInstanceComponentType)]; ok {
-			if net, ok := comp.(*NetworkInstanceComponent); ok {
-				if net.OwnerId == self.PlayerId {
-					world.ToDestroy = append(world.ToDestroy, id)
+	playerId := self.PlayerId
+
+	world.Enqueue(func(w *World) {
+		for id, ent := range w.Entities {
+			if comp, ok := ent.Components[int(NetworkInstanceComponentType)]; ok {
+				if net, ok := comp.(*NetworkInstanceComponent); ok {
+					if net.OwnerId == playerId {
+						w.Destroy(id)
+					}
 				}
 			}
 		}
-	}
-	fmt.Println("Removing player ", self.PlayerId)
-	delete(world.Input.Player, self.PlayerId)
 
-	err := self.PeerConnection.Close()
+		// drop entities that were queued for spawning but haven't been added yet.
+		toSpawn := w.ToSpawn[:0]
+		for _, entity := range w.ToSpawn {
+			if net, ok := entity.Components[int(NetworkInstanceComponentType)].(*NetworkInstanceComponent); ok && net.OwnerId == playerId {
+				continue
+			}
+			toSpawn = append(toSpawn, entity)
+		}
+		w.ToSpawn = toSpawn
 
-	if err != nil {
-		fmt.Println(err)
-	}
+		fmt.Println("Removing player ", playerId)
+		delete(w.Input.Player, playerId)
+	})
 
-	err = self.DataChannel.Close()
+	close(self.closed)
 
-	if err != nil {
-		fmt.Println(err)
+	if self.Connection != nil {
+		if err := self.Connection.Close(); err != nil {
+			fmt.Println(err)
+		}
 	}
 }
 
@@ -0,0 +1,568 @@
+package server
+
+import (
+	"encoding/json"
+	. "github.com/Banyango/io-engine/src/ecs"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// spawns and destroys queued entities the same way game.SpawnSystem does.
+type testSpawnSystem struct {
+	server *Server
+}
+
+func (*testSpawnSystem) Init(w *World)                           {}
+func (*testSpawnSystem) AddToStorage(entity *Entity)             {}
+func (*testSpawnSystem) RemoveFromStorage(entity *Entity)        {}
+func (*testSpawnSystem) RequiredComponentTypes() []ComponentType { return []ComponentType{} }
+
+func (self *testSpawnSystem) UpdateSystem(delta float64, world *World) {
+	for _, entity := range world.ToSpawn {
+		entity.Id = world.FetchAndIncrementId()
+		world.AddEntityToWorld(entity)
+		self.server.EntityWasSpawned(&entity)
+	}
+	world.ToSpawn = nil
+
+	for _, id := range world.ToDestroy {
+		self.server.EntityWasDestroyed(id)
+		world.RemoveEntity(id)
+	}
+	world.ToDestroy = nil
+}
+
+func createTestServer() *Server {
+	world := NewWorld()
+	world.Input.Player = map[PlayerId]*Input{}
+	world.PrefabData = &PrefabData{
+		Prefabs:        map[int]Entity{0: NewEntity()},
+		NetworkPrefabs: map[NetworkPrefabId]NetworkPrefab{0: {Id: 0, Name: "Player", Owner: 0, Peer: 0}},
+		Player:         "Player",
+	}
+
+	gameServer := &Server{World: world}
+
+	world.AddSystem(new(NetworkInputFutureCollectionSystem))
+	world.AddSystem(&testSpawnSystem{server: gameServer})
+	world.AddSystem(NewNetworkInstanceDataCollectionSystem(gameServer))
+
+	return gameServer
+}
+
+// Run with -race. Clients connect, spawn, send input and disconnect from their own goroutines
+// while the tick goroutine is running.
+func TestServer_ConcurrentClientsDuringTicks(t *testing.T) {
+
+	gameServer := createTestServer()
+
+	httpServer := httptest.NewServer(http.HandlerFunc(gameServer.Ws))
+	defer httpServer.Close()
+
+	url := "ws" + strings.TrimPrefix(httpServer.URL, "http")
+
+	done := make(chan struct{})
+	ticking := sync.WaitGroup{}
+	ticking.Add(1)
+
+	go func() {
+		defer ticking.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+				gameServer.HandleIncomingData(FIXED_DELTA)
+				gameServer.World.Update(FIXED_DELTA)
+				gameServer.SendNetworkData(FIXED_DELTA)
+			}
+		}
+	}()
+
+	clients := sync.WaitGroup{}
+
+	for i := 0; i < 32; i++ {
+		clients.Add(1)
+		go func() {
+			defer clients.Done()
+
+			conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+
+			if !assert.NoError(t, err) {
+				return
+			}
+
+			// the websocket handler registers the client before the upgrade returns.
+			var clientConn *ClientConnection
+			for deadline := time.Now().Add(time.Second); clientConn == nil && time.Now().Before(deadline); {
+				for _, c := range gameServer.ConnectedClients() {
+					if c.Connection != nil && c.Connection.RemoteAddr() == conn.LocalAddr().String() {
+						clientConn = c
+					}
+				}
+				time.Sleep(time.Millisecond)
+			}
+
+			if !assert.NotNil(t, clientConn, "client %s wasn't registered", conn.LocalAddr()) {
+				conn.Close()
+				return
+			}
+
+			assert.NoError(t, gameServer.SpawnPlayer(clientConn))
+
+			for j := 0; j < 10; j++ {
+				clientConn.ReceiveUnreliable([]byte{byte(j)})
+			}
+
+			time.Sleep(10 * time.Millisecond)
+
+			err = conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+			assert.NoError(t, err)
+
+			conn.Close()
+		}()
+	}
+
+	clients.Wait()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(gameServer.ConnectedClients()) > 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+
+	close(done)
+	ticking.Wait()
+
+	// run the remaining disconnect commands and the destroys they queued.
+	gameServer.World.Update(FIXED_DELTA)
+	gameServer.World.Update(FIXED_DELTA)
+
+	assert.Equal(t, 0, len(gameServer.ConnectedClients()))
+	assert.Equal(t, 0, len(gameServer.World.Entities))
+	assert.Equal(t, 0, len(gameServer.World.Input.Player))
+}
+
+func TestServer_DisconnectTwice(t *testing.T) {
+	gameServer := createTestServer()
+
+	clientConn := NewClientConnection(gameServer.FetchAndIncrementPlayerId())
+
+	gameServer.Connect(clientConn)
+	gameServer.World.Update(FIXED_DELTA)
+
+	assert.NotNil(t, gameServer.World.InputForPlayer(clientConn.PlayerId))
+
+	gameServer.Disconnect(clientConn)
+	gameServer.Disconnect(clientConn)
+	gameServer.World.Update(FIXED_DELTA)
+
+	assert.Nil(t, gameServer.World.InputForPlayer(clientConn.PlayerId))
+	assert.Equal(t, 0, len(gameServer.ConnectedClients()))
+}
+
+func TestServer_SpawnPlayerThatFailsKeepsTheNetworkId(t *testing.T) {
+	gameServer := createTestServer()
+	gameServer.World.PrefabData.NetworkPrefabs[0] = NetworkPrefab{Id: 0, Name: "Player", Owner: 3, Peer: 3}
+
+	clientConn := NewClientConnection(gameServer.FetchAndIncrementPlayerId())
+
+	assert.Error(t, gameServer.SpawnPlayer(clientConn))
+	assert.Equal(t, uint16(0), gameServer.NetworkIndex)
+
+	gameServer.World.PrefabData.NetworkPrefabs[0] = NetworkPrefab{Id: 0, Name: "Player", Owner: 0, Peer: 0}
+
+	assert.NoError(t, gameServer.SpawnPlayer(clientConn))
+	assert.Equal(t, uint16(1), gameServer.NetworkIndex)
+}
+
+func TestServer_ConnectSendsHandshake(t *testing.T) {
+	gameServer := createTestServer()
+	gameServer.World.Seed(77)
+	gameServer.World.PrefabData.Version = "1.2.0"
+
+	// the generator has moved on since it was seeded.
+	gameServer.World.Rand.Uint64()
+	gameServer.World.Update(FIXED_DELTA)
+
+	clientConn := NewClientConnection(gameServer.FetchAndIncrementPlayerId())
+
+	gameServer.Connect(clientConn)
+	gameServer.World.Update(FIXED_DELTA)
+
+	var handshake ServerConnectionHandshakePacket
+	assert.NoError(t, json.Unmarshal(<-clientConn.reliableOut, &handshake))
+
+	assert.Equal(t, clientConn.PlayerId, handshake.PlayerId)
+	assert.Equal(t, gameServer.World.Rand.State, handshake.RandState)
+	assert.NotEqual(t, uint64(77), handshake.RandState)
+	assert.Equal(t, gameServer.World.CurrentTick, handshake.Tick)
+	assert.Equal(t, "1.2.0", handshake.Version)
+}
+
+func TestServer_RefusesOtherDataVersion(t *testing.T) {
+	gameServer := createTestServer()
+	gameServer.SetDataVersion("1.2.0")
+
+	httpServer := httptest.NewServer(http.HandlerFunc(gameServer.Ws))
+	defer httpServer.Close()
+
+	url := "ws" + strings.TrimPrefix(httpServer.URL, "http")
+
+	for _, version := range []string{"", "1.1.0"} {
+		_, response, err := websocket.DefaultDialer.Dial(url+"?version="+version, nil)
+
+		assert.Error(t, err)
+		if assert.NotNil(t, response) {
+			assert.Equal(t, http.StatusConflict, response.StatusCode)
+		}
+	}
+
+	assert.Equal(t, 0, len(gameServer.ConnectedClients()))
+
+	conn, _, err := websocket.DefaultDialer.Dial(url+"?version=1.2.0", nil)
+
+	if assert.NoError(t, err) {
+		conn.Close()
+	}
+}
+
+func TestServer_ReloadGameData(t *testing.T) {
+	gameServer := createTestServer()
+
+	open := NewClientConnection(gameServer.FetchAndIncrementPlayerId())
+	open.Connection, _ = NewMemoryConnectionPair()
+	signaling := NewClientConnection(gameServer.FetchAndIncrementPlayerId())
+
+	gameServer.AddClient(open)
+	gameServer.AddClient(signaling)
+
+	gameJson := `{"name": "test", "version": "1.1.0", "prefabs": { "player": { "id": "0", "components": [] } } }`
+
+	assert.NoError(t, gameServer.ReloadGameData([]byte(gameJson), true))
+
+	// swapped in on the next tick.
+	assert.NotEqual(t, 0, len(gameServer.World.PrefabData.NetworkPrefabs))
+
+	gameServer.World.Update(FIXED_DELTA)
+
+	assert.Equal(t, 0, gameServer.World.PrefabData.Names["player"])
+	assert.Equal(t, 0, len(gameServer.World.PrefabData.NetworkPrefabs))
+	assert.Equal(t, "1.1.0", gameServer.DataVersion())
+
+	var packet GameDataPacket
+	assert.NoError(t, json.Unmarshal(<-open.reliableOut, &packet))
+	assert.Equal(t, gameJson, packet.GameData)
+	assert.True(t, packet.Patch)
+
+	assert.Equal(t, 0, len(signaling.reliableOut))
+}
+
+func TestServer_ReloadBrokenGameData(t *testing.T) {
+	gameServer := createTestServer()
+	gameServer.World.StrictLoading = true
+
+	prefabData := gameServer.World.PrefabData
+
+	assert.Error(t, gameServer.ReloadGameData([]byte(`{"prefabs": { "player": { "id": "zero" } } }`), true))
+
+	gameServer.World.Update(FIXED_DELTA)
+
+	assert.Equal(t, prefabData, gameServer.World.PrefabData)
+}
+
+func TestServer_ReloadRejectsNewNetworkPrefabIds(t *testing.T) {
+	gameServer := createTestServer()
+
+	open := NewClientConnection(gameServer.FetchAndIncrementPlayerId())
+	open.Connection, _ = NewMemoryConnectionPair()
+	gameServer.AddClient(open)
+
+	prefabData := gameServer.World.PrefabData
+
+	gameJson := `{
+		"version": "1.1.0",
+		"network": { "Player": { "id": 1, "owner": "player" }, "Bullet": { "id": 0, "owner": "player" } },
+		"prefabs": { "player": { "id": "0", "components": [] } }
+	}`
+
+	assert.NoError(t, gameServer.ReloadGameData([]byte(gameJson), true))
+
+	gameServer.World.Update(FIXED_DELTA)
+
+	assert.Equal(t, prefabData, gameServer.World.PrefabData)
+	assert.Equal(t, 0, len(open.reliableOut))
+
+	// new network prefabs get new ids.
+	gameJson = `{
+		"version": "1.1.0",
+		"network": { "Player": { "id": 0, "owner": "player" }, "Bullet": { "id": 1, "owner": "player" } },
+		"prefabs": { "player": { "id": "0", "components": [] } }
+	}`
+
+	assert.NoError(t, gameServer.ReloadGameData([]byte(gameJson), true))
+
+	gameServer.World.Update(FIXED_DELTA)
+
+	assert.Equal(t, 2, len(gameServer.World.PrefabData.NetworkPrefabs))
+	assert.Equal(t, 1, len(open.reliableOut))
+}
+
+func TestServer_LoadScene(t *testing.T) {
+	dir, err := ioutil.TempDir("", "scene")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	scene := `{ "name": "arena", "entities": [ { "prefab": "Wall" }, { "prefab": "Wall" } ] }`
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "arena.json"), []byte(scene), 0644))
+
+	gameServer := createTestServer()
+	gameServer.DataDir = dir
+
+	spawn := &testSpawnSystem{server: gameServer}
+
+	world := gameServer.World
+	world.PrefabData = &PrefabData{
+		Prefabs:        map[int]Entity{0: NewEntity()},
+		NetworkPrefabs: map[NetworkPrefabId]NetworkPrefab{0: {Id: 0, Name: "Wall", Owner: 0, Peer: 0}},
+		Scenes:         map[string]string{"arena": "arena.json"},
+	}
+
+	assert.Error(t, gameServer.LoadScene("missing"))
+	assert.NoError(t, gameServer.LoadScene("arena"))
+
+	assert.Equal(t, 2, len(world.ToSpawn))
+
+	spawn.UpdateSystem(FIXED_DELTA, world)
+
+	assert.Equal(t, 2, len(gameServer.CurrentState.Created))
+
+	for _, created := range gameServer.CurrentState.Created {
+		assert.Equal(t, SERVER_PLAYER_ID, created.OwnerId)
+		assert.Equal(t, NetworkPrefabId(0), created.NetworkPrefabId)
+	}
+
+	assert.NotEqual(t, gameServer.CurrentState.Created[0].NetworkId, gameServer.CurrentState.Created[1].NetworkId)
+}
+
+func TestServer_AnalogInputIsAppliedAndCached(t *testing.T) {
+	gameServer := createTestServer()
+	world := gameServer.World
+
+	world.PrefabData.InputMap = &InputMap{
+		Actions: []InputAction{{Name: "fire"}},
+		Axes:    []InputAxis{{Name: "move_x", Gamepad: -1}},
+		Cursor:  true,
+	}
+	world.Input.Player[3] = NewInput()
+
+	world.SetFutureInput(world.CurrentTick+1, NetworkInput{Buttons: []byte{1}, Axes: []int8{-127}, HasCursor: true, CursorX: 40, CursorY: 50}, 3)
+
+	world.Update(FIXED_DELTA)
+
+	input := world.InputForPlayer(3)
+
+	assert.True(t, input.Action("fire"))
+	assert.Equal(t, -1.0, input.Axis("move_x"))
+	assert.Equal(t, 40.0, input.Cursor.X())
+	assert.Equal(t, 50.0, input.Cursor.Y())
+
+	cached := world.CacheInput[len(world.CacheInput)-1].Player[3]
+
+	assert.Equal(t, -1.0, cached.Axis("move_x"))
+	assert.Equal(t, input.Cursor, cached.Cursor)
+}
+
+func TestClientPacket_Binary(t *testing.T) {
+	packet := ClientPacket{Tick: 1200, Time: time.Now().UnixNano(), SnapshotAck: 1196, Inputs: []NetworkInput{{Buttons: []byte{0x3}, Axes: []int8{-20}}, {Buttons: []byte{0x1}}}}
+
+	data, err := packet.MarshalBinary()
+	assert.NoError(t, err)
+
+	received := ClientPacket{}
+	assert.NoError(t, received.UnmarshalBinary(data))
+	assert.Equal(t, packet, received)
+	assert.Equal(t, int64(1199), received.InputTick(0))
+
+	assert.Equal(t, ErrPacketTruncated, received.UnmarshalBinary(data[:len(data)-1]))
+	assert.EqualError(t, received.UnmarshalBinary(append(data, 0)), "1 trailing bytes")
+	assert.Equal(t, ErrPacketTruncated, received.UnmarshalBinary(nil))
+	assert.EqualError(t, received.UnmarshalBinary(append([]byte{0, 0, 0, 40}, make([]byte, 120)...)), "40 inputs is more than the 32 allowed")
+
+	_, err = ClientPacket{Inputs: make([]NetworkInput, 33)}.MarshalBinary()
+	assert.EqualError(t, err, "33 inputs is more than the 32 allowed")
+}
+
+// records the default action buttons each player held on every tick.
+type testInputRecordSystem struct {
+	buttons map[int64]byte
+}
+
+func (*testInputRecordSystem) Init(w *World)                           {}
+func (*testInputRecordSystem) AddToStorage(entity *Entity)             {}
+func (*testInputRecordSystem) RemoveFromStorage(entity *Entity)        {}
+func (*testInputRecordSystem) RequiredComponentTypes() []ComponentType { return []ComponentType{} }
+
+func (self *testInputRecordSystem) UpdateSystem(delta float64, world *World) {
+	self.buttons[world.CurrentTick] = world.InputMap().ToButtons(world.InputForPlayer(1))[0]
+}
+
+func TestServer_RedundantInputSurvivesPacketLoss(t *testing.T) {
+	gameServer := createTestServer()
+	world := gameServer.World
+
+	recorder := &testInputRecordSystem{buttons: map[int64]byte{}}
+	world.AddSystem(recorder)
+
+	client := NewClientConnection(1)
+	world.Input.Player[1] = NewInput()
+
+	history := InputHistory{Redundancy: 4}
+	sent := map[int64]byte{}
+
+	for i := 0; i < 40; i++ {
+		// the client runs a few ticks ahead of the server.
+		tick := world.CurrentTick + 3
+		sent[tick] = byte(tick % 64)
+
+		history.Add(tick, NetworkInput{Buttons: []byte{sent[tick]}})
+
+		// drops two packets out of every three, more than that and the oldest resent input arrives
+		// after its tick ran.
+		if i%3 == 0 {
+			data, err := history.Packet(time.Now().UnixNano()).MarshalBinary()
+			assert.NoError(t, err)
+
+			gameServer.HandleClientPacket(client, data)
+
+			// the ack is lost too.
+			if i%6 == 0 {
+				history.Ack(client.InputAck)
+			}
+		}
+
+		world.Update(FIXED_DELTA)
+	}
+
+	assert.Equal(t, world.CurrentTick+2, client.InputAck)
+
+	for tick := int64(4); tick <= world.CurrentTick; tick++ {
+		assert.Equal(t, sent[tick], recorder.buttons[tick], "tick %d", tick)
+	}
+}
+
+func TestServer_DuplicateInputIsIgnored(t *testing.T) {
+	gameServer := createTestServer()
+	world := gameServer.World
+
+	world.Input.Player[1] = NewInput()
+
+	assert.True(t, world.SetFutureInput(2, NetworkInput{Buttons: []byte{1}}, 1))
+	assert.True(t, world.SetFutureInput(2, NetworkInput{Buttons: []byte{2}}, 1))
+	assert.False(t, world.SetFutureInput(world.CurrentTick, NetworkInput{Buttons: []byte{4}}, 1))
+
+	world.Update(FIXED_DELTA)
+	world.Update(FIXED_DELTA)
+
+	assert.True(t, world.InputForPlayer(1).Action("move_up"))
+	assert.False(t, world.InputForPlayer(1).Action("move_down"))
+	assert.Empty(t, world.Future)
+}
+
+func TestInputHistory(t *testing.T) {
+	history := InputHistory{Redundancy: 3}
+
+	assert.Nil(t, history.Packet(0))
+
+	for tick := int64(10); tick < 15; tick++ {
+		history.Add(tick, NetworkInput{Buttons: []byte{byte(tick)}})
+	}
+
+	packet := history.Packet(5)
+
+	assert.Equal(t, int64(14), packet.Tick)
+	assert.Equal(t, []NetworkInput{{Buttons: []byte{12}}, {Buttons: []byte{13}}, {Buttons: []byte{14}}}, packet.Inputs)
+	assert.Equal(t, int64(12), packet.InputTick(0))
+
+	history.Ack(13)
+	assert.Equal(t, []NetworkInput{{Buttons: []byte{14}}}, history.Packet(5).Inputs)
+
+	// a late ack doesn't go back.
+	history.Ack(11)
+	assert.Equal(t, int64(13), history.Acked)
+
+	// resynced back to an earlier tick.
+	history.Add(3, NetworkInput{})
+	assert.Equal(t, int64(3), history.Packet(5).Tick)
+	assert.Equal(t, 1, len(history.Packet(5).Inputs))
+}
+
+func TestServer_StateCarriesPlayerInputs(t *testing.T) {
+	gameServer := createTestServer()
+	world := gameServer.World
+
+	world.Input.Player[1] = NewInput()
+	world.Input.Player[0] = NewInput()
+
+	world.SetFutureInput(1, NetworkInput{Buttons: []byte{0x1}}, 1)
+	world.SetFutureInput(2, NetworkInput{Buttons: []byte{0x2}}, 1)
+	world.SetFutureInput(3, NetworkInput{Buttons: []byte{0x4}}, 1)
+	world.SetFutureInput(3, NetworkInput{Buttons: []byte{0x8}}, 0)
+
+	world.Update(FIXED_DELTA)
+
+	inputs := gameServer.collectInputs()
+
+	// player 0 has nothing buffered for tick 2 so its tick 3 input isn't sent yet.
+	assert.Equal(t, []PlayerInputs{
+		{PlayerId: 0, Inputs: []NetworkInput{{Buttons: []byte{0x0}}}},
+		{PlayerId: 1, Inputs: []NetworkInput{{Buttons: []byte{0x1}}, {Buttons: []byte{0x2}}, {Buttons: []byte{0x4}}}},
+	}, inputs)
+}
+
+func TestServer_InputEdgesArePerTick(t *testing.T) {
+	gameServer := createTestServer()
+	world := gameServer.World
+
+	world.Input.Player[1] = NewInput()
+
+	pressed := map[int64]bool{}
+	released := map[int64]bool{}
+
+	world.AddSystem(&testFuncSystem{update: func(world *World) {
+		pressed[world.CurrentTick] = world.InputForPlayer(1).ActionPressed("fire")
+		released[world.CurrentTick] = world.InputForPlayer(1).ActionReleased("fire")
+	}})
+
+	// fire is the fifth default action, held from tick 2 to 3.
+	world.SetFutureInput(2, NetworkInput{Buttons: []byte{0x10}}, 1)
+	world.SetFutureInput(4, NetworkInput{Buttons: []byte{0x0}}, 1)
+
+	for i := 0; i < 5; i++ {
+		world.Update(FIXED_DELTA)
+	}
+
+	assert.Equal(t, map[int64]bool{1: false, 2: true, 3: false, 4: false, 5: false}, pressed)
+	assert.Equal(t, map[int64]bool{1: false, 2: false, 3: false, 4: true, 5: false}, released)
+}
+
+type testFuncSystem struct {
+	update func(world *World)
+}
+
+func (*testFuncSystem) Init(w *World)                           {}
+func (*testFuncSystem) AddToStorage(entity *Entity)             {}
+func (*testFuncSystem) RemoveFromStorage(entity *Entity)        {}
+func (*testFuncSystem) RequiredComponentTypes() []ComponentType { return []ComponentType{} }
+
+func (self *testFuncSystem) UpdateSystem(delta float64, world *World) {
+	self.update(world)
+}
@@ -0,0 +1,217 @@
+package server
+
+import (
+	"bytes"
+	"sort"
+)
+
+// World states kept on both sides to delta against, a client whose ack is older than all of them gets
+// the full state.
+const SNAPSHOT_HISTORY = 32
+
+/*
+----------------------------------------------------------------------------------------------------------------
+Delta compression
+----------------------------------------------------------------------------------------------------------------
+*/
+
+/**
+Delta compression
+
+Every world state the server sends is kept as a snapshot, the entity updates at its tick. Clients
+send back the tick of the newest state they have in full in ClientPacket.SnapshotAck and the server
+sends each client its updates as a delta against that snapshot:
+
+	an entity that didn't change is left out
+	an entity that did only carries the components whose bytes changed
+	an entity that's new is sent in full, with its overrides so a client that joined after it was
+	created, like scene entities, creates it as it was
+	an entity that's gone, or lost a component or changed owner or prefab, is in Removed and is
+	sent in full if it's still there
+
+The client applies the delta to its copy of the snapshot, so HandleWorldStatePacket always gets the
+full state. Ticks start at 1, a Baseline or an ack of 0 means the full state.
+*/
+type SnapshotHistory struct {
+	Size int // snapshots kept, SNAPSHOT_HISTORY if 0
+
+	ticks   []int64
+	updates [][]*NetworkData
+}
+
+// Keeps the updates of the state at tick, in network id order. The oldest tick is dropped when full.
+func (self *SnapshotHistory) Add(tick int64, updates []*NetworkData) {
+	if _, found := self.Find(tick); found || tick <= 0 {
+		return
+	}
+
+	sorted := append([]*NetworkData{}, updates...)
+	sortByNetworkId(sorted)
+
+	self.ticks = append(self.ticks, tick)
+	self.updates = append(self.updates, sorted)
+
+	size := self.Size
+	if size <= 0 {
+		size = SNAPSHOT_HISTORY
+	}
+
+	if len(self.ticks) > size {
+		oldest := 0
+		for i := range self.ticks {
+			if self.ticks[i] < self.ticks[oldest] {
+				oldest = i
+			}
+		}
+
+		self.ticks = append(self.ticks[:oldest], self.ticks[oldest+1:]...)
+		self.updates = append(self.updates[:oldest], self.updates[oldest+1:]...)
+	}
+}
+
+func (self *SnapshotHistory) Find(tick int64) ([]*NetworkData, bool) {
+	for i := range self.ticks {
+		if self.ticks[i] == tick {
+			return self.updates[i], true
+		}
+	}
+
+	return nil, false
+}
+
+// Newest tick kept, 0 if there's none.
+func (self *SnapshotHistory) Newest() int64 {
+	newest := int64(0)
+
+	for _, tick := range self.ticks {
+		if tick > newest {
+			newest = tick
+		}
+	}
+
+	return newest
+}
+
+// Copy of the state with its updates as a delta against the snapshot at baselineTick.
+func (self *WorldState) Delta(baselineTick int64, baseline []*NetworkData) *WorldState {
+	delta := *self
+	delta.Baseline = baselineTick
+	delta.Updates = []*NetworkData{}
+	delta.Removed = []int{}
+
+	previous := map[uint16]*NetworkData{}
+	for _, data := range baseline {
+		previous[data.NetworkId] = data
+	}
+
+	for _, data := range self.Updates {
+		before, found := previous[data.NetworkId]
+		delete(previous, data.NetworkId)
+
+		if !found {
+			delta.Updates = append(delta.Updates, data)
+			continue
+		}
+
+		if !data.extends(before) {
+			delta.Removed = append(delta.Removed, int(data.NetworkId))
+			delta.Updates = append(delta.Updates, data)
+			continue
+		}
+
+		// the client has the entity, the overrides are only needed to create it.
+		changed := NetworkData{
+			OwnerId:         data.OwnerId,
+			NetworkId:       data.NetworkId,
+			NetworkPrefabId: data.NetworkPrefabId,
+			Data:            map[int][]byte{},
+		}
+
+		for id, component := range data.Data {
+			if !bytes.Equal(component, before.Data[id]) {
+				changed.Data[id] = component
+			}
+		}
+
+		if len(changed.Data) > 0 {
+			delta.Updates = append(delta.Updates, &changed)
+		}
+	}
+
+	for id := range previous {
+		delta.Removed = append(delta.Removed, int(id))
+	}
+
+	sort.Ints(delta.Removed)
+
+	return &delta
+}
+
+// Turns a delta back into the full state with the snapshot it's against, the snapshot isn't changed.
+func (self *WorldState) ApplyDelta(baseline []*NetworkData) {
+	removed := map[uint16]bool{}
+	for _, id := range self.Removed {
+		removed[uint16(id)] = true
+	}
+
+	entities := map[uint16]*NetworkData{}
+	for _, data := range baseline {
+		if !removed[data.NetworkId] {
+			entities[data.NetworkId] = data
+		}
+	}
+
+	for _, data := range self.Updates {
+		before, found := entities[data.NetworkId]
+
+		if !found {
+			entities[data.NetworkId] = data
+			continue
+		}
+
+		merged := *before
+		merged.Data = make(map[int][]byte, len(before.Data))
+
+		for id, component := range before.Data {
+			merged.Data[id] = component
+		}
+
+		for id, component := range data.Data {
+			merged.Data[id] = component
+		}
+
+		entities[data.NetworkId] = &merged
+	}
+
+	self.Updates = make([]*NetworkData, 0, len(entities))
+	for _, data := range entities {
+		self.Updates = append(self.Updates, data)
+	}
+
+	sortByNetworkId(self.Updates)
+
+	self.Baseline = 0
+	self.Removed = nil
+}
+
+// Whether the entity can be sent as the changes since before, it has the same owner, prefab and at
+// least the same components.
+func (self *NetworkData) extends(before *NetworkData) bool {
+	if self.OwnerId != before.OwnerId || self.NetworkPrefabId != before.NetworkPrefabId {
+		return false
+	}
+
+	for id := range before.Data {
+		if _, ok := self.Data[id]; !ok {
+			return false
+		}
+	}
+
+	return true
+}
+
+func sortByNetworkId(updates []*NetworkData) {
+	sort.Slice(updates, func(i, j int) bool {
+		return updates[i].NetworkId < updates[j].NetworkId
+	})
+}
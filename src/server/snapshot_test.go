@@ -0,0 +1,171 @@
+package server
+
+import (
+	"encoding/json"
+	. "github.com/Banyango/io-engine/src/ecs"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestSnapshotHistory(t *testing.T) {
+	history := SnapshotHistory{Size: 2}
+
+	assert.Equal(t, int64(0), history.Newest())
+
+	history.Add(0, []*NetworkData{{NetworkId: 1}})
+	_, found := history.Find(0)
+	assert.False(t, found)
+
+	history.Add(5, []*NetworkData{{NetworkId: 3}, {NetworkId: 1}})
+	history.Add(4, nil)
+
+	updates, found := history.Find(5)
+	assert.True(t, found)
+	assert.Equal(t, uint16(1), updates[0].NetworkId)
+	assert.Equal(t, int64(5), history.Newest())
+
+	// the oldest tick goes, not the first added.
+	history.Add(6, nil)
+	_, found = history.Find(4)
+	assert.False(t, found)
+	_, found = history.Find(5)
+	assert.True(t, found)
+	assert.Equal(t, int64(6), history.Newest())
+}
+
+func TestWorldState_Delta(t *testing.T) {
+	baseline := []*NetworkData{
+		{NetworkId: 1, Data: map[int][]byte{0: {1}, 1: {2}}},
+		{NetworkId: 2, Data: map[int][]byte{0: {3}}},
+		{NetworkId: 3, Data: map[int][]byte{0: {4}, 1: {5}}},
+		{NetworkId: 4, Data: map[int][]byte{0: {6}}},
+		{NetworkId: 6, OwnerId: 1, Data: map[int][]byte{0: {8}}},
+	}
+
+	state := WorldState{Tick: 10, Updates: []*NetworkData{
+		{NetworkId: 5, Data: map[int][]byte{0: {7}}},
+		{NetworkId: 1, Data: map[int][]byte{0: {1}, 1: {9}}},
+		{NetworkId: 2, Data: map[int][]byte{0: {3}}},
+		{NetworkId: 3, Data: map[int][]byte{0: {4}}},
+		{NetworkId: 6, OwnerId: 2, Data: map[int][]byte{0: {8}}},
+	}}
+
+	delta := state.Delta(8, baseline)
+
+	assert.Equal(t, int64(8), delta.Baseline)
+	assert.Equal(t, int64(10), delta.Tick)
+	assert.Equal(t, []int{3, 4, 6}, delta.Removed)
+	assert.Equal(t, []*NetworkData{
+		{NetworkId: 5, Data: map[int][]byte{0: {7}}},
+		{NetworkId: 1, Data: map[int][]byte{1: {9}}},
+		{NetworkId: 3, Data: map[int][]byte{0: {4}}},
+		{NetworkId: 6, OwnerId: 2, Data: map[int][]byte{0: {8}}},
+	}, delta.Updates)
+
+	bytes, err := delta.MarshalBinary()
+	assert.NoError(t, err)
+
+	received := WorldState{}
+	assert.NoError(t, received.UnmarshalBinary(bytes))
+
+	received.ApplyDelta(baseline)
+
+	sortByNetworkId(state.Updates)
+	assert.Equal(t, state, received)
+
+	// the snapshot is left as it was.
+	assert.Equal(t, []byte{2}, baseline[0].Data[1])
+	assert.Equal(t, 5, len(baseline))
+}
+
+// A client that acks a state gets the next ones as deltas against it, and nothing for an entity that
+// didn't change.
+func TestServer_SendsDeltasAgainstTheAckedSnapshot(t *testing.T) {
+	gameServer := createTestServer()
+
+	transport := NewMemoryTransport()
+	defer transport.Close()
+
+	go gameServer.Listen(transport)
+
+	client, err := transport.Dial()
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer client.Close()
+
+	tick := func() {
+		gameServer.HandleIncomingData(FIXED_DELTA)
+		gameServer.World.Update(FIXED_DELTA)
+		gameServer.SendNetworkData(FIXED_DELTA)
+	}
+
+	tick()
+
+	var handshake ServerConnectionHandshakePacket
+	assert.NoError(t, json.Unmarshal(receiveMessage(t, client, true), &handshake))
+	assert.NoError(t, client.SendReliable([]byte(`{"event":"spawn"}`)))
+
+	for deadline := time.Now().Add(time.Second); len(gameServer.World.Entities) == 0 && time.Now().Before(deadline); {
+		tick()
+	}
+
+	// the newest state sent, older ones still queued are skipped.
+	nextState := func() WorldState {
+		var state WorldState
+
+		for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); {
+			tick()
+
+			received := false
+			for drained := false; !drained; {
+				select {
+				case message := <-client.Receive():
+					if !message.Reliable {
+						var packet ClientWorldStatePacket
+						assert.NoError(t, packet.UnmarshalBinary(message.Data))
+						assert.NoError(t, state.UnmarshalBinary(packet.State))
+						received = true
+					}
+				default:
+					drained = true
+				}
+			}
+
+			if received {
+				return state
+			}
+		}
+
+		t.Fatal("no world state")
+		return state
+	}
+
+	full := nextState()
+	for len(full.Updates) == 0 {
+		full = nextState()
+	}
+	assert.Equal(t, int64(0), full.Baseline)
+
+	packet, err := ClientPacket{Tick: gameServer.World.CurrentTick + 2, SnapshotAck: full.Tick, Inputs: []NetworkInput{{}}}.MarshalBinary()
+	assert.NoError(t, err)
+	assert.NoError(t, client.SendUnreliable(packet))
+
+	clientConn := gameServer.ConnectedClients()[0]
+	for deadline := time.Now().Add(time.Second); clientConn.SnapshotAck == 0 && time.Now().Before(deadline); {
+		time.Sleep(time.Millisecond)
+		tick()
+	}
+
+	delta := nextState()
+
+	assert.Equal(t, full.Tick, delta.Baseline)
+	assert.Empty(t, delta.Updates)
+	assert.Empty(t, delta.Removed)
+
+	delta.ApplyDelta(full.Updates)
+
+	sent, _ := gameServer.snapshots.Find(delta.Tick)
+	assert.Equal(t, sent, delta.Updates)
+}
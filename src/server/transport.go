@@ -0,0 +1,325 @@
+package server
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+)
+
+/*
+----------------------------------------------------------------------------------------------------------------
+Transports
+----------------------------------------------------------------------------------------------------------------
+*/
+
+/**
+Connection
+
+One client's link to the server, or the server's link seen from a client. Reliable messages arrive in
+order or the connection closes, unreliable ones can be lost or reordered and carry the per tick input
+and world state. The browser uses a websocket for reliable messages and a WebRTC data channel for
+unreliable ones, other transports only need to keep the same guarantees.
+
+Messages from the other side are read from Receive, which is closed once the connection closes.
+Every method is safe to call from any goroutine.
+*/
+type Connection interface {
+	SendReliable(data []byte) error
+
+	// Errors if the unreliable channel isn't open yet.
+	SendUnreliable(data []byte) error
+
+	Receive() <-chan Message
+
+	// Whether unreliable messages can be sent and received.
+	UnreliableOpen() bool
+
+	RemoteAddr() string
+
+	Stats() ConnectionStats
+
+	// Safe to call more than once.
+	Close() error
+}
+
+type Message struct {
+	Data     []byte
+	Reliable bool
+}
+
+type ConnectionStats struct {
+	ReliableSent       int64
+	ReliableReceived   int64
+	UnreliableSent     int64
+	UnreliableReceived int64
+	BytesSent          int64
+	BytesReceived      int64
+	Dropped            int64 // unreliable messages dropped because the reader fell behind
+}
+
+// Accepts client connections and passes each one to accept. Serve blocks until the transport is closed.
+// The browser transport is served over http instead, see Server.Ws.
+type Transport interface {
+	Serve(accept func(Connection)) error
+	Close() error
+}
+
+var ErrConnectionClosed = errors.New("connection is closed")
+
+var ErrUnreliableNotOpen = errors.New("unreliable channel isn't open")
+
+const RECEIVE_BUFFER_SIZE = 64
+
+// Receive side of a connection. Messages can be pushed from any goroutine, a reliable push waits for
+// the reader and an unreliable one is dropped when the buffer is full, the same as a lost packet.
+type inbox struct {
+	messages chan Message
+	closed   chan struct{}
+	done     bool
+	mux      sync.RWMutex
+	once     sync.Once
+	stats    connectionCounters
+}
+
+func newInbox() *inbox {
+	return &inbox{
+		messages: make(chan Message, RECEIVE_BUFFER_SIZE),
+		closed:   make(chan struct{}),
+	}
+}
+
+func (self *inbox) push(message Message) bool {
+	self.mux.RLock()
+	defer self.mux.RUnlock()
+
+	if self.done {
+		return false
+	}
+
+	if message.Reliable {
+		select {
+		case self.messages <- message:
+		case <-self.closed:
+			return false
+		}
+	} else {
+		select {
+		case self.messages <- message:
+		default:
+			atomic.AddInt64(&self.stats.dropped, 1)
+			return false
+		}
+	}
+
+	self.stats.received(message)
+
+	return true
+}
+
+// Pushes without waiting, returns false if the message didn't fit. Unlike push a reliable message
+// isn't lost, the caller keeps it and tries again.
+func (self *inbox) tryPush(message Message) bool {
+	self.mux.RLock()
+	defer self.mux.RUnlock()
+
+	if self.done {
+		return false
+	}
+
+	select {
+	case self.messages <- message:
+		self.stats.received(message)
+		return true
+	default:
+		if !message.Reliable {
+			atomic.AddInt64(&self.stats.dropped, 1)
+		}
+		return false
+	}
+}
+
+func (self *inbox) close() {
+	self.once.Do(func() {
+		// wakes up reliable pushes waiting for the reader before closing the channel they send on.
+		close(self.closed)
+
+		self.mux.Lock()
+		self.done = true
+		close(self.messages)
+		self.mux.Unlock()
+	})
+}
+
+func (self *inbox) isClosed() bool {
+	select {
+	case <-self.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+type connectionCounters struct {
+	reliableSent       int64
+	reliableReceived   int64
+	unreliableSent     int64
+	unreliableReceived int64
+	bytesSent          int64
+	bytesReceived      int64
+	dropped            int64
+}
+
+func (self *connectionCounters) sent(message Message) {
+	if message.Reliable {
+		atomic.AddInt64(&self.reliableSent, 1)
+	} else {
+		atomic.AddInt64(&self.unreliableSent, 1)
+	}
+	atomic.AddInt64(&self.bytesSent, int64(len(message.Data)))
+}
+
+func (self *connectionCounters) received(message Message) {
+	if message.Reliable {
+		atomic.AddInt64(&self.reliableReceived, 1)
+	} else {
+		atomic.AddInt64(&self.unreliableReceived, 1)
+	}
+	atomic.AddInt64(&self.bytesReceived, int64(len(message.Data)))
+}
+
+func (self *connectionCounters) snapshot() ConnectionStats {
+	return ConnectionStats{
+		ReliableSent:       atomic.LoadInt64(&self.reliableSent),
+		ReliableReceived:   atomic.LoadInt64(&self.reliableReceived),
+		UnreliableSent:     atomic.LoadInt64(&self.unreliableSent),
+		UnreliableReceived: atomic.LoadInt64(&self.unreliableReceived),
+		BytesSent:          atomic.LoadInt64(&self.bytesSent),
+		BytesReceived:      atomic.LoadInt64(&self.bytesReceived),
+		Dropped:            atomic.LoadInt64(&self.dropped),
+	}
+}
+
+/*
+----------------------------------------------------------------------------------------------------------------
+In memory transport
+----------------------------------------------------------------------------------------------------------------
+*/
+
+// One end of an in process connection, for tests and bots running in the server's process.
+type MemoryConnection struct {
+	// drops unreliable messages it returns true for, to simulate packet loss. Set it before sending.
+	Drop func(data []byte) bool
+
+	name  string
+	inbox *inbox
+	peer  *MemoryConnection
+}
+
+// Both ends of a connection, messages sent on one are received on the other.
+func NewMemoryConnectionPair() (*MemoryConnection, *MemoryConnection) {
+	a := &MemoryConnection{name: "memory:a", inbox: newInbox()}
+	b := &MemoryConnection{name: "memory:b", inbox: newInbox()}
+
+	a.peer = b
+	b.peer = a
+
+	return a, b
+}
+
+func (self *MemoryConnection) SendReliable(data []byte) error {
+	return self.send(Message{Data: data, Reliable: true})
+}
+
+func (self *MemoryConnection) SendUnreliable(data []byte) error {
+	message := Message{Data: data}
+
+	if self.Drop != nil && self.Drop(data) {
+		if self.inbox.isClosed() {
+			return ErrConnectionClosed
+		}
+		self.inbox.stats.sent(message)
+		return nil
+	}
+
+	return self.send(message)
+}
+
+func (self *MemoryConnection) send(message Message) error {
+	if self.inbox.isClosed() || self.peer.inbox.isClosed() {
+		return ErrConnectionClosed
+	}
+
+	self.inbox.stats.sent(message)
+
+	// the receiver falling behind looks like a lost packet to the sender.
+	self.peer.inbox.push(message)
+
+	return nil
+}
+
+func (self *MemoryConnection) Receive() <-chan Message {
+	return self.inbox.messages
+}
+
+func (self *MemoryConnection) UnreliableOpen() bool {
+	return !self.inbox.isClosed()
+}
+
+func (self *MemoryConnection) RemoteAddr() string {
+	return self.peer.name
+}
+
+func (self *MemoryConnection) Stats() ConnectionStats {
+	return self.inbox.stats.snapshot()
+}
+
+// Closes both ends.
+func (self *MemoryConnection) Close() error {
+	self.inbox.close()
+	self.peer.inbox.close()
+	return nil
+}
+
+// Hands out the server end of a memory connection for every Dial.
+type MemoryTransport struct {
+	dials  chan *MemoryConnection
+	closed chan struct{}
+	once   sync.Once
+}
+
+func NewMemoryTransport() *MemoryTransport {
+	return &MemoryTransport{
+		dials:  make(chan *MemoryConnection),
+		closed: make(chan struct{}),
+	}
+}
+
+func (self *MemoryTransport) Serve(accept func(Connection)) error {
+	for {
+		select {
+		case connection := <-self.dials:
+			accept(connection)
+		case <-self.closed:
+			return nil
+		}
+	}
+}
+
+// Connects to the transport and returns the client end once Serve has taken the server end.
+func (self *MemoryTransport) Dial() (*MemoryConnection, error) {
+	clientEnd, serverEnd := NewMemoryConnectionPair()
+
+	select {
+	case self.dials <- serverEnd:
+		return clientEnd, nil
+	case <-self.closed:
+		return nil, ErrConnectionClosed
+	}
+}
+
+func (self *MemoryTransport) Close() error {
+	self.once.Do(func() {
+		close(self.closed)
+	})
+	return nil
+}
@@ -0,0 +1,295 @@
+package server
+
+import (
+	"encoding/json"
+	. "github.com/Banyango/io-engine/src/ecs"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// next message of the kind from the connection, skipping the others.
+func receiveMessage(t *testing.T, connection Connection, reliable bool) []byte {
+	timeout := time.After(time.Second)
+
+	for {
+		select {
+		case message, ok := <-connection.Receive():
+			if !ok {
+				t.Fatal("connection closed")
+			}
+			if message.Reliable == reliable {
+				return message.Data
+			}
+		case <-timeout:
+			t.Fatal("no message received")
+		}
+	}
+}
+
+// Plays a client through the handshake, spawn, input and world state, then disconnects it.
+func testClientSession(t *testing.T, gameServer *Server, client Connection) {
+	tick := func() {
+		gameServer.HandleIncomingData(FIXED_DELTA)
+		gameServer.World.Update(FIXED_DELTA)
+		gameServer.SendNetworkData(FIXED_DELTA)
+	}
+
+	tick()
+
+	var handshake ServerConnectionHandshakePacket
+	assert.NoError(t, json.Unmarshal(receiveMessage(t, client, true), &handshake))
+	assert.Equal(t, PlayerId(0), handshake.PlayerId)
+
+	assert.NoError(t, client.SendReliable([]byte(`{"event":"spawn"}`)))
+
+	deadline := time.Now().Add(time.Second)
+	for len(gameServer.World.Entities) == 0 && time.Now().Before(deadline) {
+		tick()
+	}
+	assert.Equal(t, 1, len(gameServer.World.Entities))
+
+	inputTick := gameServer.World.CurrentTick + 2
+
+	packet, err := ClientPacket{Tick: inputTick, Inputs: []NetworkInput{{Buttons: []byte{0x1}}}}.MarshalBinary()
+	assert.NoError(t, err)
+	assert.NoError(t, client.SendUnreliable(packet))
+
+	// the read pump hands it to the tick goroutine.
+	clientConn := gameServer.ConnectedClients()[0]
+	for deadline := time.Now().Add(time.Second); len(clientConn.UdpIn) == 0 && time.Now().Before(deadline); {
+		time.Sleep(time.Millisecond)
+	}
+
+	var state ClientWorldStatePacket
+	for deadline := time.Now().Add(time.Second); state.InputAck != inputTick && time.Now().Before(deadline); {
+		tick()
+
+		select {
+		case message := <-client.Receive():
+			if !message.Reliable {
+				assert.NoError(t, state.UnmarshalBinary(message.Data))
+			}
+		default:
+		}
+	}
+	assert.Equal(t, inputTick, state.InputAck)
+
+	assert.NoError(t, client.Close())
+
+	for deadline := time.Now().Add(time.Second); len(gameServer.ConnectedClients()) > 0 && time.Now().Before(deadline); {
+		time.Sleep(time.Millisecond)
+	}
+	assert.Equal(t, 0, len(gameServer.ConnectedClients()))
+
+	tick()
+	tick()
+
+	assert.Equal(t, 0, len(gameServer.World.Entities))
+	assert.Nil(t, gameServer.World.InputForPlayer(handshake.PlayerId))
+}
+
+func TestMemoryConnection(t *testing.T) {
+	a, b := NewMemoryConnectionPair()
+
+	a.Drop = func(data []byte) bool {
+		return data[0]%2 == 1
+	}
+
+	assert.NoError(t, a.SendReliable([]byte{1}))
+	for i := 0; i < 4; i++ {
+		assert.NoError(t, a.SendUnreliable([]byte{byte(i)}))
+	}
+
+	assert.Equal(t, Message{Data: []byte{1}, Reliable: true}, <-b.Receive())
+	assert.Equal(t, Message{Data: []byte{0}}, <-b.Receive())
+	assert.Equal(t, Message{Data: []byte{2}}, <-b.Receive())
+
+	assert.Equal(t, ConnectionStats{ReliableSent: 1, UnreliableSent: 4, BytesSent: 5}, a.Stats())
+	assert.Equal(t, ConnectionStats{ReliableReceived: 1, UnreliableReceived: 2, BytesReceived: 3}, b.Stats())
+
+	// a reader that falls behind loses unreliable messages.
+	for i := 0; i < RECEIVE_BUFFER_SIZE+1; i++ {
+		assert.NoError(t, b.SendUnreliable([]byte{0}))
+	}
+	assert.Equal(t, int64(1), a.Stats().Dropped)
+
+	assert.NoError(t, b.Close())
+	assert.NoError(t, a.Close())
+
+	assert.False(t, a.UnreliableOpen())
+	assert.Equal(t, ErrConnectionClosed, a.SendReliable([]byte{1}))
+
+	count := 0
+	for range a.Receive() {
+		count++
+	}
+	assert.Equal(t, RECEIVE_BUFFER_SIZE, count)
+}
+
+// A client plays a whole session through the memory transport, no browser needed.
+func TestServer_ClientOverMemoryTransport(t *testing.T) {
+	gameServer := createTestServer()
+
+	transport := NewMemoryTransport()
+	defer transport.Close()
+
+	go gameServer.Listen(transport)
+
+	client, err := transport.Dial()
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	testClientSession(t, gameServer, client)
+}
+
+// The client never opens a data channel, after the timeout the server falls back to the websocket and
+// world state and input use it with the same packets.
+func TestServer_FallsBackToWebsocket(t *testing.T) {
+	gameServer := createTestServer()
+	gameServer.DataChannelTimeout = 20 * time.Millisecond
+
+	httpServer := httptest.NewServer(http.HandlerFunc(gameServer.Ws))
+	defer httpServer.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(httpServer.URL, "http"), nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer conn.Close()
+
+	messages := make(chan []byte, 64)
+	go func() {
+		for {
+			_, message, err := conn.ReadMessage()
+			if err != nil {
+				close(messages)
+				return
+			}
+			messages <- message
+		}
+	}()
+
+	tick := func() {
+		gameServer.HandleIncomingData(FIXED_DELTA)
+		gameServer.World.Update(FIXED_DELTA)
+		gameServer.SendNetworkData(FIXED_DELTA)
+		time.Sleep(time.Millisecond)
+	}
+
+	// runs ticks until a message matches.
+	await := func(match func(message []byte) bool) bool {
+		for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); {
+			tick()
+
+			select {
+			case message := <-messages:
+				if match(message) {
+					return true
+				}
+			default:
+			}
+		}
+		return false
+	}
+
+	assert.True(t, await(func(message []byte) bool {
+		return string(message) == `{"fallback":true}`
+	}))
+
+	clients := gameServer.ConnectedClients()
+	if !assert.Equal(t, 1, len(clients)) {
+		return
+	}
+	assert.True(t, clients[0].IsDataChannelOpen())
+
+	inputTick := gameServer.World.CurrentTick + 50
+
+	packet, err := ClientPacket{Tick: inputTick, Inputs: []NetworkInput{{Buttons: []byte{0x1}}}}.MarshalBinary()
+	assert.NoError(t, err)
+	assert.NoError(t, conn.WriteMessage(websocket.BinaryMessage, append([]byte{WEBSOCKET_UNRELIABLE_PREFIX}, packet...)))
+
+	assert.True(t, await(func(message []byte) bool {
+		if message[0] != WEBSOCKET_UNRELIABLE_PREFIX {
+			return false
+		}
+
+		var state ClientWorldStatePacket
+		assert.NoError(t, state.UnmarshalBinary(message[1:]))
+
+		return state.InputAck == inputTick
+	}))
+}
+
+func TestServer_ClientFallsBackToWebsocket(t *testing.T) {
+	gameServer := createTestServer()
+
+	httpServer := httptest.NewServer(http.HandlerFunc(gameServer.Ws))
+	defer httpServer.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(httpServer.URL, "http"), nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer conn.Close()
+
+	assert.NoError(t, conn.WriteMessage(websocket.BinaryMessage, []byte(`{"fallback":true}`)))
+
+	open := false
+	for deadline := time.Now().Add(time.Second); !open && time.Now().Before(deadline); {
+		for _, client := range gameServer.ConnectedClients() {
+			open = client.IsDataChannelOpen()
+		}
+		time.Sleep(time.Millisecond)
+	}
+	assert.True(t, open)
+}
+
+func TestServer_FallbackNeverBlocksOnAStalledClient(t *testing.T) {
+	gameServer := createTestServer()
+
+	httpServer := httptest.NewServer(http.HandlerFunc(gameServer.Ws))
+	defer httpServer.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(httpServer.URL, "http"), nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer conn.Close()
+
+	assert.NoError(t, conn.WriteMessage(websocket.BinaryMessage, []byte(`{"fallback":true}`)))
+
+	var client *ClientConnection
+	for deadline := time.Now().Add(time.Second); client == nil && time.Now().Before(deadline); {
+		for _, connected := range gameServer.ConnectedClients() {
+			if connected.IsDataChannelOpen() {
+				client = connected
+			}
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if !assert.NotNil(t, client, "client didn't fall back") {
+		return
+	}
+
+	// the client never reads, the socket buffers fill up long before this is sent.
+	sent := make(chan struct{})
+	go func() {
+		packet := make([]byte, 64*1024)
+		for i := 0; i < 1000; i++ {
+			client.SendUnreliable(packet)
+		}
+		close(sent)
+	}()
+
+	select {
+	case <-sent:
+	case <-time.After(time.Second):
+		assert.Fail(t, "SendUnreliable blocked on a client that doesn't read")
+	}
+}
@@ -0,0 +1,604 @@
+package server
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+/*
+----------------------------------------------------------------------------------------------------------------
+UDP transport
+----------------------------------------------------------------------------------------------------------------
+*/
+
+/**
+UDP transport
+
+For native clients, bots and load tests that can't do WebRTC. Every packet starts with its type, the
+ones after the handshake carry the session token the server handed out so a client that changes
+address keeps its session:
+
+	connect     | nonce | game data version      client, resent until it's answered
+	accept      | nonce | token                  server
+	refuse      | nonce | reason                 server
+	reliable    | token | sequence | data
+	ack         | token | next sequence expected
+	unreliable  | token | data
+	keepalive   | token
+	disconnect  | token
+
+Nonces and tokens are 8 bytes, sequences are unsigned varints. Reliable messages are resent every
+UDP_RESEND_INTERVAL until they're acked and the receiver only takes the next one in order. Either side
+sends a keepalive when it hasn't sent anything for UDP_KEEPALIVE_INTERVAL and closes the connection
+when it hasn't heard anything for UDP_TIMEOUT.
+*/
+
+const (
+	udpConnect byte = iota
+	udpAccept
+	udpRefuse
+	udpReliable
+	udpAck
+	udpUnreliable
+	udpKeepalive
+	udpDisconnect
+)
+
+const (
+	UDP_RESEND_INTERVAL    = 100 * time.Millisecond
+	UDP_KEEPALIVE_INTERVAL = time.Second
+	UDP_TIMEOUT            = 10 * time.Second
+)
+
+// Largest message that fits a datagram with its header.
+const UDP_MAX_MESSAGE_SIZE = 65000
+
+// Reliable messages that can wait for an ack before sending fails.
+const UDP_MAX_UNACKED = 256
+
+const udpReadBufferSize = 65536
+
+// Token of a session, or the nonce of a connect.
+const udpIdSize = 8
+
+type udpMessage struct {
+	sequence uint64
+	data     []byte
+	sent     time.Time
+}
+
+// One end of a UDP session, made by the UDPTransport for each client and by DialUDP on the client.
+type UDPConnection struct {
+	// drops outgoing packets it returns true for, to simulate packet loss. Set it before sending.
+	Drop func(packet []byte) bool
+
+	socket *net.UDPConn
+	token  uint64
+	inbox  *inbox
+
+	mux          sync.Mutex
+	addr         *net.UDPAddr
+	nextSequence uint64
+	unacked      []udpMessage
+	expected     uint64
+	lastSent     time.Time
+	lastReceived time.Time
+	onClose      func()
+	closeOnce    sync.Once
+}
+
+func newUDPConnection(socket *net.UDPConn, addr *net.UDPAddr, token uint64, onClose func()) *UDPConnection {
+	return &UDPConnection{
+		socket:       socket,
+		token:        token,
+		inbox:        newInbox(),
+		addr:         addr,
+		lastSent:     time.Now(),
+		lastReceived: time.Now(),
+		onClose:      onClose,
+	}
+}
+
+func (self *UDPConnection) SendReliable(data []byte) error {
+	if len(data) > UDP_MAX_MESSAGE_SIZE {
+		return fmt.Errorf("%d bytes is more than the %d a udp message can have", len(data), UDP_MAX_MESSAGE_SIZE)
+	}
+
+	self.mux.Lock()
+	defer self.mux.Unlock()
+
+	if self.inbox.isClosed() {
+		return ErrConnectionClosed
+	}
+
+	if len(self.unacked) >= UDP_MAX_UNACKED {
+		return fmt.Errorf("%d reliable messages are waiting for an ack", len(self.unacked))
+	}
+
+	message := udpMessage{sequence: self.nextSequence, data: data, sent: time.Now()}
+	self.nextSequence++
+
+	self.unacked = append(self.unacked, message)
+	self.write(self.reliablePacket(message))
+
+	self.inbox.stats.sent(Message{Data: data, Reliable: true})
+
+	return nil
+}
+
+func (self *UDPConnection) SendUnreliable(data []byte) error {
+	if len(data) > UDP_MAX_MESSAGE_SIZE {
+		return fmt.Errorf("%d bytes is more than the %d a udp message can have", len(data), UDP_MAX_MESSAGE_SIZE)
+	}
+
+	self.mux.Lock()
+	defer self.mux.Unlock()
+
+	if self.inbox.isClosed() {
+		return ErrConnectionClosed
+	}
+
+	self.write(append(self.header(udpUnreliable), data...))
+
+	self.inbox.stats.sent(Message{Data: data})
+
+	return nil
+}
+
+func (self *UDPConnection) Receive() <-chan Message {
+	return self.inbox.messages
+}
+
+func (self *UDPConnection) UnreliableOpen() bool {
+	return !self.inbox.isClosed()
+}
+
+func (self *UDPConnection) RemoteAddr() string {
+	self.mux.Lock()
+	defer self.mux.Unlock()
+	return self.addr.String()
+}
+
+func (self *UDPConnection) Stats() ConnectionStats {
+	return self.inbox.stats.snapshot()
+}
+
+// Tells the other side, it isn't resent so the other side may only notice by timing out.
+func (self *UDPConnection) Close() error {
+	self.closeOnce.Do(func() {
+		self.mux.Lock()
+		self.write(self.header(udpDisconnect))
+		self.mux.Unlock()
+
+		self.inbox.close()
+
+		if self.onClose != nil {
+			self.onClose()
+		}
+	})
+
+	return nil
+}
+
+// Handles a packet of the session, payload is what follows the token.
+func (self *UDPConnection) handle(kind byte, payload []byte, addr *net.UDPAddr) {
+	self.mux.Lock()
+
+	self.lastReceived = time.Now()
+
+	// the token proves it's the same client.
+	self.addr = addr
+
+	switch kind {
+	case udpReliable:
+		reader := NewNetworkReader(payload)
+		sequence := reader.Uint()
+		data := reader.Rest()
+
+		if reader.Err() != nil {
+			break
+		}
+
+		// a full inbox doesn't take it, the other side resends it.
+		if sequence == self.expected && self.inbox.tryPush(Message{Data: append([]byte{}, data...), Reliable: true}) {
+			self.expected++
+		}
+
+		// repeats are acked too, the ack may have been lost.
+		ack := NetworkWriter{data: self.header(udpAck)}
+		ack.Uint(self.expected)
+		self.write(ack.Data())
+
+	case udpAck:
+		reader := NewNetworkReader(payload)
+		next := reader.Uint()
+
+		if reader.Done() != nil {
+			break
+		}
+
+		unacked := self.unacked[:0]
+		for _, message := range self.unacked {
+			if message.sequence >= next {
+				unacked = append(unacked, message)
+			}
+		}
+		self.unacked = unacked
+
+	case udpUnreliable:
+		self.inbox.tryPush(Message{Data: append([]byte{}, payload...)})
+
+	case udpDisconnect:
+		self.mux.Unlock()
+		self.Close()
+		return
+	}
+
+	self.mux.Unlock()
+}
+
+// Resends unacked messages, keeps the session alive and closes it once the other side went quiet.
+func (self *UDPConnection) update(now time.Time) {
+	self.mux.Lock()
+
+	if now.Sub(self.lastReceived) > UDP_TIMEOUT {
+		self.mux.Unlock()
+		fmt.Println("UDP connection timed out", self.RemoteAddr())
+		self.Close()
+		return
+	}
+
+	for i := range self.unacked {
+		if now.Sub(self.unacked[i].sent) >= UDP_RESEND_INTERVAL {
+			self.unacked[i].sent = now
+			self.write(self.reliablePacket(self.unacked[i]))
+		}
+	}
+
+	if now.Sub(self.lastSent) >= UDP_KEEPALIVE_INTERVAL {
+		self.write(self.header(udpKeepalive))
+	}
+
+	self.mux.Unlock()
+}
+
+// Call with mux held.
+func (self *UDPConnection) write(packet []byte) {
+	self.lastSent = time.Now()
+
+	if self.Drop != nil && self.Drop(packet) {
+		return
+	}
+
+	if _, err := self.socket.WriteToUDP(packet, self.addr); err != nil {
+		fmt.Println("Error writing udp packet", self.addr, err)
+	}
+}
+
+func (self *UDPConnection) header(kind byte) []byte {
+	packet := make([]byte, 1+udpIdSize, 16)
+	packet[0] = kind
+	binary.BigEndian.PutUint64(packet[1:], self.token)
+	return packet
+}
+
+func (self *UDPConnection) reliablePacket(message udpMessage) []byte {
+	writer := NetworkWriter{data: self.header(udpReliable)}
+	writer.Uint(message.sequence)
+	writer.Raw(message.data)
+	return writer.Data()
+}
+
+// Handles the session's packets until the socket is closed, for the client that owns it.
+func (self *UDPConnection) readLoop() {
+	buf := make([]byte, udpReadBufferSize)
+
+	for {
+		n, addr, err := self.socket.ReadFromUDP(buf)
+
+		if err != nil {
+			if self.inbox.isClosed() {
+				return
+			}
+			continue
+		}
+
+		if n < 1+udpIdSize || binary.BigEndian.Uint64(buf[1:]) != self.token {
+			continue
+		}
+
+		self.handle(buf[0], buf[1+udpIdSize:n], addr)
+	}
+}
+
+func (self *UDPConnection) updateLoop() {
+	ticker := time.NewTicker(UDP_RESEND_INTERVAL / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			self.update(now)
+		case <-self.inbox.closed:
+			return
+		}
+	}
+}
+
+// Serves native clients on a UDP socket.
+type UDPTransport struct {
+	// game data version clients must connect with, any version is accepted if nil.
+	Version func() string
+
+	socket   *net.UDPConn
+	mux      sync.Mutex
+	sessions map[uint64]*UDPConnection
+	connects map[string]*UDPConnection // by client address and nonce, to answer repeated connects
+	closed   chan struct{}
+	once     sync.Once
+}
+
+// Binds the socket, clients can connect once Serve runs.
+func ListenUDP(address string) (*UDPTransport, error) {
+	addr, err := net.ResolveUDPAddr("udp", address)
+
+	if err != nil {
+		return nil, err
+	}
+
+	socket, err := net.ListenUDP("udp", addr)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &UDPTransport{
+		socket:   socket,
+		sessions: map[uint64]*UDPConnection{},
+		connects: map[string]*UDPConnection{},
+		closed:   make(chan struct{}),
+	}, nil
+}
+
+func (self *UDPTransport) Addr() net.Addr {
+	return self.socket.LocalAddr()
+}
+
+func (self *UDPTransport) Serve(accept func(Connection)) error {
+	go self.updateLoop()
+
+	buf := make([]byte, udpReadBufferSize)
+
+	for {
+		n, addr, err := self.socket.ReadFromUDP(buf)
+
+		if err != nil {
+			select {
+			case <-self.closed:
+				return nil
+			default:
+			}
+
+			// e.g. an icmp port unreachable from a client that's gone.
+			fmt.Println("Error reading udp packet", err)
+			continue
+		}
+
+		self.handlePacket(buf[:n], addr, accept)
+	}
+}
+
+// Closes every session and the socket.
+func (self *UDPTransport) Close() error {
+	var err error
+
+	self.once.Do(func() {
+		close(self.closed)
+
+		for _, connection := range self.connections() {
+			connection.Close()
+		}
+
+		err = self.socket.Close()
+	})
+
+	return err
+}
+
+func (self *UDPTransport) handlePacket(packet []byte, addr *net.UDPAddr, accept func(Connection)) {
+	if len(packet) < 1+udpIdSize {
+		return
+	}
+
+	if packet[0] == udpConnect {
+		self.handleConnect(packet[1:1+udpIdSize], string(packet[1+udpIdSize:]), addr, accept)
+		return
+	}
+
+	self.mux.Lock()
+	connection := self.sessions[binary.BigEndian.Uint64(packet[1:])]
+	self.mux.Unlock()
+
+	if connection != nil {
+		connection.handle(packet[0], packet[1+udpIdSize:], addr)
+	}
+}
+
+func (self *UDPTransport) handleConnect(nonce []byte, version string, addr *net.UDPAddr, accept func(Connection)) {
+	if self.Version != nil && version != self.Version() {
+		fmt.Println("Refusing udp client with game data version", version)
+		reason := fmt.Sprintf("game data version %q doesn't match the server's %q", version, self.Version())
+		self.reply(append(append([]byte{udpRefuse}, nonce...), reason...), addr)
+		return
+	}
+
+	key := addr.String() + string(nonce)
+
+	self.mux.Lock()
+
+	connection, exists := self.connects[key]
+
+	if !exists {
+		token := self.newToken()
+
+		connection = newUDPConnection(self.socket, addr, token, func() {
+			self.mux.Lock()
+			delete(self.sessions, token)
+			delete(self.connects, key)
+			self.mux.Unlock()
+		})
+
+		self.sessions[token] = connection
+		self.connects[key] = connection
+	}
+
+	self.mux.Unlock()
+
+	// a repeated connect means the accept was lost.
+	acceptPacket := make([]byte, 1+2*udpIdSize)
+	acceptPacket[0] = udpAccept
+	copy(acceptPacket[1:], nonce)
+	binary.BigEndian.PutUint64(acceptPacket[1+udpIdSize:], connection.token)
+	self.reply(acceptPacket, addr)
+
+	if !exists {
+		accept(connection)
+	}
+}
+
+func (self *UDPTransport) reply(packet []byte, addr *net.UDPAddr) {
+	if _, err := self.socket.WriteToUDP(packet, addr); err != nil {
+		fmt.Println("Error writing udp packet", addr, err)
+	}
+}
+
+// Call with mux held.
+func (self *UDPTransport) newToken() uint64 {
+	for {
+		token := binary.BigEndian.Uint64(randomId())
+
+		if _, ok := self.sessions[token]; !ok {
+			return token
+		}
+	}
+}
+
+func (self *UDPTransport) connections() []*UDPConnection {
+	self.mux.Lock()
+	defer self.mux.Unlock()
+
+	connections := make([]*UDPConnection, 0, len(self.sessions))
+	for _, connection := range self.sessions {
+		connections = append(connections, connection)
+	}
+
+	return connections
+}
+
+func (self *UDPTransport) updateLoop() {
+	ticker := time.NewTicker(UDP_RESEND_INTERVAL / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			for _, connection := range self.connections() {
+				connection.update(now)
+			}
+		case <-self.closed:
+			return
+		}
+	}
+}
+
+// Connects to a UDPTransport with the game data version the client loaded. Blocks until the server
+// accepts or refuses the client, or the timeout passes.
+func DialUDP(address string, version string, timeout time.Duration) (*UDPConnection, error) {
+	remote, err := net.ResolveUDPAddr("udp", address)
+
+	if err != nil {
+		return nil, err
+	}
+
+	socket, err := net.ListenUDP("udp", nil)
+
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := randomId()
+	connect := append(append([]byte{udpConnect}, nonce...), version...)
+
+	buf := make([]byte, udpReadBufferSize)
+	deadline := time.Now().Add(timeout)
+
+	for time.Now().Before(deadline) {
+		if _, err := socket.WriteToUDP(connect, remote); err != nil {
+			socket.Close()
+			return nil, err
+		}
+
+		wait := time.Now().Add(UDP_RESEND_INTERVAL)
+		if wait.After(deadline) {
+			wait = deadline
+		}
+
+		socket.SetReadDeadline(wait)
+
+		n, from, err := socket.ReadFromUDP(buf)
+
+		if err != nil {
+			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+				continue
+			}
+			socket.Close()
+			return nil, err
+		}
+
+		if n < 1+udpIdSize || !bytes.Equal(buf[1:1+udpIdSize], nonce) {
+			continue
+		}
+
+		switch buf[0] {
+		case udpAccept:
+			if n < 1+2*udpIdSize {
+				continue
+			}
+
+			socket.SetReadDeadline(time.Time{})
+
+			connection := newUDPConnection(socket, from, binary.BigEndian.Uint64(buf[1+udpIdSize:]), func() {
+				socket.Close()
+			})
+
+			go connection.readLoop()
+			go connection.updateLoop()
+
+			return connection, nil
+
+		case udpRefuse:
+			socket.Close()
+			return nil, fmt.Errorf("server refused the connection: %s", buf[1+udpIdSize:n])
+		}
+	}
+
+	socket.Close()
+
+	return nil, errors.New("udp connect timed out")
+}
+
+func randomId() []byte {
+	id := make([]byte, udpIdSize)
+
+	if _, err := rand.Read(id); err != nil {
+		panic(err)
+	}
+
+	return id
+}
@@ -0,0 +1,93 @@
+package server
+
+import (
+	"github.com/stretchr/testify/assert"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func listenTestUDP(t *testing.T, version string) *UDPTransport {
+	transport, err := ListenUDP("127.0.0.1:0")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	transport.Version = func() string { return version }
+
+	return transport
+}
+
+func TestServer_ClientOverUDP(t *testing.T) {
+	gameServer := createTestServer()
+
+	transport := listenTestUDP(t, "1.2.0")
+	defer transport.Close()
+
+	go gameServer.Listen(transport)
+
+	client, err := DialUDP(transport.Addr().String(), "1.2.0", time.Second)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	testClientSession(t, gameServer, client)
+}
+
+func TestUDPTransport_RefusesOtherDataVersion(t *testing.T) {
+	transport := listenTestUDP(t, "1.2.0")
+	defer transport.Close()
+
+	accepted := make(chan Connection, 1)
+	go transport.Serve(func(connection Connection) {
+		accepted <- connection
+	})
+
+	_, err := DialUDP(transport.Addr().String(), "1.1.0", time.Second)
+
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "1.1.0")
+	}
+
+	assert.Equal(t, 0, len(accepted))
+}
+
+// Reliable messages arrive once and in order when packets are lost both ways.
+func TestUDPConnection_ReliableSurvivesPacketLoss(t *testing.T) {
+	transport := listenTestUDP(t, "")
+	defer transport.Close()
+
+	accepted := make(chan Connection, 1)
+	go transport.Serve(func(connection Connection) {
+		connection.(*UDPConnection).Drop = dropRandomly(1, 0.3)
+		accepted <- connection
+	})
+
+	client, err := DialUDP(transport.Addr().String(), "", time.Second)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer client.Close()
+
+	client.Drop = dropRandomly(2, 0.3)
+
+	serverEnd := <-accepted
+
+	for i := 0; i < 20; i++ {
+		assert.NoError(t, client.SendReliable([]byte{byte(i)}))
+	}
+
+	for i := 0; i < 20; i++ {
+		assert.Equal(t, []byte{byte(i)}, receiveMessage(t, serverEnd, true))
+	}
+
+	assert.Equal(t, int64(20), serverEnd.Stats().ReliableReceived)
+}
+
+func dropRandomly(seed int64, rate float64) func(packet []byte) bool {
+	random := rand.New(rand.NewSource(seed))
+	return func(packet []byte) bool {
+		return random.Float64() < rate
+	}
+}
@@ -1,26 +1,35 @@
 package server
 
 import (
-	"bytes"
-	"encoding/gob"
 	"fmt"
 )
 
-func DecodeNetworkDataBytes(networkPacket *NetworkData, id int, buf interface{}) {
-	reader := bytes.NewReader(networkPacket.Data[id])
-	enc := gob.NewDecoder(reader)
-	err := enc.Decode(buf)
-	if err != nil {
-		fmt.Println("Error Decoding id:", id, " owner:", networkPacket.OwnerId)
+// Reads the data of a component written by WriteComponent. Returns false, without calling read, if the
+// entity has no data for the component, and when the data is broken.
+func (self *NetworkData) ReadComponent(id int, read func(reader *NetworkReader)) bool {
+	data, ok := self.Data[id]
+
+	if !ok {
+		return false
 	}
-}
 
-func EncodeNetworkDataBytes(networkPacket *NetworkData, id int, buf interface{}) {
-	buffer := bytes.Buffer{}
-	enc := gob.NewEncoder(&buffer)
-	err := enc.Encode(buf)
-	if err != nil {
-		fmt.Println("Error encoding id:", id, " owner:", networkPacket.OwnerId, err)
+	reader := NewNetworkReader(data)
+
+	read(reader)
+
+	if err := reader.Done(); err != nil {
+		fmt.Println("Error Decoding id:", id, " owner:", self.OwnerId, err)
+		return false
 	}
-	networkPacket.Data[id] = buffer.Bytes()
+
+	return true
+}
+
+// Sets the data of a component to what write writes.
+func (self *NetworkData) WriteComponent(id int, write func(writer *NetworkWriter)) {
+	writer := NetworkWriter{}
+
+	write(&writer)
+
+	self.Data[id] = writer.Data()
 }
@@ -1,41 +1,37 @@
 package server
 
 import (
-	"bytes"
-	"encoding/gob"
 	"github.com/stretchr/testify/assert"
 	"testing"
 )
 
 func TestEncode(t *testing.T) {
-	netData := NetworkData{NetworkId:0, OwnerId:0}
+	netData := NetworkData{NetworkId: 0, OwnerId: 0}
 
 	netData.Data = map[int][]byte{}
 
-	var data struct{
-		X int
-		Y int
-	}
+	netData.WriteComponent(0, func(writer *NetworkWriter) {
+		writer.Int(33)
+		writer.Int(-23)
+	})
 
-	data.X = 33
-	data.Y = 23
+	var x, y int64
 
-	EncodeNetworkDataBytes(&netData, 0, data)
+	assert.True(t, netData.ReadComponent(0, func(reader *NetworkReader) {
+		x, y = reader.Int(), reader.Int()
+	}))
 
-	var result struct{
-		X int
-		Y int
-	}
+	assert.Equal(t, int64(33), x)
+	assert.Equal(t, int64(-23), y)
 
-	reader := bytes.NewReader(netData.Data[0])
-	enc := gob.NewDecoder(reader)
-	err := enc.Decode(&result)
-	if err != nil {
-		assert.Fail(t, "error decoding")
-	}
+	// reading more than was written is an error.
+	assert.False(t, netData.ReadComponent(0, func(reader *NetworkReader) {
+		reader.Int()
+		reader.Int()
+		reader.Int()
+	}))
 
-	DecodeNetworkDataBytes(&netData, 0, &result)
-
-	assert.Equal(t, result.X, data.X)
-	assert.Equal(t, result.Y, data.Y)
+	assert.False(t, netData.ReadComponent(1, func(reader *NetworkReader) {
+		assert.Fail(t, "no data for component 1")
+	}))
 }
@@ -0,0 +1,406 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/websocket"
+	"github.com/pion/webrtc/v2"
+	"sync"
+	"time"
+)
+
+// How long the data channel has to open before the connection falls back to the websocket.
+const DATA_CHANNEL_TIMEOUT = 5 * time.Second
+
+// First byte of an unreliable packet sent over the websocket.
+const WEBSOCKET_UNRELIABLE_PREFIX byte = 0
+
+// Unreliable packets waiting to be written to the websocket after falling back, more are dropped.
+const WEBSOCKET_UNRELIABLE_BUFFER_SIZE = 64
+
+// How long a websocket write of an unreliable packet can take before the connection is closed.
+const WEBSOCKET_WRITE_TIMEOUT = time.Second
+
+/*
+----------------------------------------------------------------------------------------------------------------
+WebRTC transport
+----------------------------------------------------------------------------------------------------------------
+*/
+
+/**
+WebRTCConnection
+
+The browser connects with a websocket, reliable messages go over it. The client then offers a WebRTC
+data channel through the websocket and the unreliable messages go over the channel once it's open.
+Signaling messages are handled here and never reach Receive.
+
+When the data channel doesn't open within the fallback timeout, e.g. UDP is blocked, either side
+falls back to the websocket and tells the other with a {"fallback":true} signal. Unreliable packets
+then go over the websocket behind WEBSOCKET_UNRELIABLE_PREFIX, reliable messages are json so they
+never start with it. SendUnreliable only queues them for the writer goroutine and drops them when
+it has fallen behind, the same as a lost packet, so a stalled client never blocks the tick.
+
+The websocket is read on its own goroutine and the data channel callbacks run on pion's, both only
+push to the inbox.
+*/
+type WebRTCConnection struct {
+	conn  *websocket.Conn
+	inbox *inbox
+
+	mux            sync.Mutex
+	writeMux       sync.Mutex
+	peerConnection *webrtc.PeerConnection
+	dataChannel    *webrtc.DataChannel
+	open           bool
+	fallback       bool
+	fallbackTimer  *time.Timer
+	unreliableOut  chan []byte
+}
+
+// Starts reading the websocket, the connection closes when it does. It falls back to the websocket
+// if the data channel isn't open after fallbackTimeout.
+func NewWebRTCConnection(conn *websocket.Conn, fallbackTimeout time.Duration) *WebRTCConnection {
+	connection := &WebRTCConnection{
+		conn:          conn,
+		inbox:         newInbox(),
+		unreliableOut: make(chan []byte, WEBSOCKET_UNRELIABLE_BUFFER_SIZE),
+	}
+
+	connection.mux.Lock()
+	connection.fallbackTimer = time.AfterFunc(fallbackTimeout, func() {
+		if !connection.UnreliableOpen() && !connection.inbox.isClosed() {
+			fmt.Println("Data channel didn't open, falling back to the websocket", connection.RemoteAddr())
+			connection.fallBack(true)
+		}
+	})
+	connection.mux.Unlock()
+
+	go connection.readPump()
+	go connection.writeUnreliablePump()
+
+	return connection
+}
+
+func (self *WebRTCConnection) SendReliable(data []byte) error {
+	if self.inbox.isClosed() {
+		return ErrConnectionClosed
+	}
+
+	self.writeMux.Lock()
+	defer self.writeMux.Unlock()
+
+	if err := self.conn.WriteMessage(websocket.BinaryMessage, data); err != nil {
+		return err
+	}
+
+	self.inbox.stats.sent(Message{Data: data, Reliable: true})
+
+	return nil
+}
+
+func (self *WebRTCConnection) SendUnreliable(data []byte) error {
+	self.mux.Lock()
+	channel := self.dataChannel
+	open := self.open
+	fallback := self.fallback
+	self.mux.Unlock()
+
+	if fallback {
+		return self.queueWebsocketUnreliable(data)
+	}
+
+	if channel == nil || !open {
+		return ErrUnreliableNotOpen
+	}
+
+	if err := channel.Send(data); err != nil {
+		return err
+	}
+
+	self.inbox.stats.sent(Message{Data: data})
+
+	return nil
+}
+
+func (self *WebRTCConnection) Receive() <-chan Message {
+	return self.inbox.messages
+}
+
+func (self *WebRTCConnection) UnreliableOpen() bool {
+	self.mux.Lock()
+	defer self.mux.Unlock()
+	return self.open || self.fallback
+}
+
+// Whether unreliable packets go over the websocket.
+func (self *WebRTCConnection) IsFallback() bool {
+	self.mux.Lock()
+	defer self.mux.Unlock()
+	return self.fallback
+}
+
+func (self *WebRTCConnection) RemoteAddr() string {
+	return self.conn.RemoteAddr().String()
+}
+
+func (self *WebRTCConnection) Stats() ConnectionStats {
+	return self.inbox.stats.snapshot()
+}
+
+func (self *WebRTCConnection) Close() error {
+	self.inbox.close()
+
+	self.mux.Lock()
+	peerConnection := self.peerConnection
+	channel := self.dataChannel
+	self.open = false
+	self.fallbackTimer.Stop()
+	self.mux.Unlock()
+
+	if peerConnection != nil {
+		if err := peerConnection.Close(); err != nil {
+			fmt.Println(err)
+		}
+	}
+
+	if channel != nil {
+		if err := channel.Close(); err != nil {
+			fmt.Println(err)
+		}
+	}
+
+	return self.conn.Close()
+}
+
+func (self *WebRTCConnection) setOpen(open bool) {
+	self.mux.Lock()
+	self.open = open
+	self.mux.Unlock()
+}
+
+func (self *WebRTCConnection) readPump() {
+	defer self.Close()
+
+	for {
+		_, message, err := self.conn.ReadMessage()
+
+		if err != nil {
+			return
+		}
+
+		if len(message) > 0 && message[0] == WEBSOCKET_UNRELIABLE_PREFIX {
+			self.inbox.push(Message{Data: message[1:]})
+			continue
+		}
+
+		isSignal, err := self.handleSignal(message)
+
+		if err != nil {
+			fmt.Println("WebRTC signaling failed", self.RemoteAddr(), err)
+			return
+		}
+
+		if !isSignal && !self.inbox.push(Message{Data: message, Reliable: true}) {
+			return
+		}
+	}
+}
+
+// Answers the client's offer and adds its ICE candidates. Returns false for messages that aren't signaling.
+func (self *WebRTCConnection) handleSignal(data []byte) (bool, error) {
+	var signal map[string]interface{}
+
+	if err := json.Unmarshal(data, &signal); err != nil {
+		return false, nil
+	}
+
+	if _, ok := signal["fallback"]; ok {
+		fmt.Println("Client fell back to the websocket", self.RemoteAddr())
+		self.fallBack(false)
+		return true, nil
+	}
+
+	if val, ok := signal["offer"].(string); ok {
+		fmt.Println("Rec Offer: ", val)
+
+		offer := webrtc.SessionDescription{}
+		Decode(val, &offer)
+
+		peerConnection, err := self.createPeerConnection()
+
+		if err != nil {
+			return true, err
+		}
+
+		if err := peerConnection.SetRemoteDescription(offer); err != nil {
+			return true, err
+		}
+
+		answer, err := peerConnection.CreateAnswer(nil)
+
+		if err != nil {
+			return true, err
+		}
+
+		if err := peerConnection.SetLocalDescription(answer); err != nil {
+			return true, err
+		}
+
+		signal["answer"] = Encode(answer)
+		marshal, err := json.Marshal(signal)
+
+		if err != nil {
+			return true, err
+		}
+
+		return true, self.SendReliable(marshal)
+	}
+
+	if val, ok := signal["candidate"].(string); ok {
+		fmt.Println("Adding candidate: ", val)
+
+		self.mux.Lock()
+		peerConnection := self.peerConnection
+		self.mux.Unlock()
+
+		if peerConnection == nil {
+			return true, fmt.Errorf("ice candidate before the offer")
+		}
+
+		candidateInit := webrtc.ICECandidateInit{}
+
+		if err := json.Unmarshal([]byte(val), &candidateInit); err != nil {
+			return true, err
+		}
+
+		return true, peerConnection.AddICECandidate(candidateInit)
+	}
+
+	return false, nil
+}
+
+func (self *WebRTCConnection) createPeerConnection() (*webrtc.PeerConnection, error) {
+	self.mux.Lock()
+	existing := self.peerConnection
+	self.mux.Unlock()
+
+	if existing != nil {
+		return nil, fmt.Errorf("peer connection already created")
+	}
+
+	fmt.Println("Configuring ICE server")
+	config := webrtc.Configuration{
+		ICEServers: []webrtc.ICEServer{
+			{
+				URLs: []string{"stun:stun.l.google.com:19302"},
+			},
+		},
+	}
+
+	peerConnection, err := webrtc.NewPeerConnection(config)
+
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Println("Created Peer Connection")
+
+	// Set the handler for ICE connection state
+	// This will notify you when the peer has connected/disconnected
+	peerConnection.OnICEConnectionStateChange(func(connectionState webrtc.ICEConnectionState) {
+		fmt.Printf("ICE Connection State has changed: %s\n", connectionState.String())
+		if connectionState == webrtc.ICEConnectionStateDisconnected {
+			self.setOpen(false)
+		} else if connectionState == webrtc.ICEConnectionStateConnected {
+			self.setOpen(true)
+		}
+	})
+
+	peerConnection.OnDataChannel(func(d *webrtc.DataChannel) {
+		fmt.Printf("New DataChannel %s %d\n", d.Label(), d.ID())
+
+		self.mux.Lock()
+		self.dataChannel = d
+		self.mux.Unlock()
+
+		d.OnOpen(func() {
+			fmt.Printf("Opened DataChannel %s %d\n", self.RemoteAddr(), d.ID())
+			self.setOpen(true)
+		})
+
+		d.OnMessage(func(msg webrtc.DataChannelMessage) {
+			self.inbox.push(Message{Data: msg.Data})
+		})
+
+		d.OnClose(func() {
+			fmt.Println("Closing data channel ->", self.RemoteAddr())
+		})
+	})
+
+	self.mux.Lock()
+	self.peerConnection = peerConnection
+	self.mux.Unlock()
+
+	return peerConnection, nil
+}
+
+// Sends the unreliable packets over the websocket from now on, notify tells the client to do the same.
+func (self *WebRTCConnection) fallBack(notify bool) {
+	self.mux.Lock()
+	if self.fallback {
+		self.mux.Unlock()
+		return
+	}
+	self.fallback = true
+	self.fallbackTimer.Stop()
+	self.mux.Unlock()
+
+	if notify {
+		if err := self.SendReliable([]byte(`{"fallback":true}`)); err != nil {
+			fmt.Println("Error sending fallback", self.RemoteAddr(), err)
+		}
+	}
+}
+
+// Queues the packet for writeUnreliablePump, it's dropped if the queue is full.
+func (self *WebRTCConnection) queueWebsocketUnreliable(data []byte) error {
+	if self.inbox.isClosed() {
+		return ErrConnectionClosed
+	}
+
+	message := make([]byte, 0, len(data)+1)
+	message = append(message, WEBSOCKET_UNRELIABLE_PREFIX)
+	message = append(message, data...)
+
+	select {
+	case self.unreliableOut <- message:
+	default:
+	}
+
+	return nil
+}
+
+// Writes the queued unreliable packets to the websocket until the connection closes. A write that
+// takes longer than WEBSOCKET_WRITE_TIMEOUT closes the connection.
+func (self *WebRTCConnection) writeUnreliablePump() {
+	for {
+		select {
+		case message := <-self.unreliableOut:
+			self.writeMux.Lock()
+			self.conn.SetWriteDeadline(time.Now().Add(WEBSOCKET_WRITE_TIMEOUT))
+			err := self.conn.WriteMessage(websocket.BinaryMessage, message)
+			self.conn.SetWriteDeadline(time.Time{})
+			self.writeMux.Unlock()
+
+			if err != nil {
+				fmt.Println("Error writing unreliable message", self.RemoteAddr(), err)
+				self.Close()
+				return
+			}
+
+			self.inbox.stats.sent(Message{Data: message[1:]})
+		case <-self.inbox.closed:
+			return
+		}
+	}
+}
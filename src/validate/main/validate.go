@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/Banyango/io-engine/src/ecs"
+	"github.com/Banyango/io-engine/src/game"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// Checks game data before it's shipped.
+//
+//	validate [game.json]          prints every problem with its line and exits 1 if there are any.
+//	validate -schema > schema.json prints the JSON Schema for editors.
+//	validate -migrate [game.json] rewrites game data and scenes in an older format once they're valid.
+func main() {
+
+	printSchema := flag.Bool("schema", false, "print the JSON Schema for game data and exit")
+	migrate := flag.Bool("migrate", false, "rewrite game data and scenes in an older format in the current one")
+	flag.Parse()
+
+	game.RegisterComponents()
+
+	if *printSchema {
+		schema, err := json.MarshalIndent(ecs.GameDataSchema(), "", "  ")
+
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+
+		fmt.Println(string(schema))
+		return
+	}
+
+	path := "./game.json"
+
+	if flag.NArg() > 0 {
+		path = flag.Arg(0)
+	}
+
+	gameJson, err := ioutil.ReadFile(path)
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	if problems := report(path, ecs.ValidateGameData(gameJson)); problems > 0 {
+		fmt.Fprintf(os.Stderr, "%d problem(s)\n", problems)
+		os.Exit(1)
+	}
+
+	// scenes are only checked once the game data is valid.
+	world := ecs.NewWorld()
+	world.StrictLoading = true
+
+	prefabData, err := ecs.NewPrefabManager(string(gameJson), world)
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	problems := 0
+	scenePaths := []string{}
+	names := []string{}
+	for name := range prefabData.Scenes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		scenePath := filepath.Join(filepath.Dir(path), prefabData.Scenes[name])
+		scenePaths = append(scenePaths, scenePath)
+
+		sceneJson, err := ioutil.ReadFile(scenePath)
+
+		if err != nil {
+			problems += report(scenePath, err)
+			continue
+		}
+
+		_, err = prefabData.LoadScene(name, sceneJson)
+
+		problems += report(scenePath, err)
+	}
+
+	if prefabData.Map != "" {
+		mapPath := filepath.Join(filepath.Dir(path), prefabData.Map)
+
+		mapJson, err := ioutil.ReadFile(mapPath)
+
+		if err == nil {
+			_, err = prefabData.LoadTiledMap(prefabData.Map, mapJson)
+		}
+
+		problems += report(mapPath, err)
+	}
+
+	if problems > 0 {
+		fmt.Fprintf(os.Stderr, "%d problem(s)\n", problems)
+		os.Exit(1)
+	}
+
+	fmt.Println(path, "ok")
+
+	if *migrate {
+		migrateFile(path, ecs.MigrateGameData)
+
+		for _, scenePath := range scenePaths {
+			migrateFile(scenePath, ecs.MigrateScene)
+		}
+	}
+}
+
+// Prints the problems of a file and returns how many there were, every LoadError is one and any
+// other error, like a missing file or broken json, is one.
+func report(path string, err error) int {
+	if err == nil {
+		return 0
+	}
+
+	if loadErrors, ok := err.(ecs.LoadErrors); ok {
+		for _, loadError := range loadErrors {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", path, loadError)
+		}
+		return len(loadErrors)
+	}
+
+	fmt.Fprintf(os.Stderr, "%s: %s\n", path, err)
+	return 1
+}
+
+func migrateFile(path string, migrate func([]byte) ([]byte, bool, error)) {
+	data, err := ioutil.ReadFile(path)
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	migrated, ok, err := migrate(data)
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", path, err)
+		os.Exit(1)
+	}
+
+	if !ok {
+		return
+	}
+
+	if err := ioutil.WriteFile(path, migrated, 0644); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	fmt.Println(path, "migrated to format", ecs.GameDataFormat)
+}